@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	file := fs.String("file", "", "reference file to search; synthetic vectors are generated when empty")
+	n := fs.Int("n", 100000, "number of synthetic items")
+	dim := fs.Int("dim", 256, "dimension of synthetic vectors")
+	clusters := fs.Int("clusters", 64, "number of clusters the synthetic vectors are drawn around")
+	queries := fs.Int("queries", 200, "number of queries")
+	k := fs.Int("k", 5, "results per query")
+	ann := fs.Bool("ann", true, "also build and benchmark the HNSW index")
+	quantize := fs.Bool("int8", true, "also benchmark the int8 scan with float32 rescoring")
+	m := fs.Int("m", 0, "HNSW links per node (0 for the default)")
+	efConstruction := fs.Int("ef-construction", 0, "HNSW build candidate list size (0 for the default)")
+	efSearch := fs.Int("ef-search", 0, "HNSW search candidate list size (0 for the default)")
+	seed := fs.Uint64("seed", 1, "random seed")
+	fs.Parse(args)
+
+	rng := rand.New(rand.NewPCG(*seed, 0))
+	var items []similarity.ReferenceData
+	if *file != "" {
+		refFile, err := similarity.LoadReferenceFile(*file)
+		if err != nil {
+			return err
+		}
+		items = refFile.Items
+	} else {
+		items = syntheticItems(rng, *n, *dim, *clusters)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no items to search")
+	}
+	// Queries are perturbed copies of indexed vectors, taken before the index consumes them
+	qs := make([][]float32, *queries)
+	for i := range qs {
+		qs[i] = perturb(rng, items[rng.IntN(len(items))].Embedding, 0.3)
+	}
+
+	// The index takes the embeddings out of its items, so the HNSW index gets its own item slice
+	annItems := append([]similarity.ReferenceData(nil), items...)
+	start := time.Now()
+	idx := similarity.NewReferenceIndex(items, similarity.IndexOptions{Quantize: *quantize})
+	fmt.Printf("Indexed %d items (%d searchable, %d dimensions) in %s\n",
+		len(idx.Items), idx.Searchable(), idx.Dimension(), time.Since(start).Round(time.Millisecond))
+	var annIdx *similarity.ReferenceIndex
+	if *ann {
+		start = time.Now()
+		annIdx = similarity.NewReferenceIndex(annItems, similarity.IndexOptions{
+			ANN:  true,
+			HNSW: similarity.HNSWParams{M: *m, EfConstruction: *efConstruction, EfSearch: *efSearch},
+		})
+		fmt.Printf("Built HNSW index in %s\n", time.Since(start).Round(time.Millisecond))
+	}
+	fmt.Println()
+
+	testing.Init()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "search\tns/query\tqueries/s\t")
+	report := func(name string, search func(q []float32, k int) []similarity.ResultWithScore) {
+		res := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				search(qs[i%len(qs)], *k)
+			}
+		})
+		perOp := res.NsPerOp()
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t\n", name, perOp, 1e9/math.Max(1, float64(perOp)))
+	}
+
+	procs := runtime.GOMAXPROCS(1)
+	report("exact, 1 core", idx.SearchExact)
+	runtime.GOMAXPROCS(procs)
+	if procs > 1 {
+		report(fmt.Sprintf("exact, %d cores", procs), idx.SearchExact)
+	}
+	if *quantize {
+		report("int8 + rescore", idx.Search)
+	}
+	if *ann {
+		report("hnsw", annIdx.Search)
+	}
+	tw.Flush()
+
+	fmt.Println()
+	if *quantize {
+		fmt.Printf("int8 recall@%d: %.3f\n", *k, recall(idx.Search, idx.SearchExact, qs, *k))
+	}
+	if *ann {
+		fmt.Printf("HNSW recall@%d: %.3f\n", *k, recall(annIdx.Search, idx.SearchExact, qs, *k))
+	}
+	return nil
+}
+
+// syntheticItems draws n unit-scale vectors around random cluster centres, which resembles the
+// structure of real embeddings better than uniform noise.
+func syntheticItems(rng *rand.Rand, n, dim, clusters int) []similarity.ReferenceData {
+	centres := make([][]float32, max(clusters, 1))
+	for i := range centres {
+		centres[i] = perturb(rng, make([]float32, dim), 1)
+	}
+	items := make([]similarity.ReferenceData, n)
+	for i := range items {
+		items[i] = similarity.ReferenceData{
+			ID:        fmt.Sprintf("synthetic-%d", i),
+			Embedding: perturb(rng, centres[rng.IntN(len(centres))], 0.5),
+		}
+	}
+	return items
+}
+
+// perturb returns vec plus Gaussian noise of the given scale per component.
+func perturb(rng *rand.Rand, vec []float32, scale float64) []float32 {
+	out := make([]float32, len(vec))
+	for i, v := range vec {
+		out[i] = v + float32(rng.NormFloat64()*scale)
+	}
+	return out
+}
+
+// recall returns the share of exact top-k results that the approximate search also finds.
+func recall(approxSearch, exactSearch func(q []float32, k int) []similarity.ResultWithScore, qs [][]float32, k int) float64 {
+	found, total := 0, 0
+	for _, q := range qs {
+		approx := map[string]bool{}
+		for _, r := range approxSearch(q, k) {
+			approx[r.Data.ID] = true
+		}
+		for _, r := range exactSearch(q, k) {
+			total++
+			if approx[r.Data.ID] {
+				found++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(found) / float64(total)
+}
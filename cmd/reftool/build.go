@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	"github.com/amir-saatchi/rest-api/corelogic/workflows"
+	"golang.org/x/sync/errgroup"
+)
+
+// columnAliases maps normalised spreadsheet headers that differ from the shot keys to the keys.
+var columnAliases = map[string]string{
+	"description":         config.AssetDescription,
+	"assetdesc":           config.AssetDescription,
+	"stride":              config.Threat,
+	"threattype":          config.Threat,
+	"attackpath":          config.AttackSteps,
+	"safety":              config.SafetyImpact,
+	"financial":           config.FinancialImpact,
+	"operational":         config.OperationalImpact,
+	"privacy":             config.PrivacyImpact,
+	"elapsedtime":         config.ET,
+	"specialistexpertise": config.SE,
+	"expertise":           config.SE,
+	"knowledgeoftheitem":  config.KOIC,
+	"knowledgeofitem":     config.KOIC,
+	"windowofopportunity": config.WOO,
+	"equipment":           config.EQ,
+	"damagescenarioid":    config.DamageID,
+	"attackpathid":        config.AttackID,
+	"threatscenarioid":    config.ThreatID,
+	"assetpropertyid":     config.AssetPropertyID,
+}
+
+// columnMappings collects repeated -map "Header=key" flags.
+type columnMappings map[string]string
+
+func (m columnMappings) String() string { return fmt.Sprint(map[string]string(m)) }
+
+func (m columnMappings) Set(v string) error {
+	header, key, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(header) == "" || strings.TrimSpace(key) == "" {
+		return fmt.Errorf(`expected "Column header=shot_key", got %q`, v)
+	}
+	m[normalizeHeader(header)] = strings.TrimSpace(key)
+	return nil
+}
+
+func runBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	analysisType := fs.String("type", "", "analysis type the rows are shots of: "+strings.Join(config.AnalysisTypes(), ", "))
+	out := fs.String("out", "", "reference file to write; a "+similarity.BinaryReferenceExt+" extension writes the binary format, anything else JSON")
+	sheet := fs.String("sheet", "", "XLSX sheet to read (default: the first)")
+	mappings := columnMappings{}
+	fs.Var(mappings, "map", `map a column to a shot key, "Column header=shot_key"; repeatable, overrides the header matching`)
+	merge := fs.Bool("merge", false, "add the rows to the existing -out file instead of replacing it")
+	int8 := fs.Bool("int8", false, "store int8 codes in a binary file")
+	concurrency := fs.Int("concurrency", 4, "embedding requests in flight")
+	strict := fs.Bool("strict", false, "fail on invalid rows instead of skipping them")
+	dryRun := fs.Bool("dry-run", false, "map, validate and deduplicate the rows without embedding or writing")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: reftool build -type <analysis type> -out <file> [flags] <file.csv|file.xlsx>...\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	inputs := fs.Args()
+	if *analysisType == "" || len(inputs) == 0 || (*out == "" && !*dryRun) {
+		fs.Usage()
+		return fmt.Errorf("-type, -out and at least one input file are required")
+	}
+	cfg, err := config.AnalysisConfig(*analysisType)
+	if err != nil {
+		return err
+	}
+
+	lib := newLibraryBuilder(cfg)
+	if *merge {
+		if err := lib.loadExisting(*out); err != nil {
+			return err
+		}
+	}
+	invalid := 0
+	for _, in := range inputs {
+		n, err := lib.addTable(in, *sheet, mappings, *strict)
+		if err != nil {
+			return err
+		}
+		invalid += n
+	}
+	fmt.Printf("%d new items, %d duplicates skipped, %d invalid rows skipped\n", len(lib.pending), lib.duplicates, invalid)
+	if *dryRun || len(lib.pending) == 0 {
+		if len(lib.pending) == 0 && !*dryRun {
+			return fmt.Errorf("no new items to write")
+		}
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := lib.embed(ctx, *concurrency); err != nil {
+		return err
+	}
+	file := &similarity.ReferenceFile{Metadata: lib.metadata, Items: lib.items}
+	if filepath.Ext(*out) == similarity.BinaryReferenceExt {
+		err = similarity.WriteBinaryReferenceFile(*out, file, similarity.BinaryOptions{Int8: *int8})
+	} else {
+		err = similarity.WriteReferenceFile(*out, file)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d items to %s\n", len(lib.items), *out)
+	return nil
+}
+
+// libraryBuilder accumulates the deduplicated, validated items of one reference library.
+type libraryBuilder struct {
+	cfg        *config.ModelConfig
+	idKey      string
+	keys       []string // Shot and embed keys, the columns a row is mapped to
+	metadata   *similarity.ReferenceMetadata
+	embedding  similarity.EmbeddingSpec
+	items      []similarity.ReferenceData
+	pending    []int             // Indexes of items that still need an embedding
+	seen       map[string]string // Content key -> item ID
+	ids        map[string]bool
+	duplicates int
+}
+
+func newLibraryBuilder(cfg *config.ModelConfig) *libraryBuilder {
+	spec := similarity.ConfiguredEmbedding()
+	keys := slices.Clone(cfg.ShotsKeys)
+	for _, k := range cfg.EmbedKeys {
+		if !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	return &libraryBuilder{
+		cfg:       cfg,
+		idKey:     cfg.ShotIDKey(),
+		keys:      keys,
+		metadata:  &similarity.ReferenceMetadata{EmbedKeys: cfg.EmbedKeys, Model: spec.Model, Dimensions: spec.Dimensions},
+		embedding: spec,
+		seen:      map[string]string{},
+		ids:       map[string]bool{},
+	}
+}
+
+// loadExisting starts from the items of an existing library, so rows already in it are skipped.
+func (lib *libraryBuilder) loadExisting(filePath string) error {
+	file, err := similarity.LoadReferenceFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if file.Metadata != nil && !slices.Equal(file.Metadata.EmbedKeys, lib.cfg.EmbedKeys) {
+		return fmt.Errorf("%s was embedded with keys %v, %s uses %v; rebuild it instead of merging", filePath, file.Metadata.EmbedKeys, lib.cfg.AnalysisType, lib.cfg.EmbedKeys)
+	}
+	if spec := file.Metadata.Embedding(); spec != lib.embedding {
+		return fmt.Errorf("%s was embedded with %s, the configured embedding is %s; re-embed it with \"reftool reembed\" first", filePath, spec, lib.embedding)
+	}
+	if file.Metadata != nil {
+		lib.metadata = file.Metadata
+		lib.metadata.Model, lib.metadata.Dimensions = lib.embedding.Model, lib.embedding.Dimensions
+	}
+	for _, item := range file.Items {
+		lib.seen[lib.contentKey(similarity.ShotValues(item))] = item.ID
+		lib.ids[item.ID] = true
+	}
+	lib.items = file.Items
+	return nil
+}
+
+// addTable adds the rows of one spreadsheet and returns the number of invalid rows.
+func (lib *libraryBuilder) addTable(filePath, sheet string, mappings columnMappings, strict bool) (int, error) {
+	rows, err := readTable(filePath, sheet)
+	if err != nil {
+		return 0, err
+	}
+	headerRow := slices.IndexFunc(rows, func(row []string) bool { return !isBlankRow(row) })
+	if headerRow < 0 {
+		return 0, fmt.Errorf("%s: no rows", filePath)
+	}
+	columns, err := lib.mapColumns(filePath, rows[headerRow], mappings)
+	if err != nil {
+		return 0, err
+	}
+
+	invalid := 0
+	for i, row := range rows[headerRow+1:] {
+		if isBlankRow(row) {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", filePath, headerRow+i+2)
+		shot := map[string]any{}
+		for col, key := range columns {
+			if key != "" && col < len(row) {
+				if v := strings.TrimSpace(row[col]); v != "" {
+					shot[key] = v
+				}
+			}
+		}
+		if problems := workflows.CheckReferenceShot(lib.cfg, shot); len(problems) > 0 {
+			if strict {
+				return invalid, fmt.Errorf("%s: %s", where, strings.Join(problems, "; "))
+			}
+			log.Printf("Skipping %s: %s", where, strings.Join(problems, "; "))
+			invalid++
+			continue
+		}
+		if err := lib.add(shot, where); err != nil {
+			return invalid, err
+		}
+	}
+	return invalid, nil
+}
+
+// mapColumns returns the shot key of every column, "" for columns that aren't used.
+func (lib *libraryBuilder) mapColumns(filePath string, header []string, mappings columnMappings) ([]string, error) {
+	byName := map[string]string{}
+	for _, k := range lib.keys {
+		byName[normalizeHeader(k)] = k
+	}
+	for alias, k := range columnAliases {
+		if slices.Contains(lib.keys, k) {
+			byName[alias] = k
+		}
+	}
+	for header, k := range mappings {
+		if !slices.Contains(lib.keys, k) {
+			return nil, fmt.Errorf("-map: %q is not a shot key of %s; keys are %q", k, lib.cfg.AnalysisType, lib.keys)
+		}
+		byName[header] = k
+	}
+
+	columns := make([]string, len(header))
+	mapped := map[string]bool{}
+	var ignored []string
+	for i, h := range header {
+		k := byName[normalizeHeader(h)]
+		if k == "" || mapped[k] {
+			if strings.TrimSpace(h) != "" {
+				ignored = append(ignored, h)
+			}
+			continue
+		}
+		columns[i] = k
+		mapped[k] = true
+	}
+	var missing []string
+	for _, k := range lib.keys {
+		if !mapped[k] && k != lib.idKey {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s: no column for %q; name the columns with -map \"Column header=shot_key\"", filePath, missing)
+	}
+	if len(ignored) > 0 {
+		log.Printf("%s: ignoring columns %q", filePath, ignored)
+	}
+	return columns, nil
+}
+
+// add records a validated shot unless an item with the same content exists.
+func (lib *libraryBuilder) add(shot map[string]any, where string) error {
+	key := lib.contentKey(shot)
+	if id, ok := lib.seen[key]; ok {
+		log.Printf("Skipping %s: duplicate of %s", where, id)
+		lib.duplicates++
+		return nil
+	}
+
+	id, _ := shot[lib.idKey].(string)
+	if id == "" {
+		sum := sha256.Sum256([]byte(key))
+		id = strings.TrimSuffix(lib.idKey, "_id") + "-" + hex.EncodeToString(sum[:5])
+	}
+	for base, n := id, 2; lib.ids[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	if lib.idKey != "" {
+		shot[lib.idKey] = id
+	}
+
+	b, err := json.Marshal(shot)
+	if err != nil {
+		return err
+	}
+	var item similarity.ReferenceData
+	if err := json.Unmarshal(b, &item); err != nil {
+		return fmt.Errorf("%s: %w", where, err)
+	}
+	item.ID = id
+	lib.seen[key] = id
+	lib.ids[id] = true
+	lib.pending = append(lib.pending, len(lib.items))
+	lib.items = append(lib.items, item)
+	return nil
+}
+
+// contentKey identifies a shot by its values other than the ID, ignoring case and spacing.
+func (lib *libraryBuilder) contentKey(shot map[string]any) string {
+	parts := make([]string, 0, len(lib.keys))
+	for _, k := range lib.keys {
+		if k == lib.idKey {
+			continue
+		}
+		v := ""
+		if shot[k] != nil {
+			v = strings.ToLower(strings.Join(strings.Fields(fmt.Sprint(shot[k])), " "))
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, "\x1f")
+}
+
+// embed computes the embeddings of the pending items, once per distinct text.
+func (lib *libraryBuilder) embed(ctx context.Context, concurrency int) error {
+	texts := map[string][]int{}
+	for _, i := range lib.pending {
+		text := lib.metadata.EmbeddingText(stringValues(similarity.ShotValues(lib.items[i])))
+		if text == "" {
+			return fmt.Errorf("item %s has nothing to embed", lib.items[i].ID)
+		}
+		texts[text] = append(texts[text], i)
+	}
+
+	var done atomic.Int64
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(concurrency, 1))
+	for text, indexes := range texts {
+		g.Go(func() error {
+			embedding, err := similarity.EmbedText(ctx, text, lib.embedding)
+			if err != nil {
+				return fmt.Errorf("embedding %s: %w", lib.items[indexes[0]].ID, err)
+			}
+			for _, i := range indexes {
+				lib.items[i].Embedding = embedding
+			}
+			if n := done.Add(1); n%100 == 0 {
+				log.Printf("Embedded %d of %d texts", n, len(texts))
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func stringValues(values map[string]any) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		if v != nil {
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// normalizeHeader reduces a header or key to lower-case letters and digits, so "Damage ID",
+// "damage_id" and "DamageId" compare equal.
+func normalizeHeader(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	in := fs.String("in", "", "reference file to read, JSON or binary")
+	out := fs.String("out", "", "file to write; a "+similarity.BinaryReferenceExt+" extension writes the binary format, anything else JSON")
+	int8 := fs.Bool("int8", false, "store int8 codes in a binary file, so its searches scan them and rescore in float32")
+	model := fs.String("model", "", "embedding model to record, for inputs that don't record theirs")
+	fs.Parse(args)
+	if *in == "" || *out == "" {
+		return fmt.Errorf("both -in and -out are required")
+	}
+
+	file, err := similarity.LoadReferenceFile(*in)
+	if err != nil {
+		return err
+	}
+	if *model != "" {
+		if file.Metadata == nil {
+			file.Metadata = &similarity.ReferenceMetadata{}
+		}
+		if file.Metadata.Model != "" && file.Metadata.Model != *model {
+			return fmt.Errorf("%s was embedded with %s; use \"reftool reembed\" to change the model", *in, file.Metadata.Model)
+		}
+		file.Metadata.Model = *model
+	}
+
+	if filepath.Ext(*out) != similarity.BinaryReferenceExt {
+		if *int8 {
+			return fmt.Errorf("-int8 needs a %s output", similarity.BinaryReferenceExt)
+		}
+		if err := similarity.WriteReferenceFile(*out, file); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d items to %s\n", len(file.Items), *out)
+		return nil
+	}
+
+	if err := similarity.WriteBinaryReferenceFile(*out, file, similarity.BinaryOptions{Int8: *int8}); err != nil {
+		return err
+	}
+	// Read the result back so a broken file is caught here rather than at serving time
+	written, err := similarity.LoadReferenceFile(*out)
+	if err != nil {
+		return err
+	}
+	idx := similarity.NewReferenceIndex(written.Items, similarity.IndexOptions{})
+	fmt.Printf("Wrote %d items (%d searchable, embedding %s, int8 %t) to %s\n",
+		len(idx.Items), idx.Searchable(), written.Metadata.Embedding(), *int8, *out)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/workflows"
+)
+
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	dataPath := fs.String("data", defaultDataPath(), "directory of the reference libraries")
+	analysisType := fs.String("type", "", "check only this analysis type's library")
+	file := fs.String("file", "", "check this file against -type instead of the configured library")
+	asJSON := fs.Bool("json", false, "print the reports as JSON")
+	fs.Parse(args)
+
+	var reports []workflows.ReferenceReport
+	switch {
+	case *file != "":
+		if *analysisType == "" {
+			return fmt.Errorf("-file needs -type")
+		}
+		cfg, err := config.AnalysisConfig(*analysisType)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, workflows.LintReferenceFile(cfg, *file))
+	default:
+		for _, r := range workflows.LintReferenceLibraries(*dataPath) {
+			if *analysisType == "" || r.AnalysisType == *analysisType {
+				reports = append(reports, r)
+			}
+		}
+		if len(reports) == 0 {
+			return fmt.Errorf("unknown analysis type %q", *analysisType)
+		}
+	}
+
+	issues := 0
+	for _, r := range reports {
+		issues += len(r.Issues)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range reports {
+			fmt.Println(r.Summary())
+			for _, issue := range r.Issues {
+				fmt.Printf("  %s\n", issue)
+			}
+		}
+	}
+	if issues > 0 {
+		return fmt.Errorf("%d issues", issues)
+	}
+	return nil
+}
+
+// defaultDataPath is the server's reference data directory: REFERENCE_DATA_PATH or ./data.
+func defaultDataPath() string {
+	if p := os.Getenv("REFERENCE_DATA_PATH"); p != "" {
+		return p
+	}
+	return "./data"
+}
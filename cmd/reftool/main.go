@@ -0,0 +1,66 @@
+// Command reftool maintains the reference shot libraries used by the similarity search.
+//
+// Usage:
+//
+//	reftool <command> [flags]
+//
+// Commands:
+//
+//	bench     benchmark exact and approximate top-k search
+//	build     build a reference library from CSV/XLSX exports of past TARAs
+//	convert   convert a reference file between the JSON and binary formats
+//	lint      check the reference libraries for bad embeddings, IDs and fields
+//	reembed   re-embed a reference library with another embedding model, resumably
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: reftool <command> [flags]
+
+Commands:
+  bench     benchmark exact and approximate top-k search
+  build     build a reference library from CSV/XLSX exports of past TARAs
+  convert   convert a reference file between the JSON and binary formats
+  lint      check the reference libraries for bad embeddings, IDs and fields
+  reembed   re-embed a reference library with another embedding model, resumably
+
+Run "reftool <command> -h" for the flags of a command.
+`)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd := os.Args[1]; cmd {
+	case "bench":
+		err = runBench(os.Args[2:])
+	case "build":
+		err = runBuild(os.Args[2:])
+	case "convert":
+		err = runConvert(os.Args[2:])
+	case "lint":
+		err = runLint(os.Args[2:])
+	case "reembed":
+		err = runReembed(os.Args[2:])
+	case "help", "-h", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "reftool: unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("reftool %s: %v", os.Args[1], err)
+	}
+}
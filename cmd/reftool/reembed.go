@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	"golang.org/x/sync/errgroup"
+)
+
+func runReembed(args []string) error {
+	configured := similarity.ConfiguredEmbedding()
+	fs := flag.NewFlagSet("reembed", flag.ExitOnError)
+	in := fs.String("in", "", "reference file to re-embed")
+	out := fs.String("out", "", "file to write (default: -in, replaced when done)")
+	analysisType := fs.String("type", "", "analysis type whose embed keys to use, for files that don't record theirs")
+	model := fs.String("model", configured.Model, "embedding model to switch to, e.g. builtin:hashed-tfidf to work offline or local:<model> (default: EMBEDDING_MODEL)")
+	dimensions := fs.Int("dimensions", configured.Dimensions, "embedding dimensions to switch to (default: EMBEDDING_DIMENSIONS)")
+	concurrency := fs.Int("concurrency", 4, "embedding requests in flight")
+	int8 := fs.Bool("int8", false, "store int8 codes in a binary output file")
+	restart := fs.Bool("restart", false, "discard the progress of an interrupted run instead of resuming it")
+	fs.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	if *out == "" {
+		*out = *in
+	}
+	target := similarity.EmbeddingSpec{Model: *model, Dimensions: *dimensions}
+
+	file, err := similarity.LoadReferenceFile(*in)
+	if err != nil {
+		return err
+	}
+	meta := similarity.ReferenceMetadata{}
+	if file.Metadata != nil {
+		meta = *file.Metadata
+	}
+	if len(meta.EmbedKeys) == 0 {
+		if *analysisType == "" {
+			return fmt.Errorf("%s doesn't record its embed keys; name its analysis with -type", *in)
+		}
+		cfg, err := config.AnalysisConfig(*analysisType)
+		if err != nil {
+			return err
+		}
+		meta.EmbedKeys = cfg.EmbedKeys
+	}
+	source := file.Metadata.Embedding()
+	meta.Model, meta.Dimensions = target.Model, target.Dimensions
+
+	// Embeddings that can be kept: the input's own if it already uses the target embedding, else
+	// those of an earlier output for items whose text is unchanged
+	reuse := map[string][]float32{}
+	if source == target {
+		for _, item := range file.Items {
+			if len(item.Embedding) == target.Dimensions {
+				reuse[textHash(meta, item)] = item.Embedding
+			}
+		}
+	} else if *out != *in {
+		if prev, err := similarity.LoadReferenceFile(*out); err == nil && prev.Metadata.Embedding() == target {
+			for _, item := range prev.Items {
+				if len(item.Embedding) == target.Dimensions {
+					reuse[textHash(meta, item)] = item.Embedding
+				}
+			}
+		}
+	}
+
+	progress, err := openProgress(*out+".reembed", target, *restart)
+	if err != nil {
+		return err
+	}
+	defer progress.close()
+	for hash, embedding := range progress.done {
+		reuse[hash] = embedding
+	}
+
+	texts := map[string]string{} // Hash -> text still to embed
+	for _, item := range file.Items {
+		hash := textHash(meta, item)
+		if _, ok := reuse[hash]; !ok {
+			text := meta.EmbeddingText(stringValues(similarity.ShotValues(item)))
+			if text == "" {
+				return fmt.Errorf("item %s has nothing to embed", item.ID)
+			}
+			texts[hash] = text
+		}
+	}
+	fmt.Printf("%s -> %s: %d items, %d texts to embed, %d reused\n", source, target, len(file.Items), len(texts), len(file.Items)-len(texts))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var done atomic.Int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(*concurrency, 1))
+	for hash, text := range texts {
+		g.Go(func() error {
+			embedding, err := similarity.EmbedText(gctx, text, target)
+			if err != nil {
+				return err
+			}
+			if err := progress.record(hash, embedding); err != nil {
+				return err
+			}
+			if n := done.Add(1); n%100 == 0 {
+				log.Printf("Embedded %d of %d texts", n, len(texts))
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("stopped after %d of %d texts (%w); run the same command again to resume", done.Load(), len(texts), err)
+	}
+
+	for i, item := range file.Items {
+		hash := textHash(meta, item)
+		if embedding, ok := reuse[hash]; ok {
+			file.Items[i].Embedding = embedding
+		} else {
+			file.Items[i].Embedding = progress.done[hash]
+		}
+	}
+	file.Metadata = &meta
+	if filepath.Ext(*out) == similarity.BinaryReferenceExt {
+		err = similarity.WriteBinaryReferenceFile(*out, file, similarity.BinaryOptions{Int8: *int8})
+	} else {
+		err = similarity.WriteReferenceFile(*out, file)
+	}
+	if err != nil {
+		return err
+	}
+	progress.remove()
+	fmt.Printf("Wrote %d items embedded with %s to %s\n", len(file.Items), target, *out)
+	return nil
+}
+
+// textHash identifies the text an item is embedded from.
+func textHash(meta similarity.ReferenceMetadata, item similarity.ReferenceData) string {
+	sum := sha256.Sum256([]byte(meta.EmbeddingText(stringValues(similarity.ShotValues(item)))))
+	return hex.EncodeToString(sum[:])
+}
+
+// reembedProgress is the append-only record of an interrupted re-embedding: a header line naming
+// the target embedding, then one line per embedded text.
+type reembedProgress struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	done map[string][]float32 // Text hash -> embedding
+}
+
+type progressEntry struct {
+	TextSHA256 string    `json:"text_sha256"`
+	Embedding  []float32 `json:"embedding"`
+}
+
+func openProgress(path string, target similarity.EmbeddingSpec, restart bool) (*reembedProgress, error) {
+	p := &reembedProgress{path: path, done: map[string][]float32{}}
+	if restart {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		header, rest, _ := bytes.Cut(data, []byte("\n"))
+		var spec similarity.EmbeddingSpec
+		if err := json.Unmarshal(header, &spec); err != nil || spec != target {
+			return nil, fmt.Errorf("%s holds progress towards another embedding (%s); use -restart to discard it", path, spec)
+		}
+		for _, line := range bytes.Split(rest, []byte("\n")) {
+			var e progressEntry
+			// A line cut short by a crash is skipped; its text is embedded again
+			if json.Unmarshal(line, &e) == nil && len(e.Embedding) == target.Dimensions {
+				p.done[e.TextSHA256] = e.Embedding
+			}
+		}
+		log.Printf("Resuming from %s: %d texts already embedded", path, len(p.done))
+	}
+
+	p.f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	p.w = bufio.NewWriter(p.f)
+	if len(data) == 0 {
+		header, _ := json.Marshal(target)
+		p.w.Write(append(header, '\n'))
+	} else if data[len(data)-1] != '\n' {
+		p.w.WriteByte('\n') // Terminate a partial last line
+	}
+	return p, p.w.Flush()
+}
+
+// record appends an embedding and flushes it, so an interruption loses at most the texts in flight.
+func (p *reembedProgress) record(hash string, embedding []float32) error {
+	line, err := json.Marshal(progressEntry{TextSHA256: hash, Embedding: embedding})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[hash] = embedding
+	p.w.Write(append(line, '\n'))
+	return p.w.Flush()
+}
+
+func (p *reembedProgress) close() {
+	if p.f != nil {
+		p.f.Close()
+		p.f = nil
+	}
+}
+
+func (p *reembedProgress) remove() {
+	p.close()
+	os.Remove(p.path)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readTable reads the rows of a CSV file or of one sheet of an XLSX workbook (the first sheet
+// when sheet is empty). Cells are returned as text.
+func readTable(filePath, sheet string) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv", ".tsv", ".txt":
+		return readCSV(filePath)
+	case ".xlsx", ".xlsm":
+		return readXLSX(filePath, sheet)
+	}
+	return nil, fmt.Errorf("%s: unsupported file type, expected .csv or .xlsx", filePath)
+}
+
+func readCSV(filePath string) ([][]string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Excel writes a BOM
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = sniffDelimiter(data)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return rows, nil
+}
+
+// sniffDelimiter picks the most frequent of comma, semicolon (European Excel) and tab in the
+// first line.
+func sniffDelimiter(data []byte) rune {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	best, bestCount := ',', bytes.Count(line, []byte(","))
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(line, []byte(string(d))); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+// XLSX parts, reduced to what reading cell text needs.
+type (
+	xlsxWorkbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	xlsxRelationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	xlsxText struct {
+		T    string `xml:"t"`
+		Runs []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	}
+	xlsxSharedStrings struct {
+		Items []xlsxText `xml:"si"`
+	}
+	xlsxSheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+)
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+func readXLSX(filePath, sheet string) ([][]string, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	parts := map[string]*zip.File{}
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	decode := func(name string, v any) error {
+		f, ok := parts[name]
+		if !ok {
+			return fmt.Errorf("%s: missing %s", filePath, name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if err := xml.NewDecoder(rc).Decode(v); err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %s: %w", filePath, name, err)
+		}
+		return nil
+	}
+
+	var wb xlsxWorkbook
+	if err := decode("xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	var rid string
+	var names []string
+	for _, s := range wb.Sheets {
+		names = append(names, s.Name)
+		if rid == "" && (sheet == "" || s.Name == sheet) {
+			rid = s.RID
+		}
+	}
+	if rid == "" {
+		return nil, fmt.Errorf("%s: no sheet %q; sheets are %q", filePath, sheet, names)
+	}
+	var sheetPart string
+	for _, r := range rels.Relationships {
+		if r.ID == rid {
+			// Targets are relative to xl/, or absolute within the package
+			if strings.HasPrefix(r.Target, "/") {
+				sheetPart = strings.TrimPrefix(r.Target, "/")
+			} else {
+				sheetPart = path.Join("xl", r.Target)
+			}
+		}
+	}
+
+	var shared xlsxSharedStrings
+	if _, ok := parts["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+	var ws xlsxSheet
+	if err := decode(sheetPart, &ws); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(ws.Rows))
+	for _, r := range ws.Rows {
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if ref := columnIndex(c.Ref); ref >= 0 {
+				col = ref // Empty cells are left out, so place by reference
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch c.Type {
+			case "s":
+				if n, err := strconv.Atoi(c.Value); err == nil && n >= 0 && n < len(shared.Items) {
+					row[col] = shared.Items[n].String()
+				}
+			case "inlineStr":
+				row[col] = c.Inline.String()
+			case "b":
+				row[col] = map[string]string{"0": "FALSE", "1": "TRUE"}[c.Value]
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// columnIndex returns the zero-based column of a cell reference such as "AB12".
+func columnIndex(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
@@ -0,0 +1,102 @@
+// Package catalog holds the threat and technique catalogs the analyses refer to:
+// STRIDE, the threat groups of UN Regulation No. 155 Annex 5, and common automotive attack techniques.
+package catalog
+
+import (
+	"sort"
+	"strings"
+)
+
+// Catalog names.
+const (
+	STRIDE     = "stride"
+	UNR155     = "unr155"
+	Techniques = "techniques"
+)
+
+// Entry is one catalog item.
+type Entry struct {
+	ID          string `json:"id"`
+	Catalog     string `json:"catalog"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var entries = []Entry{
+	// STRIDE, with the cybersecurity property each threat violates
+	{"S", STRIDE, "Spoofing", "Pretending to be something or someone else. Violates authenticity."},
+	{"T", STRIDE, "Tampering", "Modifying data or code without authorisation. Violates integrity."},
+	{"R", STRIDE, "Repudiation", "Denying having performed an action. Violates non-repudiation."},
+	{"I", STRIDE, "Information disclosure", "Exposing information to someone not authorised to see it. Violates confidentiality."},
+	{"D", STRIDE, "Denial of service", "Making a service or function unavailable. Violates availability."},
+	{"E", STRIDE, "Elevation of privilege", "Gaining capabilities without authorisation. Violates authorisation."},
+
+	// UN R155 Annex 5 Part A threat groups
+	{"4.3.1", UNR155, "Threats regarding back-end servers", "Back-end servers used to attack a vehicle or extract data, e.g. abuse of privileges by staff, unauthorised internet access to the server, loss of data held on the server."},
+	{"4.3.2", UNR155, "Threats to vehicles regarding their communication channels", "Spoofing of messages or data received by the vehicle, unauthorised manipulation or deletion of vehicle-held code and data, accepting untrusted messages, man-in-the-middle and session hijacking, denial of service via the channels, information leakage or interception."},
+	{"4.3.3", UNR155, "Threats to vehicles regarding their update procedures", "Misuse or compromise of over-the-air or local update procedures, e.g. fabricated update packages, and denial of legitimate updates."},
+	{"4.3.4", UNR155, "Threats to vehicles regarding unintended human actions", "Legitimate actors unintentionally facilitating an attack, e.g. owners being tricked into loading malware or misconfiguring equipment."},
+	{"4.3.5", UNR155, "Threats to vehicles regarding their external connectivity and connections", "Manipulation of connectivity to vehicle functions (e.g. telematics, remote keys), hosted third-party software used as a means to attack, and devices connected to external interfaces such as USB or the OBD port."},
+	{"4.3.6", UNR155, "Threats to vehicle data or code", "Extraction of vehicle data or code (e.g. IP theft, cryptographic keys), manipulation of data or code, erasure of data or code, introduction of malware, introduction of new software or overwriting existing software, disruption of systems or operations, manipulation of vehicle parameters."},
+	{"4.3.7", UNR155, "Potential vulnerabilities that could be exploited if not sufficiently protected or hardened", "Weak or misused cryptographic technologies, parts or supplies compromised to permit attack, software or hardware development permitting vulnerabilities, network design introducing vulnerabilities, physical loss of data, unintended transfer of data, physical manipulation of systems."},
+
+	// Common automotive attack techniques
+	{"AT-01", Techniques, "CAN message injection", "Sending forged frames on an in-vehicle CAN bus, e.g. through the OBD port or a compromised ECU, to trigger or suppress functions."},
+	{"AT-02", Techniques, "Replay attack", "Capturing valid messages (e.g. key fob or CAN frames) and re-sending them later to repeat an authorised action."},
+	{"AT-03", Techniques, "Relay attack", "Relaying the radio signals between a key and the vehicle over a distance to unlock or start it without the owner's presence."},
+	{"AT-04", Techniques, "Firmware extraction", "Reading firmware from flash via debug interfaces (JTAG/SWD), chip-off or bootloader services to obtain code, keys or IP."},
+	{"AT-05", Techniques, "Firmware reflashing", "Writing modified firmware through diagnostic (UDS) reprogramming, a bootloader or debug interface, bypassing or defeating signature checks."},
+	{"AT-06", Techniques, "Diagnostic service abuse", "Using UDS/OBD diagnostic services such as SecurityAccess, RoutineControl or WriteDataByIdentifier with weak seed-key algorithms or leaked keys."},
+	{"AT-07", Techniques, "Malicious update package", "Delivering a crafted software update through a compromised back end or update channel."},
+	{"AT-08", Techniques, "Telematics remote exploit", "Exploiting a vulnerability in the telematics unit's cellular, Wi-Fi or back-end interface to execute code remotely."},
+	{"AT-09", Techniques, "Bluetooth exploit", "Exploiting the infotainment Bluetooth stack, e.g. pairing weaknesses or memory corruption, from within radio range."},
+	{"AT-10", Techniques, "Sensor spoofing", "Feeding false inputs to sensors such as GNSS, radar, lidar or cameras to mislead driver assistance functions."},
+	{"AT-11", Techniques, "Side-channel analysis", "Measuring power, timing or electromagnetic emissions of an ECU to recover cryptographic keys."},
+	{"AT-12", Techniques, "Fault injection", "Voltage or clock glitching and laser faults to skip security checks such as secure boot verification."},
+	{"AT-13", Techniques, "Gateway bypass", "Connecting directly to an internal bus segment or exploiting gateway filtering rules to reach safety-relevant ECUs."},
+	{"AT-14", Techniques, "Denial of service flooding", "Flooding a bus or interface with high-priority or malformed messages to block legitimate communication."},
+}
+
+// Names returns the available catalogs.
+func Names() []string {
+	return []string{STRIDE, UNR155, Techniques}
+}
+
+// Search returns the entries of a catalog (all catalogs when name is empty) that match the query,
+// best matches first. An empty query returns the whole catalog. Matching is by case-insensitive
+// words of the query in the ID, name and description.
+func Search(name string, query string, limit int) []Entry {
+	words := strings.Fields(strings.ToLower(query))
+	type scored struct {
+		entry Entry
+		score int
+	}
+	var matches []scored
+	for _, e := range entries {
+		if name != "" && e.Catalog != name {
+			continue
+		}
+		text := strings.ToLower(e.ID + " " + e.Name + " " + e.Description)
+		score := 0
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				score++
+				if strings.Contains(strings.ToLower(e.Name), w) {
+					score++ // Name matches count double
+				}
+			}
+		}
+		if len(words) == 0 || score > 0 {
+			matches = append(matches, scored{e, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	result := make([]Entry, len(matches))
+	for i, m := range matches {
+		result[i] = m.entry
+	}
+	return result
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
 )
 
 // Constants for Analysis Types
@@ -22,6 +25,25 @@ const (
 const (
 	StepBase     = "base"
 	StepValidate = "validate"
+	// StepSelfConsistency samples the base prompt several times and votes per field.
+	StepSelfConsistency = "self_consistency"
+	// StepReflect drafts with the base prompt, then critiques and revises the draft.
+	StepReflect = "reflect"
+	// StepAgent answers the base prompt with function tools for looking up reference and project data.
+	StepAgent = "agent"
+)
+
+// Tools available to StepAgent (ModelConfig.AgentTools).
+const (
+	ToolSearchReference = "search_reference" // Semantic search in the reference libraries
+	ToolProjectData     = "get_project_data" // The project's existing assets and scenarios
+	ToolCatalog         = "lookup_catalog"   // STRIDE, UN R155 and attack technique catalogs
+)
+
+// Trial result extractors for StepValidate (ModelConfig.TrialExtractor).
+const (
+	TrialExtractFinalStep = "final_step" // Text after the last '####'
+	TrialExtractDict      = "dict"       // Result dictionary, given to consolidation field by field
 )
 
 // Constants for common data keys (mirroring Python constants.py)
@@ -71,9 +93,51 @@ type ModelConfig struct {
 	DataKeys              []string // ADDED: Keys defining the primary input data fields
 	EmbedKeys             []string // Keys used for generating embeddings
 	ShotsKeys             []string // Keys expected in the "shots" data
-	ReferenceDataJSONFile string   // Filename of the reference JSON in the data dir
+	ReferenceDataJSONFile string   // Filename of the reference JSON in the data dir; a .refbin conversion next to it is used instead
 	PromptFiles           []string // List of prompt template filenames
 	LLMStep               string   // e.g., StepBase or StepValidate
+	OptionalSteps         []string // Other LLM steps a request may opt in to instead of LLMStep
+	OutputSchema          map[string]any // JSON schema of the result for providers with structured outputs; nil = delimiter parsing only
+
+	// Shot selection; zero values use the workflow defaults
+	MaxShots         int     // Most reference shots in the prompt
+	ShotsMinScore    float64 // Reference items less similar to the input than this cosine are not used as shots
+	ShotsDiversity   float64 // Weight of variety against relevance when picking shots, 0-1; negative ranks by relevance alone
+	ShotsTokenBudget int     // Estimated prompt tokens the shots may take together
+
+	// Sampled trials (StepValidate trials, StepSelfConsistency samples); zero values use the workflow defaults
+	Trials           int // Number of StepValidate trials
+	TrialConcurrency int // Maximum trials or samples in flight at once
+
+	// StepValidate consolidation; zero values use the workflow defaults
+	TrialExtractor            string   // How each trial's result is extracted, e.g. TrialExtractDict
+	ConsolidationSystemPrompt string   // System prompt of the consolidation call
+	ConsolidationContextKeys  []string // Placeholders filled in the validate template, from the base prompt context and experts_res
+	EnsembleModels            []string // Ensemble mode: trials are spread over these model specs ("provider:model") instead of one model
+
+	// StepReflect settings
+	ReflectRounds int      // Critique-and-revise rounds; zero uses the workflow default
+	WordingRules  []string // Wording rules the critique checks the answer against
+
+	// StepAgent settings; zero values use the workflow defaults
+	AgentTools    []string // Tools offered to the model, e.g. ToolCatalog; empty means all
+	MaxAgentSteps int      // Model rounds that may call tools before it must answer
+
+	// Output guardrails checked on the parsed result; a violation is re-prompted up to GuardrailRetries times
+	Guardrails       []Guardrail
+	GuardrailRetries int
+
+	// LLM-as-judge scoring after the workflow; no rubric means the result is not judged
+	Rubric           []RubricCriterion
+	JudgeThreshold   float64 // Minimum mean score for the result to pass
+	JudgeAction      string  // What to do with a failing result, e.g. JudgeActionRegenerate; empty means JudgeActionFlag
+	MaxRegenerations int     // Extra workflow runs allowed with JudgeActionRegenerate
+	JudgeModel       string  // Model spec ("provider:model") of the judge, with its fallback chain; empty means gpt-4o
+
+	// StepSelfConsistency settings; zero values use the workflow defaults
+	Samples            int     // Number of sampled answers
+	SampleTemperature  float32 // Temperature for the samples
+	AgreementThreshold float64 // Fields agreed on by a smaller share of samples are flagged for review
 }
 
 // --- configMap - ADDED DataKeys slices based on Python model_attrs.py ---
@@ -87,6 +151,20 @@ var configMap = map[string]ModelConfig{
 		ReferenceDataJSONFile: "damage_scenario_reference.json",
 		PromptFiles:           []string{"damage_scenario_base.txt", "damage_scenario_validate.txt"},
 		LLMStep:               StepValidate,
+		TrialExtractor:        TrialExtractFinalStep,
+		ConsolidationSystemPrompt: "You are an automotive cybersecurity expert tasked with consolidating potential damage scenarios based on expert input and providing the single most correct one formatted as instructed.",
+		ConsolidationContextKeys:  []string{"system_type", "asset", "category", "property", "asset_description", ExpertsRes},
+		WordingRules: []string{
+			"The damage scenario is one sentence of the form \"<detailed scenario> CAUSED BY <general reason based on the STRIDE clause>\".",
+			"The damage scenario describes the damage to the road user, the vehicle or the OEM, not the attack itself.",
+		},
+		OutputSchema:          damageScenarioSchema,
+		Rubric:                damageScenarioRubric,
+		JudgeThreshold:        3.5,
+		JudgeAction:           JudgeActionRegenerate,
+		MaxRegenerations:      1,
+		Guardrails:            damageScenarioGuardrails,
+		GuardrailRetries:      defaultGuardrailRetries,
 	},
 	ImpactScoresAnalysis: {
 		AnalysisType:          ImpactScoresAnalysis,
@@ -97,6 +175,12 @@ var configMap = map[string]ModelConfig{
 		ReferenceDataJSONFile: "impact_scores_reference.json",
 		PromptFiles:           []string{"impact_scores_base.txt"},
 		LLMStep:               StepBase,
+		OptionalSteps:         []string{StepSelfConsistency},
+		Samples:               5,
+		AgreementThreshold:    0.6,
+		OutputSchema:          impactScoresSchema,
+		Guardrails:            impactScoresGuardrails,
+		GuardrailRetries:      defaultGuardrailRetries,
 	},
 	ThreatScenarioAnalysis: {
 		AnalysisType:          ThreatScenarioAnalysis,
@@ -107,6 +191,19 @@ var configMap = map[string]ModelConfig{
 		ReferenceDataJSONFile: "threat_scenario_reference.json",
 		PromptFiles:           []string{"threat_scenario_base.txt"},
 		LLMStep:               StepBase,
+		OptionalSteps:         []string{StepReflect},
+		ReflectRounds:         1,
+		WordingRules: []string{
+			"The threat scenario is one sentence describing how the threat is realised against the asset.",
+			"The threat scenario does not use \"caused by\" or \"due to\".",
+		},
+		OutputSchema:          threatScenarioSchema,
+		Rubric:                threatScenarioRubric,
+		JudgeThreshold:        3.5,
+		JudgeAction:           JudgeActionRegenerate,
+		MaxRegenerations:      1,
+		Guardrails:            threatScenarioGuardrails,
+		GuardrailRetries:      defaultGuardrailRetries,
 	},
 	AttackStepsAnalysis: {
 		AnalysisType:          AttackStepsAnalysis,
@@ -117,6 +214,15 @@ var configMap = map[string]ModelConfig{
 		ReferenceDataJSONFile: "attack_steps_reference.json",
 		PromptFiles:           []string{"attack_steps_base.txt", "attack_steps_validate.txt"},
 		LLMStep:               StepValidate,
+		TrialExtractor:        TrialExtractDict,
+		ConsolidationSystemPrompt: "You are an automotive cybersecurity expert tasked with consolidating the vulnerabilities and attack steps proposed by experts and providing the single most plausible and complete one formatted as instructed.",
+		ConsolidationContextKeys:  []string{"system_type", "asset", "category", "asset_description", "threat", "threat_scenario", "attack_vector", ExpertsRes},
+		OutputSchema:          attackStepsSchema,
+		Rubric:                attackStepsRubric,
+		JudgeThreshold:        3.5,
+		JudgeAction:           JudgeActionFlag,
+		Guardrails:            attackStepsGuardrails,
+		GuardrailRetries:      defaultGuardrailRetries,
 	},
 	FeasibilityAnalysis: {
 		AnalysisType:          FeasibilityAnalysis,
@@ -127,6 +233,12 @@ var configMap = map[string]ModelConfig{
 		ReferenceDataJSONFile: "feasibility_reference.json",
 		PromptFiles:           []string{"feasibility_base.txt"},
 		LLMStep:               StepBase,
+		OptionalSteps:         []string{StepSelfConsistency},
+		Samples:               5,
+		AgreementThreshold:    0.6,
+		OutputSchema:          feasibilitySchema,
+		Guardrails:            feasibilityGuardrails,
+		GuardrailRetries:      defaultGuardrailRetries,
 	},
 	AttackTreeAnalysis: {
 		AnalysisType:          AttackTreeAnalysis,
@@ -137,18 +249,83 @@ var configMap = map[string]ModelConfig{
 		ReferenceDataJSONFile: "attack_steps_reference.json",                                                       // Matches Python
 		PromptFiles:           []string{"attack_tree.txt"},
 		LLMStep:               StepBase,
+		OptionalSteps:         []string{StepAgent},
+		AgentTools:            []string{ToolSearchReference, ToolProjectData, ToolCatalog},
+		MaxAgentSteps:         4,
 	},
 }
 
-// GetConfig retrieves the configuration for a given analysis type.
-func GetConfig(analysisType string, baseDataPath string) (*ModelConfig, error) {
+// configErrors holds the analyses whose configuration is invalid, e.g. has a guardrail pattern
+// that doesn't compile; they fail to load.
+var configErrors = map[string]error{}
+
+func init() {
+	for analysisType, config := range configMap {
+		if err := CompileGuardrails(config.Guardrails); err != nil {
+			configErrors[analysisType] = fmt.Errorf("invalid configuration of %s: %w", analysisType, err)
+		}
+	}
+}
+
+// AnalysisTypes returns the configured analysis types, sorted.
+func AnalysisTypes() []string {
+	types := make([]string, 0, len(configMap))
+	for t := range configMap {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// AnalysisConfig returns the configuration of an analysis type as declared, without resolving
+// the reference file or applying environment overrides.
+func AnalysisConfig(analysisType string) (*ModelConfig, error) {
 	config, exists := configMap[analysisType]
 	if !exists {
 		return nil, fmt.Errorf("unknown analysis type: %s", analysisType)
 	}
+	if err := configErrors[analysisType]; err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// ShotIDKey returns the shot key that identifies a reference item, e.g. DamageID, or "" if the
+// shots have none.
+func (c *ModelConfig) ShotIDKey() string {
+	for _, key := range c.ShotsKeys {
+		if strings.HasSuffix(key, "_id") {
+			return key
+		}
+	}
+	return ""
+}
+
+// GetConfig retrieves the configuration for a given analysis type.
+func GetConfig(analysisType string, baseDataPath string) (*ModelConfig, error) {
+	declared, err := AnalysisConfig(analysisType)
+	if err != nil {
+		return nil, err
+	}
+	config := *declared
+
+	// LLM_ENSEMBLE_MODELS (comma-separated model specs) turns on ensemble mode for validate-type analyses
+	if models := os.Getenv("LLM_ENSEMBLE_MODELS"); models != "" && config.LLMStep == StepValidate {
+		config.EnsembleModels = nil
+		for _, m := range strings.Split(models, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				config.EnsembleModels = append(config.EnsembleModels, m)
+			}
+		}
+	}
 
 	// Construct full path for the reference file
 	config.ReferenceDataJSONFile = filepath.Join(baseDataPath, config.ReferenceDataJSONFile)
+	// Prefer a binary conversion of the file (reftool convert) when one sits next to it
+	binaryFile := strings.TrimSuffix(config.ReferenceDataJSONFile, filepath.Ext(config.ReferenceDataJSONFile)) + similarity.BinaryReferenceExt
+	if _, err := os.Stat(binaryFile); err == nil {
+		config.ReferenceDataJSONFile = binaryFile
+	}
 
 	// Check if file exists
 	if _, err := os.Stat(config.ReferenceDataJSONFile); os.IsNotExist(err) {
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Kinds of output guardrail rules.
+const (
+	RuleRequired    = "required"     // The key is present and not empty
+	RuleEnum        = "enum"         // The value (or every element of a list value) is one of Values
+	RuleRange       = "range"        // The value is an integer between Min and Max
+	RuleContains    = "contains"     // The text contains Text (case-insensitive)
+	RuleNotContains = "not_contains" // The text doesn't contain Text (case-insensitive)
+	RulePattern     = "pattern"      // The text matches the regular expression Pattern
+)
+
+// Guardrail is one validation rule on a result field.
+type Guardrail struct {
+	Key      string
+	Rule     string
+	Values   []any   // RuleEnum
+	Min, Max float64 // RuleRange
+	Text     string  // RuleContains, RuleNotContains
+	Pattern  string  // RulePattern
+	Message  string  // Optional explanation for the model when the rule is broken
+
+	re *regexp.Regexp // Compiled Pattern, see CompileGuardrails
+}
+
+// Regexp returns the compiled Pattern of a RulePattern guardrail, or nil if it wasn't compiled
+// with CompileGuardrails.
+func (g Guardrail) Regexp() *regexp.Regexp {
+	return g.re
+}
+
+// CompileGuardrails compiles the patterns of the RulePattern rules in place. It returns an error
+// for the first invalid pattern.
+func CompileGuardrails(rules []Guardrail) error {
+	for i := range rules {
+		if rules[i].Rule != RulePattern {
+			continue
+		}
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid guardrail pattern for %q: %w", rules[i].Key, err)
+		}
+		rules[i].re = re
+	}
+	return nil
+}
+
+// defaultGuardrailRetries is the number of re-prompts for a result that breaks its guardrails.
+const defaultGuardrailRetries = 2
+
+// enumeratedStepsPattern matches attack steps written as "1. ... 2. ...", or as a single "1. ..." step.
+const enumeratedStepsPattern = `^\s*1\.\s+\S`
+
+func requiredRules(keys ...string) []Guardrail {
+	rules := make([]Guardrail, 0, len(keys))
+	for _, k := range keys {
+		rules = append(rules, Guardrail{Key: k, Rule: RuleRequired})
+	}
+	return rules
+}
+
+func impactScoreRules(keys ...string) []Guardrail {
+	rules := requiredRules(keys...)
+	for _, k := range keys {
+		rules = append(rules, Guardrail{Key: k, Rule: RuleRange, Min: 1, Max: 4, Message: "impact scores are integers from 1 (negligible) to 4 (severe)"})
+	}
+	return rules
+}
+
+var (
+	damageScenarioGuardrails = []Guardrail{
+		{Key: DamageScenario, Rule: RuleRequired},
+		{Key: DamageScenario, Rule: RuleContains, Text: "CAUSED BY", Message: `the damage scenario must have the form "<detailed scenario> CAUSED BY <general reason>"`},
+	}
+	impactScoresGuardrails   = impactScoreRules(SafetyImpact, FinancialImpact, OperationalImpact, PrivacyImpact, OEMFinancialImpact, OEMOperationalImpact, OEMIPImpact)
+	threatScenarioGuardrails = []Guardrail{
+		{Key: ThreatScenario, Rule: RuleRequired},
+		{Key: ThreatScenario, Rule: RuleNotContains, Text: "caused by", Message: `the threat scenario must not use "caused by"`},
+		{Key: ThreatScenario, Rule: RuleNotContains, Text: "due to", Message: `the threat scenario must not use "due to"`},
+		{Key: "attack_vectors", Rule: RuleEnum, Values: AttackVectorValues},
+	}
+	attackStepsGuardrails = []Guardrail{
+		{Key: "vulnerability", Rule: RuleRequired},
+		{Key: AttackSteps, Rule: RuleRequired},
+		{Key: AttackSteps, Rule: RulePattern, Pattern: enumeratedStepsPattern, Message: `attack steps must be enumerated: "1. Attacker first action 2. Attacker second action ..."`},
+	}
+	feasibilityGuardrails = append(requiredRules(ET, SE, KOIC, WOO, EQ),
+		Guardrail{Key: ET, Rule: RuleEnum, Values: ETValues},
+		Guardrail{Key: SE, Rule: RuleEnum, Values: SEValues},
+		Guardrail{Key: KOIC, Rule: RuleEnum, Values: KOICValues},
+		Guardrail{Key: WOO, Rule: RuleEnum, Values: WOOValues},
+		Guardrail{Key: EQ, Rule: RuleEnum, Values: EQValues},
+	)
+)
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestEnumeratedStepsPattern(t *testing.T) {
+	re := regexp.MustCompile(enumeratedStepsPattern)
+	tests := []struct {
+		steps string
+		want  bool
+	}{
+		{"1. Send a spoofed CAN frame", true},
+		{"1. Connect to the OBD port 2. Flash modified firmware", true},
+		{"1. Connect to the OBD port\n2. Flash modified firmware\n3. Restart the ECU", true},
+		{"  1.  Send a spoofed CAN frame", true},
+		{"Send a spoofed CAN frame", false},
+		{"Step 1. Send a spoofed CAN frame", false},
+		{"1.Send a spoofed CAN frame", false},
+		{"1. ", false},
+		{"2. Flash modified firmware", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := re.MatchString(tt.steps); got != tt.want {
+			t.Errorf("enumerated steps pattern matches %q = %v, want %v", tt.steps, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGuardrails(t *testing.T) {
+	rules := []Guardrail{
+		{Key: "a", Rule: RuleRequired},
+		{Key: "b", Rule: RulePattern, Pattern: `^\d+$`},
+	}
+	if err := CompileGuardrails(rules); err != nil {
+		t.Fatalf("CompileGuardrails: %v", err)
+	}
+	if rules[0].Regexp() != nil {
+		t.Error("non-pattern rule got a regexp")
+	}
+	if re := rules[1].Regexp(); re == nil || !re.MatchString("42") {
+		t.Errorf("pattern rule compiled to %v", re)
+	}
+
+	if err := CompileGuardrails([]Guardrail{{Key: "c", Rule: RulePattern, Pattern: `(unclosed`}}); err == nil {
+		t.Error("CompileGuardrails accepted an invalid pattern")
+	}
+}
+
+func TestConfiguredGuardrailsCompile(t *testing.T) {
+	for _, analysisType := range AnalysisTypes() {
+		cfg, err := AnalysisConfig(analysisType)
+		if err != nil {
+			t.Errorf("%s: %v", analysisType, err)
+			continue
+		}
+		for _, rule := range cfg.Guardrails {
+			if rule.Rule == RulePattern && rule.Regexp() == nil {
+				t.Errorf("%s: pattern of %q not compiled", analysisType, rule.Key)
+			}
+		}
+	}
+}
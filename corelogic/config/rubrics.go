@@ -0,0 +1,76 @@
+package config
+
+import "sort"
+
+// What happens to a result whose mean judge score is below ModelConfig.JudgeThreshold.
+const (
+	JudgeActionFlag       = "flag"       // Keep the result; the low score is only reported
+	JudgeActionReject     = "reject"     // Fail the run
+	JudgeActionRegenerate = "regenerate" // Run the workflow again, up to MaxRegenerations times
+)
+
+// Judge scores range from JudgeScoreMin (unusable) to JudgeScoreMax (expert quality).
+const (
+	JudgeScoreMin = 1
+	JudgeScoreMax = 5
+)
+
+// RubricCriterion is one dimension the judge scores a result on.
+type RubricCriterion struct {
+	Name        string
+	Description string
+}
+
+// Criteria shared by the rubrics.
+var (
+	specificityCriterion = RubricCriterion{"specificity", "The result is concrete for this asset and system, not a generic statement that would fit any component."}
+	consistencyCriterion = RubricCriterion{"consistency", "The result is consistent with the asset, its description, the cybersecurity property and the system description."}
+	strideCriterion      = RubricCriterion{"stride_correctness", "The result matches the STRIDE threat class that corresponds to the cybersecurity property (e.g. Integrity - Tampering)."}
+	formatCriterion      = RubricCriterion{"format_compliance", "The result follows the wording and format required by the analysis."}
+)
+
+var (
+	damageScenarioRubric = []RubricCriterion{
+		specificityCriterion,
+		consistencyCriterion,
+		strideCriterion,
+		{"format_compliance", "The damage scenario is one sentence of the form \"<detailed scenario> CAUSED BY <general reason>\" and describes damage, not the attack."},
+	}
+	threatScenarioRubric = []RubricCriterion{
+		specificityCriterion,
+		consistencyCriterion,
+		strideCriterion,
+		{"format_compliance", "The threat scenario is one sentence describing how the threat is realised, without \"caused by\" or \"due to\"."},
+	}
+	attackStepsRubric = []RubricCriterion{
+		specificityCriterion,
+		consistencyCriterion,
+		{"feasibility", "The attack steps are technically plausible for the attack vector and actually realise the threat scenario."},
+		formatCriterion,
+	}
+)
+
+// JudgeSchema builds the strict output schema of a judge answer: an integer score per criterion and a rationale.
+func JudgeSchema(rubric []RubricCriterion) map[string]any {
+	scoreProps := make(map[string]any, len(rubric))
+	names := make([]string, 0, len(rubric))
+	for _, c := range rubric {
+		scoreProps[c.Name] = map[string]any{"type": "integer", "minimum": JudgeScoreMin, "maximum": JudgeScoreMax}
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"scores": map[string]any{
+				"type":                 "object",
+				"properties":           scoreProps,
+				"required":             names,
+				"additionalProperties": false,
+			},
+			"rationale": stringProp(),
+		},
+		"required":             []string{"rationale", "scores"},
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import "sort"
+
+// ReasoningSteps is the key under which schema-constrained answers carry the per-step reasoning
+// that the delimiter format puts in "*** Step N:####" sections.
+const ReasoningSteps = "steps"
+
+// Value sets used by the output schemas (and mirrored in the prompt templates).
+var (
+	ImpactScoreValues  = []any{1, 2, 3, 4}
+	AttackVectorValues = []any{"Remote", "Network", "Physical", "Supply Chain", "Production Line", "Diagnostic"}
+	ETValues           = []any{"< 1 Day", "< 1 Week", "< 1 Month", "< 6 Months", "> 6 Months"}
+	SEValues           = []any{"Layman", "Proficient", "Expert", "Multiple Expert"}
+	KOICValues         = []any{"Public Information", "Restricted Information", "Confidential Information", "Strictly Confidential Information"}
+	WOOValues          = []any{"Unlimited", "Easy", "Moderate", "Difficult"}
+	EQValues           = []any{"Standard", "Specialized", "Bespoke", "Multiple Bespoke"}
+)
+
+func stringProp() map[string]any { return map[string]any{"type": "string"} }
+
+func enumProp(typ string, values []any) map[string]any {
+	return map[string]any{"type": typ, "enum": values}
+}
+
+// resultSchema builds a strict object schema: the reasoning steps plus the given result properties, all required.
+func resultSchema(props map[string]any) map[string]any {
+	properties := map[string]any{
+		ReasoningSteps: map[string]any{"type": "array", "items": stringProp()},
+	}
+	for k, v := range props {
+		properties[k] = v
+	}
+	required := make([]string, 0, len(properties))
+	for k := range properties {
+		required = append(required, k)
+	}
+	sort.Strings(required)
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+var (
+	damageScenarioSchema = resultSchema(map[string]any{
+		DamageScenario: stringProp(),
+	})
+	impactScoresSchema = resultSchema(map[string]any{
+		PrivacyImpact:        enumProp("integer", ImpactScoreValues),
+		SafetyImpact:         enumProp("integer", ImpactScoreValues),
+		FinancialImpact:      enumProp("integer", ImpactScoreValues),
+		OperationalImpact:    enumProp("integer", ImpactScoreValues),
+		OEMFinancialImpact:   enumProp("integer", ImpactScoreValues),
+		OEMOperationalImpact: enumProp("integer", ImpactScoreValues),
+		OEMIPImpact:          enumProp("integer", ImpactScoreValues),
+	})
+	threatScenarioSchema = resultSchema(map[string]any{
+		ThreatScenario:   stringProp(),
+		"attack_vectors": map[string]any{"type": "array", "items": enumProp("string", AttackVectorValues)},
+	})
+	attackStepsSchema = resultSchema(map[string]any{
+		"vulnerability": stringProp(),
+		AttackSteps:     stringProp(),
+	})
+	feasibilitySchema = resultSchema(map[string]any{
+		ET:   enumProp("string", ETValues),
+		SE:   enumProp("string", SEValues),
+		KOIC: enumProp("string", KOICValues),
+		WOO:  enumProp("string", WOOValues),
+		EQ:   enumProp("string", EQValues),
+	})
+)
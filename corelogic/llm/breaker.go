@@ -0,0 +1,149 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("llm circuit breaker open")
+
+// Breaker settings: the circuit opens after breakerFailureThreshold consecutive failures
+// and lets a single probe request through once breakerCooldown has passed.
+const (
+	breakerFailureThreshold = 5
+	breakerCooldown         = 30 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var breakers = &breakerRegistry{breakers: make(map[string]*circuitBreaker)}
+
+// get returns the breaker for a provider and model.
+func (r *breakerRegistry) get(provider, model string) *circuitBreaker {
+	key := provider + ":" + model
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[key]
+	if !ok {
+		b = &circuitBreaker{}
+		r.breakers[key] = b
+	}
+	return b
+}
+
+// allow reports whether a request may be sent. In the half-open state only one probe is let through.
+func (b *circuitBreaker) allow(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < breakerCooldown {
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, name)
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return fmt.Errorf("%w for %s (probe in flight)", ErrCircuitOpen, name)
+	default:
+		return nil
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// providerFailure reports whether err says the provider is unhealthy: a 5xx or 429 answer, or a
+// transport timeout, failed connection or connection dropped mid-request. Rejected requests (400, 401, 404, ...) and cancelled
+// or expired contexts say nothing about the provider's health.
+func providerFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return unhealthyStatus(apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return unhealthyStatus(reqErr.HTTPStatusCode)
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsProviderFailure reports whether err means the provider can't serve requests right now: a failure
+// the circuit breakers count (see providerFailure), or an open breaker. Quota errors and cancelled
+// contexts are not provider failures.
+func IsProviderFailure(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || providerFailure(err)
+}
+
+func unhealthyStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+func (b *circuitBreaker) failure(name string, err error) {
+	if !providerFailure(err) {
+		b.release()
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= breakerFailureThreshold {
+		if b.state != breakerOpen {
+			log.Printf("Warning: opening circuit breaker for %s after %d consecutive failures: %v", name, b.failures, err)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release returns a half-open breaker to open without counting a failure, so a later probe can run.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestProviderFailure(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &openai.APIError{HTTPStatusCode: 500}, true},
+		{"bad gateway", &openai.RequestError{HTTPStatusCode: 502}, true},
+		{"provider rate limit", &openai.APIError{HTTPStatusCode: 429}, true},
+		{"bad request", &openai.APIError{HTTPStatusCode: 400}, false},
+		{"unauthorized", &openai.RequestError{HTTPStatusCode: 401}, false},
+		{"not found", &openai.APIError{HTTPStatusCode: 404}, false},
+		{"connection refused", dialErr, true},
+		{"wrapped connection refused", fmt.Errorf("attempt 1 failed: %w", dialErr), true},
+		{"timeout", &url.Error{Op: "Post", URL: "http://localhost:1", Err: timeoutError{}}, true},
+		{"connection dropped", &url.Error{Op: "Post", URL: "http://localhost:1", Err: io.EOF}, true},
+		{"cancelled", &url.Error{Op: "Post", URL: "http://localhost:1", Err: context.Canceled}, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"quota", &QuotaError{err: ErrRateLimited}, false},
+		{"other", errors.New("returned empty response choice"), false},
+	}
+	for _, tt := range tests {
+		if got := providerFailure(tt.err); got != tt.want {
+			t.Errorf("%s: providerFailure(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+var errUnavailable = &openai.APIError{HTTPStatusCode: 503}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := &circuitBreaker{}
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.failure("test", errUnavailable)
+	}
+	if err := b.allow("test"); err != nil {
+		t.Fatalf("breaker open after %d failures: %v", breakerFailureThreshold-1, err)
+	}
+	// A success resets the count
+	b.success()
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.failure("test", errUnavailable)
+	}
+	if err := b.allow("test"); err != nil {
+		t.Fatalf("breaker open after a success and %d failures: %v", breakerFailureThreshold-1, err)
+	}
+	// Failures that say nothing about the provider don't count
+	b.failure("test", &openai.APIError{HTTPStatusCode: 400})
+	b.failure("test", context.Canceled)
+	if err := b.allow("test"); err != nil {
+		t.Fatalf("breaker opened by rejected requests: %v", err)
+	}
+
+	b.failure("test", errUnavailable)
+	if err := b.allow("test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow after %d failures = %v, want ErrCircuitOpen", breakerFailureThreshold, err)
+	}
+}
+
+// openBreaker returns a breaker that opened the given time ago.
+func openBreaker(ago time.Duration) *circuitBreaker {
+	return &circuitBreaker{state: breakerOpen, failures: breakerFailureThreshold, openedAt: time.Now().Add(-ago)}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		probe     func(b *circuitBreaker) // Outcome of the probe request
+		wantState breakerState
+		wantAllow bool // Whether the next request is let through right away
+	}{
+		{"probe succeeds", func(b *circuitBreaker) { b.success() }, breakerClosed, true},
+		{"probe fails", func(b *circuitBreaker) { b.failure("test", errUnavailable) }, breakerOpen, false},
+		// A rejected or cancelled probe says nothing about the provider: the next request probes again
+		{"probe rejected", func(b *circuitBreaker) { b.failure("test", &openai.APIError{HTTPStatusCode: 400}) }, breakerOpen, true},
+		{"probe released", func(b *circuitBreaker) { b.release() }, breakerOpen, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := openBreaker(breakerCooldown)
+			if err := b.allow("test"); err != nil {
+				t.Fatalf("probe after the cooldown rejected: %v", err)
+			}
+			if b.state != breakerHalfOpen {
+				t.Fatalf("state after the probe was let through = %v, want half-open", b.state)
+			}
+			// Only one probe at a time
+			if err := b.allow("test"); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("second request while probing = %v, want ErrCircuitOpen", err)
+			}
+
+			tt.probe(b)
+			if b.state != tt.wantState {
+				t.Errorf("state after the probe = %v, want %v", b.state, tt.wantState)
+			}
+			if err := b.allow("test"); (err == nil) != tt.wantAllow {
+				t.Errorf("allow after the probe = %v, want allowed %v", err, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestBreakerCooldown(t *testing.T) {
+	tests := []struct {
+		openedAgo time.Duration
+		wantAllow bool
+	}{
+		{0, false},
+		{breakerCooldown / 2, false},
+		{breakerCooldown - time.Second, false},
+		{breakerCooldown, true},
+		{2 * breakerCooldown, true},
+	}
+	for _, tt := range tests {
+		b := openBreaker(tt.openedAgo)
+		err := b.allow("test")
+		if (err == nil) != tt.wantAllow {
+			t.Errorf("allow %v after opening = %v, want allowed %v", tt.openedAgo, err, tt.wantAllow)
+		}
+		if err != nil && !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("allow %v after opening = %v, want ErrCircuitOpen", tt.openedAgo, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProviderOpenAI identifies the OpenAI chat/embedding provider.
+const ProviderOpenAI = "openai"
+
+// CacheStore persists cached LLM responses by content key.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (value string, found bool, err error)
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+}
+
+// CacheConfig controls the optional response cache. The cache is disabled by default.
+type CacheConfig struct {
+	Enabled bool
+	TTL     time.Duration
+	Store   CacheStore
+	// Only calls at or below this temperature are cached; sampled calls (e.g. validation trials)
+	// are meant to differ and must not be collapsed into one answer.
+	MaxTemperature float32
+}
+
+// CacheStats reports a tenant's cache effectiveness since startup.
+type CacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Bypassed uint64 `json:"bypassed"`
+	Errors   uint64 `json:"errors"`
+}
+
+type cacheCounters struct {
+	hits, misses, bypassed, errors atomic.Uint64
+}
+
+type responseCache struct {
+	mu     sync.RWMutex
+	config CacheConfig
+
+	counters sync.Map // Tenant ID -> *cacheCounters
+}
+
+var cache = &responseCache{}
+
+// ConfigureCache enables, disables or reconfigures the response cache.
+func ConfigureCache(cfg CacheConfig) {
+	if cfg.Enabled && cfg.Store == nil {
+		cfg.Store = NewMemoryCacheStore()
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.config = cfg
+}
+
+// GetCacheStats returns the hit/miss counters of a tenant's calls.
+func GetCacheStats(tenantID string) CacheStats {
+	v, ok := cache.counters.Load(tenantID)
+	if !ok {
+		return CacheStats{}
+	}
+	counters := v.(*cacheCounters)
+	return CacheStats{
+		Hits:     counters.hits.Load(),
+		Misses:   counters.misses.Load(),
+		Bypassed: counters.bypassed.Load(),
+		Errors:   counters.errors.Load(),
+	}
+}
+
+// stats returns the counters of the tenant in ctx.
+func (c *responseCache) stats(ctx context.Context) *cacheCounters {
+	tenantID, _ := CallerFromContext(ctx)
+	v, _ := c.counters.LoadOrStore(tenantID, &cacheCounters{})
+	return v.(*cacheCounters)
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass makes every LLM call made with ctx skip the cache lookup (the fresh answer is still stored).
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// CacheKey is the content address of a chat request.
+func CacheKey(provider, model string, temperature float32, systemPrompt, userPrompt string) string {
+	h := sha256.New()
+	// Length-prefix each part so that different splits of the same text can't collide
+	for _, part := range []string{provider, model, fmt.Sprintf("%.3f", temperature), systemPrompt, userPrompt} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *responseCache) settings(temperature float32) (CacheConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config, c.config.Enabled && temperature <= c.config.MaxTemperature
+}
+
+// scopedKey keeps tenants apart in shared stores such as the in-memory one.
+func scopedKey(ctx context.Context, key string) string {
+	tenantID, _ := CallerFromContext(ctx)
+	return tenantID + "/" + key
+}
+
+func (c *responseCache) lookup(ctx context.Context, key string, temperature float32) (string, bool) {
+	cfg, ok := c.settings(temperature)
+	if !ok {
+		return "", false
+	}
+	if cacheBypassed(ctx) {
+		c.stats(ctx).bypassed.Add(1)
+		return "", false
+	}
+	value, found, err := cfg.Store.Get(ctx, scopedKey(ctx, key))
+	if err != nil {
+		c.stats(ctx).errors.Add(1)
+		log.Printf("Warning: LLM cache lookup failed: %v", err)
+		return "", false
+	}
+	if !found {
+		c.stats(ctx).misses.Add(1)
+		return "", false
+	}
+	c.stats(ctx).hits.Add(1)
+	return value, true
+}
+
+func (c *responseCache) store(ctx context.Context, key string, temperature float32, value string) {
+	cfg, ok := c.settings(temperature)
+	if !ok {
+		return
+	}
+	if err := cfg.Store.Set(ctx, scopedKey(ctx, key), value, cfg.TTL); err != nil {
+		c.stats(ctx).errors.Add(1)
+		log.Printf("Warning: LLM cache store failed: %v", err)
+	}
+}
+
+// --- In-memory store ---
+
+// DefaultMemoryCacheEntries bounds a MemoryCacheStore made by NewMemoryCacheStore.
+const DefaultMemoryCacheEntries = 10000
+
+type memoryEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time // Zero means no expiry
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// MemoryCacheStore is a process-local CacheStore holding at most a fixed number of entries; when
+// full, the least recently used entry is evicted. Expired entries are dropped when read.
+type MemoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element // Key -> element of order holding a *memoryEntry
+	order      *list.List               // Most recently used first
+}
+
+// NewMemoryCacheStore creates an empty in-memory store of DefaultMemoryCacheEntries entries.
+func NewMemoryCacheStore() *MemoryCacheStore {
+	return NewMemoryCacheStoreWithLimit(DefaultMemoryCacheEntries)
+}
+
+// NewMemoryCacheStoreWithLimit creates an empty in-memory store of at most maxEntries entries
+// (DefaultMemoryCacheEntries if maxEntries is not positive).
+func NewMemoryCacheStoreWithLimit(maxEntries int) *MemoryCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMemoryCacheEntries
+	}
+	return &MemoryCacheStore{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (s *MemoryCacheStore) Get(_ context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+	entry := elem.Value.(*memoryEntry)
+	if entry.expired(time.Now()) {
+		s.remove(elem)
+		return "", false, nil
+	}
+	s.order.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+func (s *MemoryCacheStore) Set(_ context.Context, key string, value string, ttl time.Duration) error {
+	entry := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		elem.Value = entry
+		s.order.MoveToFront(elem)
+		return nil
+	}
+	if len(s.entries) >= s.maxEntries {
+		s.remove(s.order.Back())
+	}
+	s.entries[key] = s.order.PushFront(entry)
+	return nil
+}
+
+func (s *MemoryCacheStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.entries, elem.Value.(*memoryEntry).key)
+}
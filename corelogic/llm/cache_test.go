@@ -0,0 +1,149 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryCacheStoreWithLimit(3)
+	for _, key := range []string{"a", "b", "c"} {
+		s.Set(ctx, key, "value "+key, 0)
+	}
+	// Reading a makes b the least recently used entry
+	if _, found, _ := s.Get(ctx, "a"); !found {
+		t.Fatal("a not found")
+	}
+	s.Set(ctx, "d", "value d", 0)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		value, found, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		if found != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, found, want)
+		}
+		if found && value != "value "+key {
+			t.Errorf("Get(%q) = %q, want %q", key, value, "value "+key)
+		}
+	}
+
+	// Replacing an entry neither grows the store nor evicts another
+	s.Set(ctx, "c", "new value", 0)
+	if value, _, _ := s.Get(ctx, "c"); value != "new value" {
+		t.Errorf("Get(c) after replacing it = %q, want %q", value, "new value")
+	}
+	if len(s.entries) != 3 || s.order.Len() != 3 {
+		t.Errorf("store holds %d entries (%d in order), want 3", len(s.entries), s.order.Len())
+	}
+}
+
+func TestMemoryCacheStoreDefaultLimit(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryCacheStore()
+	for i := 0; i <= DefaultMemoryCacheEntries; i++ {
+		s.Set(ctx, fmt.Sprint(i), "v", 0)
+	}
+	if len(s.entries) != DefaultMemoryCacheEntries {
+		t.Errorf("store holds %d entries, want %d", len(s.entries), DefaultMemoryCacheEntries)
+	}
+	if _, found, _ := s.Get(ctx, "0"); found {
+		t.Error("oldest entry not evicted")
+	}
+	if _, found, _ := s.Get(ctx, fmt.Sprint(DefaultMemoryCacheEntries)); !found {
+		t.Error("newest entry evicted")
+	}
+	if s := NewMemoryCacheStoreWithLimit(0); s.maxEntries != DefaultMemoryCacheEntries {
+		t.Errorf("limit 0 gives %d entries, want %d", s.maxEntries, DefaultMemoryCacheEntries)
+	}
+}
+
+func TestMemoryCacheStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryCacheStoreWithLimit(10)
+	s.Set(ctx, "short", "v", time.Millisecond)
+	s.Set(ctx, "long", "v", time.Hour)
+	s.Set(ctx, "forever", "v", 0)
+	time.Sleep(5 * time.Millisecond)
+
+	for key, want := range map[string]bool{"short": false, "long": true, "forever": true} {
+		if _, found, _ := s.Get(ctx, key); found != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, found, want)
+		}
+	}
+	if _, ok := s.entries["short"]; ok {
+		t.Error("expired entry kept after it was read")
+	}
+}
+
+// useCache configures the package cache with a fresh in-memory store and counters for the test.
+func useCache(t *testing.T, maxTemperature float32) {
+	t.Helper()
+	cache.counters.Clear()
+	ConfigureCache(CacheConfig{Enabled: true, TTL: time.Hour, MaxTemperature: maxTemperature})
+	t.Cleanup(func() { ConfigureCache(CacheConfig{}) })
+}
+
+func TestResponseCacheTenantScoping(t *testing.T) {
+	useCache(t, 0)
+	tenantA := WithCaller(context.Background(), "cache-test-a", "alice")
+	tenantB := WithCaller(context.Background(), "cache-test-b", "bob")
+	otherUser := WithCaller(context.Background(), "cache-test-a", "carol")
+
+	cache.store(tenantA, "key", 0, "answer for a")
+	if value, ok := cache.lookup(tenantA, "key", 0); !ok || value != "answer for a" {
+		t.Errorf("lookup by tenant a = %q, %v; want its own answer", value, ok)
+	}
+	if value, ok := cache.lookup(otherUser, "key", 0); !ok || value != "answer for a" {
+		t.Errorf("lookup by another user of tenant a = %q, %v; want the tenant's answer", value, ok)
+	}
+	if value, ok := cache.lookup(tenantB, "key", 0); ok {
+		t.Errorf("lookup by tenant b = %q; want a miss", value)
+	}
+
+	if got, want := GetCacheStats("cache-test-a"), (CacheStats{Hits: 2}); got != want {
+		t.Errorf("stats of tenant a = %+v, want %+v", got, want)
+	}
+	if got, want := GetCacheStats("cache-test-b"), (CacheStats{Misses: 1}); got != want {
+		t.Errorf("stats of tenant b = %+v, want %+v", got, want)
+	}
+	if got := GetCacheStats("cache-test-unknown"); got != (CacheStats{}) {
+		t.Errorf("stats of a tenant without calls = %+v, want zero", got)
+	}
+}
+
+func TestResponseCacheBypass(t *testing.T) {
+	useCache(t, 0)
+	ctx := WithCaller(context.Background(), "cache-test-bypass", "")
+	cache.store(ctx, "key", 0, "old answer")
+
+	bypass := WithCacheBypass(ctx)
+	if value, ok := cache.lookup(bypass, "key", 0); ok {
+		t.Errorf("lookup with bypass = %q; want a miss", value)
+	}
+	// The fresh answer is still stored
+	cache.store(bypass, "key", 0, "new answer")
+	if value, ok := cache.lookup(ctx, "key", 0); !ok || value != "new answer" {
+		t.Errorf("lookup after a bypassed call = %q, %v; want the fresh answer", value, ok)
+	}
+	if got, want := GetCacheStats("cache-test-bypass"), (CacheStats{Hits: 1, Bypassed: 1}); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestResponseCacheTemperature(t *testing.T) {
+	useCache(t, 0.2)
+	ctx := WithCaller(context.Background(), "cache-test-temperature", "")
+	cache.store(ctx, "sampled", 0.7, "answer")
+	if _, ok := cache.lookup(ctx, "sampled", 0.7); ok {
+		t.Error("sampled call answered from the cache")
+	}
+	cache.store(ctx, "deterministic", 0.2, "answer")
+	if _, ok := cache.lookup(ctx, "deterministic", 0.2); !ok {
+		t.Error("call at the maximum temperature not cached")
+	}
+}
@@ -0,0 +1,223 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
+)
+
+// ProviderLocal is an OpenAI-compatible server (llama.cpp, vLLM, Ollama, ...) reachable at LOCAL_LLM_BASE_URL.
+const ProviderLocal = "local"
+
+// ProviderConfig describes how to reach an OpenAI-compatible provider.
+type ProviderConfig struct {
+	BaseURL string // Empty means the official OpenAI endpoint
+	APIKey  string // Used when the caller doesn't pass a key (non-OpenAI providers)
+
+	StructuredOutputs bool // Provider honours json_schema response formats
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderConfig{
+		ProviderOpenAI: {},
+	}
+)
+
+// RegisterProvider adds or replaces an OpenAI-compatible provider.
+func RegisterProvider(name string, cfg ProviderConfig) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = cfg
+}
+
+// Calls at or below this temperature are treated as deterministic and may be collapsed
+// with identical concurrent calls. Sampled calls (e.g. validation trials) never are.
+const maxSharedTemperature float32 = 0.2
+
+// Default limits on in-flight provider requests; see ConfigureConcurrency.
+const (
+	defaultMaxInFlight          = 16
+	defaultMaxInFlightPerTenant = 4
+)
+
+// sharedHTTPClient is reused by every provider client so connections are kept alive between calls.
+var sharedHTTPClient = &http.Client{
+	Transport: func() http.RoundTripper {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConns = 100
+		t.MaxIdleConnsPerHost = 32
+		t.IdleConnTimeout = 90 * time.Second
+		return t
+	}(),
+}
+
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*openai.Client
+
+	global           *semaphore.Weighted
+	tenants          map[string]*semaphore.Weighted
+	perTenant        int64
+	perTenantByID    map[string]int64
+	inflightRequests singleflight.Group
+}
+
+var pool = &clientPool{
+	clients:       make(map[string]*openai.Client),
+	global:        semaphore.NewWeighted(defaultMaxInFlight),
+	tenants:       make(map[string]*semaphore.Weighted),
+	perTenant:     defaultMaxInFlightPerTenant,
+	perTenantByID: make(map[string]int64),
+}
+
+// ConfigureConcurrency sets the global and default per-tenant caps on in-flight provider requests.
+// It should be called at startup, before any LLM traffic.
+func ConfigureConcurrency(maxInFlight, maxInFlightPerTenant int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if maxInFlight > 0 {
+		pool.global = semaphore.NewWeighted(int64(maxInFlight))
+	}
+	if maxInFlightPerTenant > 0 {
+		pool.perTenant = int64(maxInFlightPerTenant)
+	}
+	pool.tenants = make(map[string]*semaphore.Weighted)
+}
+
+// SetTenantMaxInFlight overrides the per-tenant in-flight cap for one tenant.
+func SetTenantMaxInFlight(tenantID string, maxInFlight int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if maxInFlight > 0 {
+		pool.perTenantByID[tenantID] = int64(maxInFlight)
+	} else {
+		delete(pool.perTenantByID, tenantID)
+	}
+	delete(pool.tenants, tenantID)
+}
+
+// client returns the long-lived client for the provider and tenant in ctx, creating it on first use.
+func (p *clientPool) client(ctx context.Context, provider, apiKey string) (*openai.Client, error) {
+	providersMu.RLock()
+	providerCfg, ok := providers[provider]
+	providersMu.RUnlock()
+	if !ok && provider == ProviderLocal {
+		// Read lazily so values loaded from .env after package init are honoured
+		providerCfg, ok = ProviderConfig{BaseURL: os.Getenv("LOCAL_LLM_BASE_URL"), APIKey: os.Getenv("LOCAL_LLM_API_KEY")}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported llm provider: %s", provider)
+	}
+	if provider != ProviderOpenAI {
+		if providerCfg.BaseURL == "" {
+			return nil, fmt.Errorf("llm provider %s has no base URL configured", provider)
+		}
+		apiKey = providerCfg.APIKey
+	}
+	tenantID, _ := CallerFromContext(ctx)
+	// The key hash distinguishes rotated keys without keeping the secret in the map key
+	keyHash := sha256.Sum256([]byte(apiKey))
+	id := provider + "/" + tenantID + "/" + hex.EncodeToString(keyHash[:8])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clients[id]; ok {
+		return c, nil
+	}
+	cfg := openai.DefaultConfig(apiKey)
+	if providerCfg.BaseURL != "" {
+		cfg.BaseURL = providerCfg.BaseURL
+	}
+	cfg.HTTPClient = sharedHTTPClient
+	c := openai.NewClientWithConfig(cfg)
+	p.clients[id] = c
+	return c, nil
+}
+
+// acquire blocks until both a global and a per-tenant request slot are free.
+func (p *clientPool) acquire(ctx context.Context) (release func(), err error) {
+	tenantID, _ := CallerFromContext(ctx)
+
+	p.mu.Lock()
+	global := p.global
+	tenantSem, ok := p.tenants[tenantID]
+	if !ok {
+		limit, overridden := p.perTenantByID[tenantID]
+		if !overridden {
+			limit = p.perTenant
+		}
+		tenantSem = semaphore.NewWeighted(limit)
+		p.tenants[tenantID] = tenantSem
+	}
+	p.mu.Unlock()
+
+	// Take the tenant slot first so one busy tenant can't hold global slots while it waits on itself
+	if err := tenantSem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	if err := global.Acquire(ctx, 1); err != nil {
+		tenantSem.Release(1)
+		return nil, err
+	}
+	return func() {
+		global.Release(1)
+		tenantSem.Release(1)
+	}, nil
+}
+
+// sharedCallTimeout bounds a shared call. It runs detached from its callers' contexts, so the
+// caller that started it giving up doesn't fail the others waiting on it.
+const sharedCallTimeout = 3 * time.Minute
+
+// callUsage is the token usage of a provider call.
+type callUsage struct {
+	model              string
+	prompt, completion int
+}
+
+type sharedResult struct {
+	value any
+	usage callUsage
+}
+
+// shared collapses identical concurrent calls into one. Every caller reserves its quotas for the
+// call before joining it, waits on its own ctx and is charged the call's usage, as if it had made
+// the call itself; a caller over its limits can't get answers by joining another's call. fn's
+// first provider request uses the reservation of the caller that runs it (see takeReservation).
+func (p *clientPool) shared(ctx context.Context, key string, estTokens int, fn func(ctx context.Context) (any, callUsage, error)) (any, error) {
+	if err := quotas.reserve(ctx, estTokens); err != nil {
+		return nil, err
+	}
+	ran := false
+	ch := p.inflightRequests.DoChan(scopedKey(ctx, key), func() (any, error) {
+		ran = true
+		callCtx, cancel := context.WithTimeout(withReservation(context.WithoutCancel(ctx)), sharedCallTimeout)
+		defer cancel()
+		value, usage, err := fn(callCtx)
+		return sharedResult{value: value, usage: usage}, err
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		result := res.Val.(sharedResult)
+		if !ran {
+			// The call recorded its usage for the caller that ran it; record it against this one's reservation
+			quotas.record(ctx, result.usage.model, result.usage.prompt, result.usage.completion)
+		}
+		return result.value, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
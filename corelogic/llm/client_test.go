@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// resetUsage forgets all recorded usage, so tests start with empty windows.
+func resetUsage() {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	quotas.usage = make(map[string]*usageCounter)
+}
+
+func TestSharedReservesQuotaBeforeJoining(t *testing.T) {
+	resetUsage()
+	SetTenantLimits("shared-test", TenantLimits{PerUser: Limits{RequestsPerMinute: 1}})
+	alice := WithCaller(context.Background(), "shared-test", "alice")
+	bob := WithCaller(context.Background(), "shared-test", "bob")
+
+	// Bob has used up his request of this minute
+	if err := quotas.reserve(bob, 1); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	reserved := make(chan [2]bool, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.shared(alice, "key", 1, func(ctx context.Context) (any, callUsage, error) {
+			close(started)
+			<-release
+			// The first provider request uses Alice's reservation, later ones reserve again
+			reserved <- [2]bool{takeReservation(ctx), takeReservation(ctx)}
+			return "answer", callUsage{model: "gpt-4o", prompt: 100}, nil
+		})
+		done <- err
+	}()
+	<-started
+
+	_, err := pool.shared(bob, "key", 1, func(ctx context.Context) (any, callUsage, error) {
+		t.Error("Bob's call ran")
+		return nil, callUsage{}, nil
+	})
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Bob joining the call: err = %v, want a rate limit QuotaError", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Alice's call: %v", err)
+	}
+	if got := <-reserved; got != [2]bool{true, false} {
+		t.Errorf("takeReservation in the shared call = %v, want [true false]", got)
+	}
+	quotas.mu.Lock()
+	requests := len(quotas.usage["u:shared-test/alice"].requests)
+	quotas.mu.Unlock()
+	if requests != 1 {
+		t.Errorf("Alice was counted %d requests, want 1", requests)
+	}
+}
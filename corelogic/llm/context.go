@@ -0,0 +1,25 @@
+package llm
+
+import "context"
+
+type ctxKey int
+
+const (
+	tenantCtxKey ctxKey = iota
+	userCtxKey
+)
+
+// WithCaller attaches the tenant (company) and user issuing LLM requests to ctx.
+// Quotas and other per-tenant behaviour in this package are keyed off these values.
+func WithCaller(ctx context.Context, tenantID, userID string) context.Context {
+	ctx = context.WithValue(ctx, tenantCtxKey, tenantID)
+	return context.WithValue(ctx, userCtxKey, userID)
+}
+
+// CallerFromContext returns the tenant and user previously set with WithCaller.
+// Both are empty when the call does not originate from a tenant request (e.g. CLI tests).
+func CallerFromContext(ctx context.Context) (tenantID, userID string) {
+	tenantID, _ = ctx.Value(tenantCtxKey).(string)
+	userID, _ = ctx.Value(userCtxKey).(string)
+	return tenantID, userID
+}
@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// CreateEmbedding embeds a single text with the given embedding model, a bare OpenAI model name or
+// "provider:model" (e.g. "local:nomic-embed-text" for a server at LOCAL_LLM_BASE_URL).
+// Like CallChatCompletion, it is subject to the caller's tenant/user quotas.
+func CreateEmbedding(ctx context.Context, text string, apiKey string, model openai.EmbeddingModel, dimensions int) ([]float32, error) {
+	spec := ParseModelSpec(string(model))
+	client, err := pool.client(ctx, spec.Provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	// Other providers' models have a fixed size and may reject the dimensions parameter
+	requested := dimensions
+	if spec.Provider != ProviderOpenAI {
+		requested = 0
+	}
+
+	// Embeddings are deterministic, so identical concurrent requests always share one call
+	key := CacheKey(spec.Provider, spec.Model, 0, fmt.Sprintf("dimensions=%d", dimensions), text)
+	result, err := pool.shared(ctx, key, estimateTokens(text), func(ctx context.Context) (any, callUsage, error) {
+		release, err := pool.acquire(ctx)
+		if err != nil {
+			return nil, callUsage{}, err
+		}
+		defer release()
+
+		req := openai.EmbeddingRequest{
+			Input:      []string{text},
+			Model:      openai.EmbeddingModel(spec.Model),
+			Dimensions: requested,
+		}
+		resp, err := client.CreateEmbeddings(ctx, req)
+		if err != nil {
+			return nil, callUsage{}, fmt.Errorf("%s CreateEmbeddings failed: %w", spec.Provider, err)
+		}
+		usage := callUsage{model: spec.Model, prompt: resp.Usage.PromptTokens}
+		quotas.record(ctx, usage.model, usage.prompt, 0)
+		return resp, usage, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := result.(openai.EmbeddingResponse)
+	if len(resp.Data) == 0 || len(resp.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("received empty embedding from %s", spec.Provider)
+	}
+	if n := len(resp.Data[0].Embedding); dimensions > 0 && n != dimensions {
+		return nil, fmt.Errorf("%s returned a %d-dimensional embedding, expected %d", spec, n, dimensions)
+	}
+	return resp.Data[0].Embedding, nil
+}
@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ModelSpec names a model at a provider. It is written "provider:model"; a bare model name means OpenAI.
+type ModelSpec struct {
+	Provider string
+	Model    string
+}
+
+// ParseModelSpec parses "provider:model" or a bare OpenAI model name. The prefix is a provider
+// only if it names a registered provider (or "local"), so model names with a colon such as
+// "llama3:8b" stay bare model names.
+func ParseModelSpec(s string) ModelSpec {
+	if provider, model, ok := strings.Cut(s, ":"); ok && knownProvider(provider) {
+		return ModelSpec{Provider: provider, Model: model}
+	}
+	return ModelSpec{Provider: ProviderOpenAI, Model: s}
+}
+
+func knownProvider(name string) bool {
+	if name == ProviderLocal {
+		return true
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	_, ok := providers[name]
+	return ok
+}
+
+func (m ModelSpec) String() string {
+	return m.Provider + ":" + m.Model
+}
+
+var (
+	fallbackMu     sync.RWMutex
+	fallbackChains = map[string][]ModelSpec{} // Explicit chains set with SetFallbackChain
+)
+
+// defaultFallbacks degrades gpt-4o to gpt-4o-mini and, when LOCAL_LLM_MODEL is set, to the local model.
+func defaultFallbacks(primary ModelSpec) []ModelSpec {
+	if primary != (ModelSpec{Provider: ProviderOpenAI, Model: "gpt-4o"}) {
+		return nil
+	}
+	chain := []ModelSpec{{Provider: ProviderOpenAI, Model: "gpt-4o-mini"}}
+	if localModel := os.Getenv("LOCAL_LLM_MODEL"); localModel != "" {
+		chain = append(chain, ModelSpec{Provider: ProviderLocal, Model: localModel})
+	}
+	return chain
+}
+
+// SetFallbackChain configures the models tried, in order, when primary is unavailable.
+// An empty chain disables fallback for primary.
+func SetFallbackChain(primary string, fallbacks []string) {
+	specs := make([]ModelSpec, 0, len(fallbacks))
+	for _, f := range fallbacks {
+		specs = append(specs, ParseModelSpec(f))
+	}
+	fallbackMu.Lock()
+	defer fallbackMu.Unlock()
+	fallbackChains[ParseModelSpec(primary).String()] = specs
+}
+
+// modelChain returns the primary model followed by its fallbacks.
+func modelChain(primary string) []ModelSpec {
+	spec := ParseModelSpec(primary)
+	fallbackMu.RLock()
+	fallbacks, explicit := fallbackChains[spec.String()]
+	fallbackMu.RUnlock()
+	if !explicit {
+		fallbacks = defaultFallbacks(spec)
+	}
+	return append([]ModelSpec{spec}, fallbacks...)
+}
+
+// shouldFallback reports whether err means "this model is unavailable" (open circuit, provider
+// failure) rather than a problem that another model would hit too (quota, cancelled or rejected
+// request).
+func shouldFallback(ctx context.Context, err error) bool {
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) || ctx.Err() != nil {
+		return false
+	}
+	return errors.Is(err, ErrCircuitOpen) || providerFailure(err)
+}
@@ -11,32 +11,56 @@ import (
 
 const maxRetries = 3 // Number of retries for LLM calls
 
+// ChatRequest is a single system+user chat completion request.
+type ChatRequest struct {
+	SystemPrompt string
+	UserPrompt   string
+	APIKey       string // OpenAI key; other providers use their registered key
+	Model        string // Primary model, optionally "provider:model"
+	Temperature  float32
+	Schema       *JSONSchema // Optional: constrain the answer to this JSON schema where supported
+}
+
+// ChatResponse carries the answer together with the model that actually produced it.
+type ChatResponse struct {
+	Content      string
+	Provider     string
+	Model        string
+	FallbackUsed bool // True when the primary model failed and a fallback answered
+	Structured   bool // True when Content is JSON constrained by the request's Schema
+}
+
 // CallChatCompletion sends distinct system and user prompts to OpenAI chat completion and returns the response.
 // It handles basic retries on failure.
 func CallChatCompletion(ctx context.Context, systemPrompt, userPrompt string, apiKey string, model string, temperature float32) (string, error) {
-	client := openai.NewClient(apiKey)
-	var lastErr error
+	resp, err := Chat(ctx, ChatRequest{SystemPrompt: systemPrompt, UserPrompt: userPrompt, APIKey: apiKey, Model: model, Temperature: temperature})
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
 
+// Chat sends the request to its model and, if that model is unavailable, walks the model's fallback chain.
+func Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
 	// Construct the messages slice based on provided prompts
 	messages := []openai.ChatCompletionMessage{}
-	if systemPrompt != "" {
+	if req.SystemPrompt != "" {
 		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: systemPrompt,
+			Content: req.SystemPrompt,
 		})
 	}
-	if userPrompt == "" {
+	if req.UserPrompt == "" {
 		// Should generally not happen if called correctly, but good to check
-		return "", fmt.Errorf("userPrompt cannot be empty")
+		return nil, fmt.Errorf("userPrompt cannot be empty")
 	}
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
-		Content: userPrompt,
+		Content: req.UserPrompt,
 	})
 
-
 		// --- ADDED: Log the messages being sent ---
-		log.Printf("[Go LLM Call] Sending %d messages to LLM (model: %s):", len(messages), model)
+		log.Printf("[Go LLM Call] Sending %d messages to LLM (model: %s):", len(messages), req.Model)
 		for i, msg := range messages {
 			// Log role and start of content (limit length for readability)
 			contentSnippet := msg.Content
@@ -51,40 +75,149 @@ func CallChatCompletion(ctx context.Context, systemPrompt, userPrompt string, ap
 		}
 		// --- END ADDED LOGGING ---
 
+	var lastErr error
+	chain := modelChain(req.Model)
+	for i, spec := range chain {
+		content, structured, err := chatWithModel(ctx, spec, messages, req)
+		if err == nil {
+			if i > 0 {
+				log.Printf("[Go LLM Call] Answer produced by fallback model %s (primary %s unavailable)", spec, chain[0])
+			}
+			return &ChatResponse{Content: content, Provider: spec.Provider, Model: spec.Model, FallbackUsed: i > 0, Structured: structured}, nil
+		}
+		if !shouldFallback(ctx, err) {
+			return nil, err
+		}
+		lastErr = err
+		if i+1 < len(chain) {
+			log.Printf("Warning: model %s unavailable (%v). Falling back to %s", spec, err, chain[i+1])
+		}
+	}
+	return nil, fmt.Errorf("all %d models in fallback chain failed: %w", len(chain), lastErr)
+}
+
+// chatWithModel serves one model of the chain: cache, singleflight, then the provider with retries.
+// It reports whether the answer was constrained by the request's schema.
+func chatWithModel(ctx context.Context, spec ModelSpec, messages []openai.ChatCompletionMessage, req ChatRequest) (string, bool, error) {
+	client, err := pool.client(ctx, spec.Provider, req.APIKey)
+	if err != nil {
+		return "", false, err
+	}
+	format := responseFormat(spec, req.Schema)
+	structured := format != nil
+	systemPrompt := req.SystemPrompt
+	if structured && req.Schema.Note != "" {
+		// Only models that are actually constrained are told to answer with the schema
+		messages = withSchemaNote(messages, req.Schema.Note)
+		systemPrompt += req.Schema.Note
+	}
+
+	// Serve deterministic calls from the response cache when possible
+	cacheKey := CacheKey(spec.Provider, spec.Model, req.Temperature, systemPrompt+formatFingerprint(format), req.UserPrompt)
+	if cached, ok := cache.lookup(ctx, cacheKey, req.Temperature); ok {
+		log.Printf("[Go LLM Call] Cache hit for model %s", spec)
+		return cached, structured, nil
+	}
+
+	call := func(ctx context.Context) (any, callUsage, error) {
+		content, usage, err := completeWithRetries(ctx, client, spec, messages, req.Temperature, format, estimateTokens(req.SystemPrompt, req.UserPrompt))
+		if err != nil {
+			return "", usage, err
+		}
+		cache.store(ctx, cacheKey, req.Temperature, content)
+		return content, usage, nil
+	}
+
+	// Collapse identical concurrent deterministic calls (e.g. batch runs over the same asset) into one request
+	var result any
+	if req.Temperature <= maxSharedTemperature {
+		result, err = pool.shared(ctx, cacheKey, estimateTokens(req.SystemPrompt, req.UserPrompt), call)
+	} else {
+		result, _, err = call(ctx)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return result.(string), structured, nil
+}
+
+// completeWithRetries performs the provider call, holding a concurrency slot only while a request is in flight.
+// While the model's circuit breaker is open it fails fast instead of retrying.
+func completeWithRetries(ctx context.Context, client *openai.Client, spec ModelSpec, messages []openai.ChatCompletionMessage, temperature float32, format *openai.ChatCompletionResponseFormat, estTokens int) (string, callUsage, error) {
+	req := openai.ChatCompletionRequest{
+		Model:       spec.Model, // e.g., openai.GPT4o, openai.GPT4oMini, or specific string IDs
+		Messages:    messages,
+		Temperature: temperature,
+		ResponseFormat: format,
+		// Add other parameters like MaxTokens if needed
+	}
+	msg, usage, err := sendWithRetries(ctx, client, spec, req, estTokens)
+	if err != nil {
+		return "", usage, err
+	}
+	return msg.Content, usage, nil
+}
+
+// sendWithRetries sends one chat completion request with quota, circuit breaker and concurrency checks,
+// retrying failures. It returns the first choice's message, which has content or tool calls, and the
+// usage it recorded against the caller's quotas.
+func sendWithRetries(ctx context.Context, client *openai.Client, spec ModelSpec, req openai.ChatCompletionRequest, estTokens int) (openai.ChatCompletionMessage, callUsage, error) {
+	var lastErr error
+	model := spec.Model
+	breaker := breakers.get(spec.Provider, spec.Model)
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		req := openai.ChatCompletionRequest{
-			Model:       model, // e.g., openai.GPT4o, openai.GPT4oMini, or specific string IDs
-			Messages:    messages,
-			Temperature: temperature,
-			// Add other parameters like MaxTokens if needed
+		// Check the breaker first, so calls it rejects don't use up the caller's quota
+		if err := breaker.allow(spec.String()); err != nil {
+			return openai.ChatCompletionMessage{}, callUsage{}, err
+		}
+		// Enforce tenant/user quotas before every provider call, unless the caller of a shared call
+		// reserved them already; quota errors are not retried
+		if !takeReservation(ctx) {
+			if err := quotas.reserve(ctx, estTokens); err != nil {
+				breaker.release()
+				log.Printf("LLM call rejected by quota: %v", err)
+				return openai.ChatCompletionMessage{}, callUsage{}, err
+			}
 		}
 
+		release, err := pool.acquire(ctx)
+		if err != nil {
+			breaker.release()
+			return openai.ChatCompletionMessage{}, callUsage{}, err
+		}
 		resp, err := client.CreateChatCompletion(ctx, req)
+		release()
+		if err == nil && (len(resp.Choices) == 0 || (resp.Choices[0].Message.Content == "" && len(resp.Choices[0].Message.ToolCalls) == 0)) {
+			err = fmt.Errorf("returned empty response choice")
+		}
 		if err != nil {
+			breaker.failure(spec.String(), err)
 			// Append attempt number and model to the error context
 			err = fmt.Errorf("attempt %d using model %s failed: %w", attempt+1, model, err)
 			lastErr = err // Store the last error encountered
+			if ctx.Err() != nil {
+				return openai.ChatCompletionMessage{}, callUsage{}, err
+			}
 			log.Printf("Warning: %v. Retrying in %d seconds...", err, (attempt+1)*2)
 			// Implement more sophisticated backoff if needed
-			time.Sleep(time.Duration(attempt+1) * 2 * time.Second)
-			continue // Retry
-		}
-
-		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
-			lastErr = fmt.Errorf("openai attempt %d using model %s returned empty response choice", attempt+1, model)
-			log.Printf("Warning: %v. Retrying in %d seconds...", lastErr, (attempt+1)*2)
-			time.Sleep(time.Duration(attempt+1) * 2 * time.Second)
+			select {
+			case <-time.After(time.Duration(attempt+1) * 2 * time.Second):
+			case <-ctx.Done():
+				return openai.ChatCompletionMessage{}, callUsage{}, err
+			}
 			continue // Retry
 		}
 
 		// Success
+		breaker.success()
 		log.Printf("LLM call successful on attempt %d.", attempt+1)
-		// Optionally log token usage: log.Printf("Usage: %+v", resp.Usage)
-		return resp.Choices[0].Message.Content, nil
+		usage := callUsage{model: model, prompt: resp.Usage.PromptTokens, completion: resp.Usage.CompletionTokens}
+		quotas.record(ctx, usage.model, usage.prompt, usage.completion)
+		return resp.Choices[0].Message, usage, nil
 	}
 
 	// All retries failed
 	log.Printf("Error: LLM call failed after %d attempts.", maxRetries)
 	// Return the *last* error encountered during retries
-	return "", fmt.Errorf("llm call failed after %d attempts: %w", maxRetries, lastErr)
-}
\ No newline at end of file
+	return openai.ChatCompletionMessage{}, callUsage{}, fmt.Errorf("llm call failed after %d attempts: %w", maxRetries, lastErr)
+}
@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits caps the LLM usage of a tenant, or of a single user within a tenant.
+// A zero value for any field means that dimension is unlimited.
+type Limits struct {
+	RequestsPerMinute int     `json:"requests_per_minute"`
+	TokensPerDay      int     `json:"tokens_per_day"`
+	MonthlyBudgetUSD  float64 `json:"monthly_budget_usd"`
+}
+
+// TenantLimits holds the limits applied to a tenant as a whole and to each of its users.
+type TenantLimits struct {
+	Tenant  Limits `json:"tenant"`
+	PerUser Limits `json:"per_user"`
+}
+
+var (
+	// ErrRateLimited is returned when a request-rate or daily token limit is hit (HTTP 429).
+	ErrRateLimited = errors.New("llm rate limit exceeded")
+	// ErrBudgetExceeded is returned when the monthly spending budget is used up (HTTP 402).
+	ErrBudgetExceeded = errors.New("llm monthly budget exceeded")
+)
+
+// QuotaError describes which limit rejected a call. It wraps ErrRateLimited or ErrBudgetExceeded.
+type QuotaError struct {
+	Scope      string        // "tenant <id>" or "user <id> of tenant <id>"
+	Limit      string        // Which limit was hit, e.g. "requests_per_minute"
+	RetryAfter time.Duration // When the limit frees up again
+	err        error
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v: %s limit reached for %s (retry after %s)", e.err, e.Limit, e.Scope, e.RetryAfter.Round(time.Second))
+}
+
+func (e *QuotaError) Unwrap() error { return e.err }
+
+// Prices in USD per 1M tokens (input, output). Unknown models are not charged against the budget.
+var modelPrices = map[string][2]float64{
+	"gpt-4o":                 {2.50, 10.00},
+	"gpt-4o-mini":            {0.15, 0.60},
+	"text-embedding-3-large": {0.13, 0},
+	"text-embedding-3-small": {0.02, 0},
+}
+
+func estimateCost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := modelPrices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price[0] + float64(completionTokens)*price[1]) / 1e6
+}
+
+// estimateTokens is a rough pre-call estimate (~4 characters per token) used for the daily token check.
+func estimateTokens(texts ...string) int {
+	n := 0
+	for _, t := range texts {
+		n += len(t)/4 + 1
+	}
+	return n
+}
+
+// usageCounter tracks consumption for one tenant or user.
+type usageCounter struct {
+	requests []time.Time // Request timestamps within the last minute
+	day      string
+	tokens   int
+	month    string
+	spentUSD float64
+}
+
+type quotaManager struct {
+	mu       sync.Mutex
+	limits   map[string]TenantLimits
+	defaults TenantLimits
+	usage    map[string]*usageCounter
+	now      func() time.Time
+}
+
+var quotas = &quotaManager{
+	limits: make(map[string]TenantLimits),
+	usage:  make(map[string]*usageCounter),
+	now:    time.Now,
+}
+
+// SetTenantLimits configures the limits for a tenant. It is normally called from the tenant registry at startup.
+func SetTenantLimits(tenantID string, limits TenantLimits) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	quotas.limits[tenantID] = limits
+}
+
+// SetDefaultLimits configures the limits for tenants that have no explicit entry.
+func SetDefaultLimits(limits TenantLimits) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	quotas.defaults = limits
+}
+
+func (q *quotaManager) counter(key string, now time.Time) *usageCounter {
+	c, ok := q.usage[key]
+	if !ok {
+		c = &usageCounter{}
+		q.usage[key] = c
+	}
+	// Reset the daily and monthly windows when they roll over
+	if day := now.Format("2006-01-02"); c.day != day {
+		c.day, c.tokens = day, 0
+	}
+	if month := now.Format("2006-01"); c.month != month {
+		c.month, c.spentUSD = month, 0
+	}
+	// Drop request timestamps older than one minute
+	cutoff := now.Add(-time.Minute)
+	kept := c.requests[:0]
+	for _, t := range c.requests {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	c.requests = kept
+	return c
+}
+
+func (c *usageCounter) check(limits Limits, scope string, estTokens int, now time.Time) error {
+	if limits.MonthlyBudgetUSD > 0 && c.spentUSD >= limits.MonthlyBudgetUSD {
+		year, month, _ := now.Date()
+		nextMonth := time.Date(year, month+1, 1, 0, 0, 0, 0, now.Location())
+		return &QuotaError{Scope: scope, Limit: "monthly_budget_usd", RetryAfter: nextMonth.Sub(now), err: ErrBudgetExceeded}
+	}
+	if limits.RequestsPerMinute > 0 && len(c.requests) >= limits.RequestsPerMinute {
+		return &QuotaError{Scope: scope, Limit: "requests_per_minute", RetryAfter: c.requests[0].Add(time.Minute).Sub(now), err: ErrRateLimited}
+	}
+	if limits.TokensPerDay > 0 && c.tokens+estTokens > limits.TokensPerDay {
+		year, month, day := now.Date()
+		tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+		return &QuotaError{Scope: scope, Limit: "tokens_per_day", RetryAfter: tomorrow.Sub(now), err: ErrRateLimited}
+	}
+	return nil
+}
+
+// scopes returns the usage keys and limits that apply to the caller in ctx.
+func (q *quotaManager) scopes(ctx context.Context) (keys []string, limits []Limits, names []string) {
+	tenantID, userID := CallerFromContext(ctx)
+	if tenantID == "" {
+		return nil, nil, nil // Not a tenant request: nothing to enforce
+	}
+	tl, ok := q.limits[tenantID]
+	if !ok {
+		tl = q.defaults
+	}
+	keys = append(keys, "t:"+tenantID)
+	limits = append(limits, tl.Tenant)
+	names = append(names, "tenant "+tenantID)
+	if userID != "" {
+		keys = append(keys, "u:"+tenantID+"/"+userID)
+		limits = append(limits, tl.PerUser)
+		names = append(names, fmt.Sprintf("user %s of tenant %s", userID, tenantID))
+	}
+	return keys, limits, names
+}
+
+// reserve checks every limit that applies to the caller before a provider call and,
+// if all pass, counts the request against the per-minute window.
+func (q *quotaManager) reserve(ctx context.Context, estTokens int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	keys, limits, names := q.scopes(ctx)
+	for i, key := range keys {
+		if err := q.counter(key, now).check(limits[i], names[i], estTokens, now); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		c := q.counter(key, now)
+		c.requests = append(c.requests, now)
+	}
+	return nil
+}
+
+// reservationKey marks a context whose caller has reserved its quotas for the next provider call.
+type reservationKey struct{}
+
+// withReservation returns ctx carrying a reservation made with reserve, which the next provider
+// call made with ctx uses up instead of reserving again.
+func withReservation(ctx context.Context) context.Context {
+	r := new(atomic.Bool)
+	r.Store(true)
+	return context.WithValue(ctx, reservationKey{}, r)
+}
+
+// takeReservation reports whether ctx carries an unused reservation, and uses it up.
+func takeReservation(ctx context.Context) bool {
+	r, ok := ctx.Value(reservationKey{}).(*atomic.Bool)
+	return ok && r.Swap(false)
+}
+
+// record adds the actual token usage and cost of a completed provider call.
+func (q *quotaManager) record(ctx context.Context, model string, promptTokens, completionTokens int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	keys, _, _ := q.scopes(ctx)
+	cost := estimateCost(model, promptTokens, completionTokens)
+	for _, key := range keys {
+		c := q.counter(key, now)
+		c.tokens += promptTokens + completionTokens
+		c.spentUSD += cost
+	}
+}
@@ -0,0 +1,187 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestQuotas returns a quota manager whose clock is *now.
+func newTestQuotas(limits TenantLimits, now *time.Time) *quotaManager {
+	return &quotaManager{
+		limits: map[string]TenantLimits{"t1": limits},
+		usage:  make(map[string]*usageCounter),
+		now:    func() time.Time { return *now },
+	}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    TenantLimits
+		calls     int     // Successful calls made before the checked one
+		tokens    int     // Tokens recorded per call
+		estTokens int     // Estimate of the checked call
+		wantErr   error   // nil when the checked call passes
+		wantLimit string  // QuotaError.Limit
+		wantScope string  // QuotaError.Scope
+		spentUSD  float64 // Recorded per call, through gpt-4o prompt tokens
+	}{
+		{name: "unlimited", calls: 100, tokens: 1000, estTokens: 1000},
+		{
+			name:      "requests per minute",
+			limits:    TenantLimits{Tenant: Limits{RequestsPerMinute: 3}},
+			calls:     3,
+			wantErr:   ErrRateLimited,
+			wantLimit: "requests_per_minute",
+			wantScope: "tenant t1",
+		},
+		{
+			name:      "under requests per minute",
+			limits:    TenantLimits{Tenant: Limits{RequestsPerMinute: 3}},
+			calls:     2,
+			estTokens: 10,
+		},
+		{
+			name:      "tokens per day",
+			limits:    TenantLimits{Tenant: Limits{TokensPerDay: 1000}},
+			calls:     1,
+			tokens:    900,
+			estTokens: 200,
+			wantErr:   ErrRateLimited,
+			wantLimit: "tokens_per_day",
+			wantScope: "tenant t1",
+		},
+		{
+			name:      "monthly budget",
+			limits:    TenantLimits{Tenant: Limits{MonthlyBudgetUSD: 1}},
+			calls:     2,
+			spentUSD:  0.5,
+			wantErr:   ErrBudgetExceeded,
+			wantLimit: "monthly_budget_usd",
+			wantScope: "tenant t1",
+		},
+		{
+			name:      "budget is checked before the rate",
+			limits:    TenantLimits{Tenant: Limits{RequestsPerMinute: 1, MonthlyBudgetUSD: 1}},
+			calls:     1,
+			spentUSD:  1,
+			wantErr:   ErrBudgetExceeded,
+			wantLimit: "monthly_budget_usd",
+			wantScope: "tenant t1",
+		},
+		{
+			name:      "per user",
+			limits:    TenantLimits{Tenant: Limits{RequestsPerMinute: 100}, PerUser: Limits{RequestsPerMinute: 2}},
+			calls:     2,
+			wantErr:   ErrRateLimited,
+			wantLimit: "requests_per_minute",
+			wantScope: "user u1 of tenant t1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+			q := newTestQuotas(tt.limits, &now)
+			ctx := WithCaller(context.Background(), "t1", "u1")
+			for i := 0; i < tt.calls; i++ {
+				if err := q.reserve(ctx, 1); err != nil {
+					t.Fatalf("call %d: reserve: %v", i, err)
+				}
+				// $2.50 per 1M gpt-4o prompt tokens
+				q.record(ctx, "gpt-4o", tt.tokens+int(math.Round(tt.spentUSD/2.5*1e6)), 0)
+			}
+
+			err := q.reserve(ctx, tt.estTokens)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("reserve: %v, want no error", err)
+				}
+				return
+			}
+			var quotaErr *QuotaError
+			if !errors.As(err, &quotaErr) || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reserve: %v, want a QuotaError wrapping %v", err, tt.wantErr)
+			}
+			if quotaErr.Limit != tt.wantLimit || quotaErr.Scope != tt.wantScope {
+				t.Errorf("QuotaError limit %q scope %q, want %q %q", quotaErr.Limit, quotaErr.Scope, tt.wantLimit, tt.wantScope)
+			}
+			if quotaErr.RetryAfter <= 0 {
+				t.Errorf("QuotaError.RetryAfter = %v, want > 0", quotaErr.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestQuotaWindowRollover(t *testing.T) {
+	tests := []struct {
+		name           string
+		limits         Limits
+		record         int // gpt-4o prompt tokens recorded after the first call
+		blockedFor     time.Duration
+		wantRetryAfter time.Duration
+	}{
+		{"minute", Limits{RequestsPerMinute: 1}, 0, 59 * time.Second, time.Minute},
+		{"day", Limits{TokensPerDay: 100}, 100, 11*time.Hour + 59*time.Minute, 12 * time.Hour},
+		{"month", Limits{MonthlyBudgetUSD: 1}, 400_000, 17*24*time.Hour + 11*time.Hour, 17*24*time.Hour + 12*time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+			now := start
+			q := newTestQuotas(TenantLimits{Tenant: tt.limits}, &now)
+			ctx := WithCaller(context.Background(), "t1", "")
+			if err := q.reserve(ctx, 0); err != nil {
+				t.Fatalf("first reserve: %v", err)
+			}
+			q.record(ctx, "gpt-4o", tt.record, 0)
+
+			var quotaErr *QuotaError
+			if err := q.reserve(ctx, 1); !errors.As(err, &quotaErr) {
+				t.Fatalf("second reserve: %v, want a QuotaError", err)
+			}
+			if quotaErr.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("RetryAfter = %v, want %v", quotaErr.RetryAfter, tt.wantRetryAfter)
+			}
+			now = start.Add(tt.blockedFor)
+			if err := q.reserve(ctx, 1); err == nil {
+				t.Fatalf("reserve after %v passed, want it blocked until the window rolls over", tt.blockedFor)
+			}
+			now = start.Add(tt.wantRetryAfter)
+			if err := q.reserve(ctx, 1); err != nil {
+				t.Fatalf("reserve after the window rolled over: %v", err)
+			}
+		})
+	}
+}
+
+func TestQuotaWithoutTenant(t *testing.T) {
+	now := time.Now()
+	q := newTestQuotas(TenantLimits{Tenant: Limits{RequestsPerMinute: 1}}, &now)
+	for i := 0; i < 3; i++ {
+		if err := q.reserve(context.Background(), 1000); err != nil {
+			t.Fatalf("reserve without a tenant: %v", err)
+		}
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		model              string
+		prompt, completion int
+		want               float64
+	}{
+		{"gpt-4o", 1_000_000, 0, 2.50},
+		{"gpt-4o", 0, 1_000_000, 10.00},
+		{"gpt-4o-mini", 2_000_000, 1_000_000, 0.90},
+		{"text-embedding-3-small", 500_000, 0, 0.01},
+		{"unknown-model", 1_000_000, 1_000_000, 0},
+	}
+	for _, tt := range tests {
+		if got := estimateCost(tt.model, tt.prompt, tt.completion); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("estimateCost(%q, %d, %d) = %v, want %v", tt.model, tt.prompt, tt.completion, got, tt.want)
+		}
+	}
+}
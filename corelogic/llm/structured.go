@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// JSONSchema asks the provider to constrain the answer to a JSON document matching Schema.
+type JSONSchema struct {
+	Name   string
+	Schema map[string]any
+	Strict bool
+	// Note is appended to the system prompt for the models that are constrained, e.g. to replace
+	// the prompt's own answer format. Models answering unconstrained never see it.
+	Note string
+}
+
+// MarshalJSON lets JSONSchema be passed directly as the provider's schema payload.
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Schema)
+}
+
+// structuredOpenAIModels are the OpenAI models known to honour json_schema response formats.
+// Earlier snapshots of the same families (e.g. gpt-4o-2024-05-13) don't.
+var structuredOpenAIModels = map[string]bool{
+	"gpt-4o":                 true,
+	"gpt-4o-2024-08-06":      true,
+	"gpt-4o-2024-11-20":      true,
+	"gpt-4o-mini":            true,
+	"gpt-4o-mini-2024-07-18": true,
+	"o1":                     true,
+	"o1-2024-12-17":          true,
+	"o3-mini":                true,
+	"o3-mini-2025-01-31":     true,
+}
+
+// structuredOpenAIFamilies are OpenAI model families whose every model honours json_schema.
+var structuredOpenAIFamilies = []string{"gpt-4.1", "gpt-5", "o3", "o4-mini"}
+
+// SupportsStructuredOutputs reports whether the model can be constrained with a JSON schema.
+// OpenAI models are looked up in an allowlist; other providers opt in via LOCAL_LLM_STRUCTURED_OUTPUTS
+// or the StructuredOutputs flag of their ProviderConfig.
+func SupportsStructuredOutputs(spec ModelSpec) bool {
+	switch spec.Provider {
+	case ProviderOpenAI:
+		if structuredOpenAIModels[spec.Model] {
+			return true
+		}
+		for _, family := range structuredOpenAIFamilies {
+			if spec.Model == family || strings.HasPrefix(spec.Model, family+"-") {
+				return true
+			}
+		}
+		return false
+	case ProviderLocal:
+		providersMu.RLock()
+		cfg, ok := providers[ProviderLocal]
+		providersMu.RUnlock()
+		if ok {
+			return cfg.StructuredOutputs
+		}
+		return os.Getenv("LOCAL_LLM_STRUCTURED_OUTPUTS") == "true"
+	default:
+		providersMu.RLock()
+		defer providersMu.RUnlock()
+		return providers[spec.Provider].StructuredOutputs
+	}
+}
+
+// responseFormat returns the provider response format for schema, or nil when the model can't honour it.
+func responseFormat(spec ModelSpec, schema *JSONSchema) *openai.ChatCompletionResponseFormat {
+	if schema == nil || !SupportsStructuredOutputs(spec) {
+		return nil
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   schema.Name,
+			Schema: schema,
+			Strict: schema.Strict,
+		},
+	}
+}
+
+// withSchemaNote appends the schema's note to the system prompt of messages, adding a system
+// message if there is none. messages itself is left unchanged.
+func withSchemaNote(messages []openai.ChatCompletionMessage, note string) []openai.ChatCompletionMessage {
+	if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+		noted := append([]openai.ChatCompletionMessage(nil), messages...)
+		noted[0].Content += note
+		return noted
+	}
+	system := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: strings.TrimLeft(note, "\n")}
+	return append([]openai.ChatCompletionMessage{system}, messages...)
+}
+
+// formatFingerprint distinguishes cache entries produced with and without a schema.
+func formatFingerprint(format *openai.ChatCompletionResponseFormat) string {
+	if format == nil {
+		return ""
+	}
+	b, _ := json.Marshal(format)
+	return "\x00response_format:" + string(b)
+}
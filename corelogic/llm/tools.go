@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Tool is a function the model may call during ChatWithTools.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON schema of the arguments object
+}
+
+// ToolCall is one function call requested by the model.
+type ToolCall struct {
+	Round     int // 1-based model round that requested the call
+	Name      string
+	Arguments string // JSON arguments as produced by the model
+}
+
+// ToolHandler executes a tool call and returns the result text given back to the model.
+// A returned error is reported to the model as the result, so it can correct its call.
+type ToolHandler func(ctx context.Context, call ToolCall) (string, error)
+
+// ToolChatRequest is a ChatRequest in which the model can call tools before answering.
+type ToolChatRequest struct {
+	ChatRequest
+	Tools     []Tool
+	Handle    ToolHandler
+	MaxRounds int // Model rounds that may call tools; the round after that must answer
+}
+
+// ToolCallRecord logs an executed tool call.
+type ToolCallRecord struct {
+	ToolCall
+	Result string
+	Err    error
+}
+
+// ChatWithTools runs a bounded tool-calling loop: while the model asks for tools (at most MaxRounds
+// times) the calls are executed with Handle and their results sent back; then the model must answer.
+// Tool-calling conversations are never cached or shared. If the primary model is unavailable before
+// the first round completes, the model's fallback chain is tried. Structured outputs are not used.
+func ChatWithTools(ctx context.Context, req ToolChatRequest) (*ChatResponse, []ToolCallRecord, error) {
+	if req.UserPrompt == "" {
+		return nil, nil, fmt.Errorf("userPrompt cannot be empty")
+	}
+	if req.MaxRounds <= 0 {
+		return nil, nil, fmt.Errorf("tool chat needs at least one round")
+	}
+	tools := make([]openai.Tool, 0, len(req.Tools))
+	for _, t := range req.Tools {
+		tools = append(tools, openai.Tool{
+			Type:     openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+
+	var lastErr error
+	chain := modelChain(req.Model)
+	for i, spec := range chain {
+		content, records, err := toolLoop(ctx, spec, tools, req)
+		if err == nil {
+			return &ChatResponse{Content: content, Provider: spec.Provider, Model: spec.Model, FallbackUsed: i > 0}, records, nil
+		}
+		// Once tools have run, retrying on another model would repeat their side effects and log
+		if len(records) > 0 || !shouldFallback(ctx, err) {
+			return nil, records, err
+		}
+		lastErr = err
+		if i+1 < len(chain) {
+			log.Printf("Warning: model %s unavailable for tool chat (%v). Falling back to %s", spec, err, chain[i+1])
+		}
+	}
+	return nil, nil, fmt.Errorf("all %d models in fallback chain failed: %w", len(chain), lastErr)
+}
+
+func toolLoop(ctx context.Context, spec ModelSpec, tools []openai.Tool, req ToolChatRequest) (string, []ToolCallRecord, error) {
+	client, err := pool.client(ctx, spec.Provider, req.APIKey)
+	if err != nil {
+		return "", nil, err
+	}
+	var messages []openai.ChatCompletionMessage
+	if req.SystemPrompt != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: req.SystemPrompt})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: req.UserPrompt})
+	estTokens := estimateTokens(req.SystemPrompt, req.UserPrompt)
+
+	var records []ToolCallRecord
+	for round := 1; ; round++ {
+		chatReq := openai.ChatCompletionRequest{
+			Model:       spec.Model,
+			Messages:    messages,
+			Temperature: req.Temperature,
+			Tools:       tools,
+		}
+		if round > req.MaxRounds {
+			chatReq.ToolChoice = "none" // Out of rounds: the model has to answer with what it has
+		}
+		msg, _, err := sendWithRetries(ctx, client, spec, chatReq, estTokens)
+		if err != nil {
+			return "", records, err
+		}
+		if len(msg.ToolCalls) == 0 {
+			if msg.Content == "" {
+				return "", records, fmt.Errorf("model %s finished the tool chat without an answer", spec)
+			}
+			return msg.Content, records, nil
+		}
+		if round > req.MaxRounds {
+			return "", records, fmt.Errorf("model %s kept calling tools after %d rounds", spec, req.MaxRounds)
+		}
+
+		messages = append(messages, msg)
+		for _, tc := range msg.ToolCalls {
+			call := ToolCall{Round: round, Name: tc.Function.Name, Arguments: tc.Function.Arguments}
+			result, err := req.Handle(ctx, call)
+			if ctx.Err() != nil {
+				return "", records, ctx.Err()
+			}
+			records = append(records, ToolCallRecord{ToolCall: call, Result: result, Err: err})
+			if err != nil {
+				log.Printf("[Go LLM Call] Tool %s failed: %v", call.Name, err)
+				errJSON, _ := json.Marshal(map[string]string{"error": err.Error()})
+				result = string(errJSON)
+			} else {
+				log.Printf("[Go LLM Call] Tool %s called in round %d", call.Name, round)
+			}
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleTool, ToolCallID: tc.ID, Content: result})
+			estTokens += estimateTokens(call.Arguments, result)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoObject is returned when no parsable object literal exists in the text.
+var ErrNoObject = errors.New("no object literal found")
+
+// ParseObject parses text that should hold a dictionary. If text as a whole isn't an object
+// literal, the object literals embedded in it (e.g. after some prose) are tried instead,
+// and the last one that parses wins, since LLMs put the final answer at the end.
+// The returned error describes the first parse problem, to help a repair round-trip.
+func ParseObject(text string) (map[string]any, error) {
+	v, firstErr := Parse(text)
+	if obj, ok := v.(map[string]any); ok && firstErr == nil {
+		return obj, nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("expected an object, got %T", v)
+	}
+
+	var found map[string]any
+	for _, candidate := range objectCandidates(StripCodeFences(text)) {
+		v, err := Parse(candidate)
+		if err != nil {
+			continue
+		}
+		if obj, ok := v.(map[string]any); ok {
+			found = obj
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNoObject, firstErr)
+}
+
+// objectCandidates returns every top-level {...} span of text, matching braces while skipping
+// over quoted strings so that braces inside scenario text don't end an object early.
+func objectCandidates(text string) []string {
+	var candidates []string
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' {
+			continue
+		}
+		end := matchBrace(text, start)
+		if end < 0 {
+			continue // Unbalanced from here; an inner '{' may still start a complete object
+		}
+		candidates = append(candidates, text[start:end+1])
+		start = end
+	}
+	return candidates
+}
+
+// matchBrace returns the index of the '}' closing the '{' at start, or -1.
+func matchBrace(text string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			case '\n':
+				// An apostrophe in prose ("driver's") is not a string; give up on it at the line end
+				if quote == '\'' {
+					quote = 0
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
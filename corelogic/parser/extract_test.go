@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseObject(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want map[string]any
+	}{
+		{"plain object", `{"Impact": "Severe"}`, map[string]any{"Impact": "Severe"}},
+		{"python dict", `{'Safety': 'Major', 'Financial': None, 'Final': True}`, map[string]any{"Safety": "Major", "Financial": nil, "Final": true}},
+		{"trailing comma", `{"a": 1, "b": [2,],}`, map[string]any{"a": 1.0, "b": []any{2.0}}},
+		{"fenced", "```json\n{'a': 'b'}\n```", map[string]any{"a": "b"}},
+		{"chatty before and after", "Sure! Here is the result:\n{\"a\": 1}\nLet me know if you need more.", map[string]any{"a": 1.0}},
+		{"fenced inside prose", "Result:\n```json\n{\"a\": 1}\n```\nDone.", map[string]any{"a": 1.0}},
+		{"apostrophe in prose", "The driver's view: {'a': 'x'}", map[string]any{"a": "x"}},
+		{"apostrophe in value", `Answer: {"text": "the attacker's goal"}`, map[string]any{"text": "the attacker's goal"}},
+		{"braces in strings", `Result: {"a": "use } and { carefully"}`, map[string]any{"a": "use } and { carefully"}},
+		{"nested object", `Final: {"a": {"b": {"c": 1}}} end`, map[string]any{"a": map[string]any{"b": map[string]any{"c": 1.0}}}},
+		{"last object wins", "Draft: {\"a\": 1}\nFinal: {\"a\": 2}", map[string]any{"a": 2.0}},
+		{"broken draft then answer", "Draft: {\"a\": \nFinal: {\"a\": 2}", map[string]any{"a": 2.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseObject(tt.text)
+			if err != nil {
+				t.Fatalf("ParseObject(%q) returned error: %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseObject(%q) = %#v, want %#v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseObjectMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"prose", "I could not determine the impact."},
+		{"list", `["a", "b"]`},
+		{"unterminated", `{"a": 1, "b": `},
+		{"unbalanced braces", "{{{"},
+		{"missing value", `Result: {"a": }`},
+		{"unterminated string", `{'a': 'open}`},
+		{"stray closing brace", "} {"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseObject(tt.text)
+			if !errors.Is(err, ErrNoObject) {
+				t.Fatalf("ParseObject(%q) = %#v, %v; want ErrNoObject", tt.text, got, err)
+			}
+		})
+	}
+}
+
+func TestStripCodeFences(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"```python\n{'a': 1}\n```", `{'a': 1}`},
+		{"```{\"a\": 1}```", `{"a": 1}`},
+		{"  {\"a\": 1}  ", `{"a": 1}`},
+		{"no fence", "no fence"},
+	}
+	for _, tt := range tests {
+		if got := StripCodeFences(tt.text); got != tt.want {
+			t.Errorf("StripCodeFences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+// Package parser reads the loosely formatted data literals LLMs produce: strict JSON,
+// JSON5 (comments, trailing commas, single quotes, unquoted keys) and Python literals
+// (True/False/None, tuples, single-quoted strings).
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError reports where and why a literal could not be parsed.
+type SyntaxError struct {
+	Msg    string
+	Offset int // Byte offset into the parsed text
+	Line   int
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Line, e.Column)
+}
+
+// Parse parses text as a single literal and returns it as JSON-compatible Go values
+// (map[string]any, []any, string, float64, bool, nil). Integers that fit are returned as float64
+// like encoding/json does. Surrounding markdown code fences and whitespace are ignored.
+func Parse(text string) (any, error) {
+	p := &literalParser{src: StripCodeFences(text)}
+	p.skipSpace()
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected trailing content %q", p.peekSnippet())
+	}
+	return v, nil
+}
+
+// StripCodeFences removes a surrounding ```json ... ``` (or bare ```) fence.
+func StripCodeFences(text string) string {
+	t := strings.TrimSpace(text)
+	if !strings.HasPrefix(t, "```") {
+		return t
+	}
+	t = strings.TrimPrefix(t, "```")
+	// Drop an optional language tag on the opening fence
+	if nl := strings.IndexByte(t, '\n'); nl >= 0 && !strings.ContainsAny(t[:nl], "{[") {
+		t = t[nl+1:]
+	}
+	t = strings.TrimSuffix(strings.TrimSpace(t), "```")
+	return strings.TrimSpace(t)
+}
+
+type literalParser struct {
+	src   string
+	pos   int
+	depth int
+}
+
+const maxDepth = 512
+
+func (p *literalParser) errorf(format string, args ...any) error {
+	line, col := 1, 1
+	for _, r := range p.src[:p.pos] {
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return &SyntaxError{Msg: fmt.Sprintf(format, args...), Offset: p.pos, Line: line, Column: col}
+}
+
+func (p *literalParser) peekSnippet() string {
+	end := p.pos + 20
+	if end > len(p.src) {
+		end = len(p.src)
+	}
+	return p.src[p.pos:end]
+}
+
+// skipSpace skips whitespace and //, /* */ and # comments.
+func (p *literalParser) skipSpace() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			p.pos++
+		case c == '#' || strings.HasPrefix(p.src[p.pos:], "//"):
+			if nl := strings.IndexByte(p.src[p.pos:], '\n'); nl >= 0 {
+				p.pos += nl + 1
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			if end := strings.Index(p.src[p.pos+2:], "*/"); end >= 0 {
+				p.pos += end + 4
+			} else {
+				p.pos = len(p.src)
+			}
+		default:
+			if r, size := utf8.DecodeRuneInString(p.src[p.pos:]); unicode.IsSpace(r) {
+				p.pos += size
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (p *literalParser) value() (any, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of input")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array('[', ']')
+	case c == '(':
+		return p.array('(', ')') // Python tuple
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	default:
+		return p.keyword()
+	}
+}
+
+func (p *literalParser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf("nesting too deep")
+	}
+	return nil
+}
+
+func (p *literalParser) object() (any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	p.pos++ // '{'
+	obj := make(map[string]any)
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated object")
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return obj, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != ':' && p.src[p.pos] != '=') {
+			return nil, p.errorf("expected ':' after object key %q", key)
+		}
+		p.pos++
+		p.skipSpace()
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = val
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated object")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++ // Trailing commas are handled by the '}' check at the top of the loop
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' in object, found %q", p.peekSnippet())
+		}
+	}
+}
+
+// key reads a quoted key, a bare identifier key (JSON5) or a number used as a key (Python).
+func (p *literalParser) key() (string, error) {
+	c := p.src[p.pos]
+	if c == '"' || c == '\'' {
+		return p.str()
+	}
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !(r == '_' || r == '$' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", p.errorf("expected object key, found %q", p.peekSnippet())
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *literalParser) array(open, close byte) (any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	p.pos++ // open
+	arr := []any{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated list")
+		}
+		if p.src[p.pos] == close {
+			p.pos++
+			return arr, nil
+		}
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, val)
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated list")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case close:
+		default:
+			return nil, p.errorf("expected ',' or '%c' in list, found %q", close, p.peekSnippet())
+		}
+	}
+}
+
+// str reads a single- or double-quoted string, including Python triple-quoted strings.
+// Raw newlines inside strings are accepted, as LLMs often emit them.
+func (p *literalParser) str() (string, error) {
+	quote := p.src[p.pos]
+	delim := string(quote)
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	p.pos += len(delim)
+
+	var sb strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			p.pos += len(delim)
+			return sb.String(), nil
+		}
+		c := p.src[p.pos]
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			sb.WriteRune(r)
+			p.pos += size
+			continue
+		}
+
+		// Escape sequence
+		p.pos++
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated string escape")
+		}
+		esc := p.src[p.pos]
+		p.pos++
+		switch esc {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'v':
+			sb.WriteByte('\v')
+		case '0':
+			sb.WriteByte(0)
+		case '\n':
+			// Line continuation (JSON5 / Python)
+		case 'u', 'x':
+			n := 4
+			if esc == 'x' {
+				n = 2
+			}
+			if p.pos+n > len(p.src) {
+				return "", p.errorf("truncated \\%c escape", esc)
+			}
+			code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+			if err != nil {
+				return "", p.errorf("invalid \\%c escape", esc)
+			}
+			p.pos += n
+			r := rune(code)
+			// Combine UTF-16 surrogate pairs written as two \u escapes
+			if utf16High(r) && strings.HasPrefix(p.src[p.pos:], `\u`) && p.pos+6 <= len(p.src) {
+				if low, err := strconv.ParseUint(p.src[p.pos+2:p.pos+6], 16, 32); err == nil && utf16Low(rune(low)) {
+					r = (r-0xD800)<<10 + (rune(low) - 0xDC00) + 0x10000
+					p.pos += 6
+				}
+			}
+			sb.WriteRune(r)
+		default:
+			// \" \' \\ \/ and unknown escapes keep the escaped character
+			sb.WriteByte(esc)
+		}
+	}
+}
+
+func utf16High(r rune) bool { return r >= 0xD800 && r < 0xDC00 }
+func utf16Low(r rune) bool  { return r >= 0xDC00 && r < 0xE000 }
+
+func (p *literalParser) number() (any, error) {
+	start := p.pos
+	if c := p.src[p.pos]; c == '+' || c == '-' {
+		p.pos++
+	}
+	// JSON5 Infinity / NaN with optional sign
+	for _, word := range []string{"Infinity", "NaN"} {
+		if strings.HasPrefix(p.src[p.pos:], word) {
+			p.pos += len(word)
+			if word == "NaN" {
+				return math.NaN(), nil
+			}
+			if p.src[start] == '-' {
+				return math.Inf(-1), nil
+			}
+			return math.Inf(1), nil
+		}
+	}
+	for p.pos < len(p.src) && strings.IndexByte("0123456789abcdefABCDEFxX.eE+-_", p.src[p.pos]) >= 0 {
+		// A sign is only part of the number right after an exponent marker
+		if c := p.src[p.pos]; (c == '+' || c == '-') && !(p.pos > start && strings.IndexByte("eE", p.src[p.pos-1]) >= 0) {
+			break
+		}
+		p.pos++
+	}
+	text := strings.ReplaceAll(p.src[start:p.pos], "_", "")
+	unsigned := strings.TrimLeft(text, "+-")
+	if strings.HasPrefix(unsigned, "0x") || strings.HasPrefix(unsigned, "0X") {
+		n, err := strconv.ParseInt(strings.TrimPrefix(text, "+"), 0, 64)
+		if err != nil {
+			return nil, p.errorf("invalid hex number %q", text)
+		}
+		return float64(n), nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimPrefix(text, "+"), 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", text)
+	}
+	return f, nil
+}
+
+var keywords = map[string]any{
+	"true": true, "True": true,
+	"false": false, "False": false,
+	"null": nil, "None": nil, "undefined": nil,
+	"Infinity": math.Inf(1), "NaN": math.NaN(),
+}
+
+func (p *literalParser) keyword() (any, error) {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			break
+		}
+		p.pos += size
+	}
+	word := p.src[start:p.pos]
+	if v, ok := keywords[word]; ok {
+		return v, nil
+	}
+	p.pos = start
+	if word == "" {
+		return nil, p.errorf("unexpected character %q", p.peekSnippet())
+	}
+	return nil, p.errorf("unexpected word %q", word)
+}
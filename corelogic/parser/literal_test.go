@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want any
+	}{
+		{"strict json", `{"a": 1, "b": [true, null, "x"]}`, map[string]any{"a": 1.0, "b": []any{true, nil, "x"}}},
+		{"single quotes", `{'name': 'Spoofing', 'score': 'High'}`, map[string]any{"name": "Spoofing", "score": "High"}},
+		{"apostrophe in double-quoted string", `{"text": "the driver's seat"}`, map[string]any{"text": "the driver's seat"}},
+		{"escaped apostrophe in single-quoted string", `{'text': 'the driver\'s seat'}`, map[string]any{"text": "the driver's seat"}},
+		{"double quotes in single-quoted string", `{'text': 'a "spoofed" ECU'}`, map[string]any{"text": `a "spoofed" ECU`}},
+		{"trailing commas", `{"a": [1, 2,], "b": {"c": 3,},}`, map[string]any{"a": []any{1.0, 2.0}, "b": map[string]any{"c": 3.0}}},
+		{"python keywords", `{'ok': True, 'no': False, 'none': None}`, map[string]any{"ok": true, "no": false, "none": nil}},
+		{"python tuple", `('a', 1)`, []any{"a", 1.0}},
+		{"python triple-quoted string", `{'text': '''it's "quoted"'''}`, map[string]any{"text": `it's "quoted"`}},
+		{"unquoted keys", `{name: "x", $id: 2}`, map[string]any{"name": "x", "$id": 2.0}},
+		{"comments", "{\n  // the score\n  \"a\": 1, /* inline */ \"b\": 2 # python\n}", map[string]any{"a": 1.0, "b": 2.0}},
+		{"nested objects", `{"a": {"b": {"c": [1, {"d": 'e'}]}}}`, map[string]any{"a": map[string]any{"b": map[string]any{"c": []any{1.0, map[string]any{"d": "e"}}}}}},
+		{"raw newline in string", "{'text': 'line one\nline two'}", map[string]any{"text": "line one\nline two"}},
+		{"unicode escapes", `["é", "🚗"]`, []any{"é", "🚗"}},
+		{"numbers", `[-1.5, +2, 1e3, 0x10, 1_000]`, []any{-1.5, 2.0, 1000.0, 16.0, 1000.0}},
+		{"fenced", "```json\n{\"a\": 1}\n```", map[string]any{"a": 1.0}},
+		{"bare fence", "```\n['x']\n```", []any{"x"}},
+		{"surrounding whitespace", "  \n\t{}\n ", map[string]any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"unterminated object", `{"a": 1`},
+		{"missing value", `{"a": }`},
+		{"missing colon", `{"a" 1}`},
+		{"missing comma", `[1 2]`},
+		{"unterminated string", `{"a": "open}`},
+		{"unterminated escape", `'abc\`},
+		{"truncated unicode escape", `"\u12"`},
+		{"trailing content", `{"a": 1} and more`},
+		{"unknown word", `{"a": maybe}`},
+		{"bad key", `{: 1}`},
+		{"bad number", `[1.2.3]`},
+		{"lone sign", `-`},
+		{"prose", `The answer is high.`},
+		{"too deep", strings.Repeat("[", maxDepth+1) + strings.Repeat("]", maxDepth+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) = %#v, %v; want a *SyntaxError", tt.text, got, err)
+			}
+		})
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := Parse("{\n  \"a\": 1,\n  \"b\" 2\n}")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("got %v, want a *SyntaxError", err)
+	}
+	if syntaxErr.Line != 3 || syntaxErr.Column != 7 {
+		t.Errorf("error at line %d, column %d, want line 3, column 7", syntaxErr.Line, syntaxErr.Column)
+	}
+}
+
+// TestParseTruncated cuts a literal at every byte: no prefix may panic, and none but the whole
+// literal may parse.
+func TestParseTruncated(t *testing.T) {
+	text := `{'a': [1, 2.5e-3, True, None], "b": {"c": 'it\'s', d: """x"""}, 'e': "é🚗"}`
+	if _, err := Parse(text); err != nil {
+		t.Fatalf("Parse of the whole literal failed: %v", err)
+	}
+	for i := 0; i < len(text); i++ {
+		if _, err := Parse(text[:i]); err == nil {
+			t.Errorf("Parse(%q) succeeded on a truncated literal", text[:i])
+		}
+	}
+}
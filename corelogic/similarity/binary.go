@@ -0,0 +1,279 @@
+package similarity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Binary reference format, written by WriteBinaryReferenceFile (reftool convert). It stores the
+// vectors as raw float32 instead of JSON text; all integers are little-endian:
+//
+//	magic      8 bytes  "TARAREF\x00"
+//	version    uint16
+//	flags      uint16   binaryFlagInt8: int8 codes follow the vectors
+//	dimension  uint32
+//	count      uint32
+//	model      uint16 length + UTF-8   embedding model that produced the vectors, from the metadata
+//	metadata   uint32 length + JSON    ReferenceMetadata; length 0 for none
+//	items      uint32 length + JSON    the items without their embeddings
+//	vectors    count*dimension float32, all zero for items without an embedding
+//	scales     count float32                    (binaryFlagInt8)
+//	codes      count*dimension int8             (binaryFlagInt8)
+//
+// The int8 codes quantize the unit-length vectors, one scale per row.
+const (
+	BinaryReferenceExt = ".refbin"
+
+	binaryVersion  = 1
+	binaryFlagInt8 = 1 << 0
+)
+
+var binaryMagic = []byte("TARAREF\x00")
+
+// BinaryOptions controls WriteBinaryReferenceFile.
+type BinaryOptions struct {
+	Int8 bool // Also store int8 codes; indexes of the file then scan them and rescore in float32
+}
+
+// quantizedVectors holds int8 codes of unit vectors, row i scaled by scales[i].
+type quantizedVectors struct {
+	scales []float32
+	codes  []int8
+}
+
+// WriteBinaryReferenceFile writes file in the binary format. Embeddings whose length differs from
+// the most common one are stored as zero rows and are not searchable.
+func WriteBinaryReferenceFile(filePath string, file *ReferenceFile, opts BinaryOptions) error {
+	dim := commonDimension(file.Items)
+	if dim == 0 {
+		return fmt.Errorf("reference file %s has no embeddings", filePath)
+	}
+	n := len(file.Items)
+	vectors := make([]float32, n*dim)
+	items := make([]ReferenceData, n)
+	for i, item := range file.Items {
+		if len(item.Embedding) == dim {
+			copy(vectors[i*dim:(i+1)*dim], item.Embedding)
+		}
+		item.Embedding = nil
+		items[i] = item
+	}
+
+	var metaJSON []byte
+	if file.Metadata != nil {
+		var err error
+		if metaJSON, err = json.Marshal(file.Metadata); err != nil {
+			return err
+		}
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	var model string
+	if file.Metadata != nil {
+		model = file.Metadata.Model
+	}
+	if len(model) > math.MaxUint16 {
+		return fmt.Errorf("embedding model name too long")
+	}
+
+	var flags uint16
+	if opts.Int8 {
+		flags |= binaryFlagInt8
+	}
+	var buf bytes.Buffer
+	buf.Write(binaryMagic)
+	le := binary.LittleEndian
+	buf.Write(le.AppendUint16(nil, binaryVersion))
+	buf.Write(le.AppendUint16(nil, flags))
+	buf.Write(le.AppendUint32(nil, uint32(dim)))
+	buf.Write(le.AppendUint32(nil, uint32(n)))
+	buf.Write(le.AppendUint16(nil, uint16(len(model))))
+	buf.WriteString(model)
+	buf.Write(le.AppendUint32(nil, uint32(len(metaJSON))))
+	buf.Write(metaJSON)
+	buf.Write(le.AppendUint32(nil, uint32(len(itemsJSON))))
+	buf.Write(itemsJSON)
+	row := make([]byte, 4*dim)
+	for i := 0; i < n; i++ {
+		for j, v := range vectors[i*dim : (i+1)*dim] {
+			le.PutUint32(row[4*j:], math.Float32bits(v))
+		}
+		buf.Write(row)
+	}
+	if opts.Int8 {
+		unit := make([]float32, len(vectors))
+		for i := 0; i < n; i++ {
+			normalizeInto(unit[i*dim:(i+1)*dim], vectors[i*dim:(i+1)*dim])
+		}
+		q := quantize(unit, dim)
+		for _, s := range q.scales {
+			buf.Write(le.AppendUint32(nil, math.Float32bits(s)))
+		}
+		for _, c := range q.codes {
+			buf.WriteByte(byte(c))
+		}
+	}
+
+	// Write to a temp file first so readers never see a partial file
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// binaryReader consumes the binary format, remembering the first error.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("truncated binary reference file")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *binaryReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *binaryReader) float32s(n int) []float32 {
+	b := r.next(4 * n)
+	if b == nil {
+		return nil
+	}
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return out
+}
+
+func decodeBinaryReference(data []byte) (*ReferenceFile, error) {
+	r := &binaryReader{data: data[len(binaryMagic):]}
+	version := r.uint16()
+	flags := r.uint16()
+	dim := int(r.uint32())
+	n := int(r.uint32())
+	model := string(r.next(int(r.uint16())))
+	metaJSON := r.next(int(r.uint32()))
+	itemsJSON := r.next(int(r.uint32()))
+	if r.err != nil {
+		return nil, r.err
+	}
+	if version != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary reference version %d", version)
+	}
+
+	file := &ReferenceFile{}
+	if len(metaJSON) > 0 {
+		if err := json.Unmarshal(metaJSON, &file.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata: %w", err)
+		}
+	}
+	// The header is authoritative for how the vectors were made
+	if model != "" || file.Metadata != nil {
+		if file.Metadata == nil {
+			file.Metadata = &ReferenceMetadata{}
+		}
+		file.Metadata.Model = model
+		file.Metadata.Dimensions = dim
+	}
+	if err := json.Unmarshal(itemsJSON, &file.Items); err != nil {
+		return nil, fmt.Errorf("invalid items: %w", err)
+	}
+	if len(file.Items) != n {
+		return nil, fmt.Errorf("header declares %d items, found %d", n, len(file.Items))
+	}
+
+	vectors := r.float32s(n * dim)
+	var quantized *quantizedVectors
+	if flags&binaryFlagInt8 != 0 {
+		quantized = &quantizedVectors{scales: r.float32s(n)}
+		if codes := r.next(n * dim); codes != nil {
+			quantized.codes = make([]int8, len(codes))
+			for i, c := range codes {
+				quantized.codes[i] = int8(c)
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range file.Items {
+		row := vectors[i*dim : (i+1)*dim : (i+1)*dim]
+		if magnitude(row) > 0 {
+			file.Items[i].Embedding = row
+		}
+	}
+	file.quantized = quantized
+	return file, nil
+}
+
+// quantize maps each dim-long row of vectors to int8 codes with a symmetric per-row scale.
+func quantize(vectors []float32, dim int) *quantizedVectors {
+	n := len(vectors) / dim
+	q := &quantizedVectors{scales: make([]float32, n), codes: make([]int8, len(vectors))}
+	for i := 0; i < n; i++ {
+		q.scales[i] = quantizeRow(q.codes[i*dim:(i+1)*dim], vectors[i*dim:(i+1)*dim])
+	}
+	return q
+}
+
+// quantizeRow writes the int8 codes of vec into dst and returns the scale that maps them back.
+func quantizeRow(dst []int8, vec []float32) float32 {
+	var maxAbs float32
+	for _, v := range vec {
+		maxAbs = max(maxAbs, float32(math.Abs(float64(v))))
+	}
+	if maxAbs == 0 {
+		clear(dst)
+		return 0
+	}
+	scale := maxAbs / 127
+	for i, v := range vec {
+		dst[i] = int8(math.Round(float64(v / scale)))
+	}
+	return scale
+}
+
+// dotInt8 is the inner product of two equal-length code rows, unrolled by four.
+func dotInt8(a, b []int8) int32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 int32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += int32(a[i]) * int32(b[i])
+		s1 += int32(a[i+1]) * int32(b[i+1])
+		s2 += int32(a[i+2]) * int32(b[i+2])
+		s3 += int32(a[i+3]) * int32(b[i+3])
+	}
+	for ; i < len(a); i++ {
+		s0 += int32(a[i]) * int32(b[i])
+	}
+	return s0 + s1 + s2 + s3
+}
@@ -1,5 +1,7 @@
 package similarity
 
+import "encoding/json"
+
 // InputData matches the structure expected by your service
 type InputData struct {
 	Asset            string `json:"Asset"`
@@ -22,16 +24,22 @@ type ReferenceData struct {
 	Asset            	string    `json:"Asset"`
 	Category         	string    `json:"Category"`
 	Property         	string    `json:"Property"`
-	AssetDescription 	string    `json:"AssetDescription"` // Ensure JSON tag matches output
+	AssetDescription 	string    `json:"Asset Description"` // Matches the config key; "AssetDescription" is still read, see UnmarshalJSON
 	DamageScenario   	string    `json:"damage_scenario"`   // Ensure JSON tag matches output
 	ThreatScenario   	string    `json:"threat_scenario"`   // Ensure JSON tag matches output
 	AttackSteps      	string    `json:"attack_steps"`      // Ensure JSON tag matches output
+	Threat           	string    `json:"threat,omitempty"`
+	DamageID         	string    `json:"damage_id,omitempty"`
+	AttackID         	string    `json:"attack_id,omitempty"`
 	// Add other metadata fields from your JSON reference files if needed
 	// Example: Impact scores if they are in the reference file
 	SafetyImpact     	any `json:"safety_impact,omitempty"` // Use any if type varies (e.g., string/int)
 	FinancialImpact  	any `json:"financial_impact,omitempty"`
 	OperationalImpact 	any `json:"operational_impact,omitempty"`
 	PrivacyImpact    	any `json:"privacy_impact,omitempty"`
+	OEMFinancialImpact	any `json:"oem_financial_impact,omitempty"`
+	OEMOperationalImpact	any `json:"oem_operational_impact,omitempty"`
+	OEMIPImpact      	any `json:"oem_ip_impact,omitempty"`
 	ET               	any `json:"et,omitempty"`
 	SE               	any `json:"se,omitempty"`
 	KOIC             	any `json:"koic,omitempty"`
@@ -39,15 +47,36 @@ type ReferenceData struct {
 	EQ               	any `json:"eq,omitempty"`
 }
 
+// UnmarshalJSON also accepts the "AssetDescription" key of older reference files.
+func (r *ReferenceData) UnmarshalJSON(data []byte) error {
+	type plain ReferenceData
+	aux := struct {
+		*plain
+		LegacyAssetDescription string `json:"AssetDescription"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if r.AssetDescription == "" {
+		r.AssetDescription = aux.LegacyAssetDescription
+	}
+	return nil
+}
+
 // ResultWithScore combines ReferenceData with its calculated similarity score
 type ResultWithScore struct {
-	Data  ReferenceData
-	Score float64
+	Data    ReferenceData
+	Score   float64 // Cosine similarity to the query embedding; 0 without one
+	Lexical float64 // BM25 score of the query text; 0 for vector-only searches or no shared terms
+	Fused   float64 // Reciprocal rank fusion score that ranked a hybrid search; 0 otherwise
+
+	index int32 // Position of the item in its index
 }
 
 // SimilarityResult holds the top K results
 type SimilarityResult struct {
-	Shots []ReferenceData `json:"shots"`
+	Shots  []ReferenceData `json:"shots"`
+	Scores []ShotScore     `json:"scores,omitempty"` // Why Shots[i] was chosen
 }
 
 // Define result structures for different workflows where applicable
@@ -58,4 +87,84 @@ type DictResult map[string]any
 type ThreatScenarioResult struct {
 	ThreatScenario string   `json:"threat_scenario"`
 	AttackVectors  []string `json:"attack_vectors"`
-}
\ No newline at end of file
+}
+
+// RunInfo records how a workflow result was produced. It is returned alongside the result.
+type RunInfo struct {
+	AnalysisType   string `json:"analysis_type"`
+	RequestedModel string `json:"requested_model"`
+	Model          string `json:"model"`         // provider:model that actually produced the final answer
+	FallbackUsed   bool   `json:"fallback_used"` // True when the requested model was unavailable
+	// StructuredOutput is true when the final answer is already a JSON result object (constrained by
+	// the analysis' JSON schema, or voted from samples) rather than text in the delimiter format.
+	StructuredOutput bool `json:"structured_output"`
+	Repairs          int  `json:"repairs,omitempty"` // Repair round-trips needed to parse the answer
+
+	// Output guardrails
+	GuardrailRetries    int      `json:"guardrail_retries,omitempty"`    // Re-prompts needed to satisfy the guardrails
+	GuardrailViolations []string `json:"guardrail_violations,omitempty"` // Violations that triggered them
+
+	// Self-consistency voting
+	Samples        int                `json:"samples,omitempty"`         // Parsable samples that took part in the vote
+	FieldAgreement map[string]float64 `json:"field_agreement,omitempty"` // Share of samples agreeing with each voted field
+	NeedsReview    []string           `json:"needs_review,omitempty"`    // Low-agreement fields flagged for human review
+
+	// StepValidate trial answers given to consolidation, with the model behind each
+	Candidates []Candidate `json:"candidates,omitempty"`
+
+	// Step-by-step reasoning behind the final answer
+	Rationale []RationaleStep `json:"rationale,omitempty"`
+
+	// Critique-and-revise reflection
+	CritiqueTrace []CritiqueRound `json:"critique_trace,omitempty"`
+
+	// Tool calls made by an agent step, in order
+	ToolCalls []ToolCallLog `json:"tool_calls,omitempty"`
+
+	// Reference shots given to the prompt, with the scores they were chosen by
+	Shots []ShotScore `json:"shots,omitempty"`
+
+	// LLM-as-judge quality scores; nil when the analysis has no rubric or the judge was unavailable
+	Judge *JudgeResult `json:"judge,omitempty"`
+}
+
+// ToolCallLog is one tool call of an agent step.
+type ToolCallLog struct {
+	Round     int    `json:"round"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"` // Truncated
+	Error     string `json:"error,omitempty"`
+}
+
+// JudgeResult holds the judge's rubric scores for a result.
+type JudgeResult struct {
+	Scores        map[string]float64 `json:"scores"` // Score per rubric criterion, 1-5
+	Mean          float64            `json:"mean"`
+	Threshold     float64            `json:"threshold"`
+	Passed        bool               `json:"passed"` // Mean reached the threshold
+	Rationale     string             `json:"rationale"`
+	Regenerations int                `json:"regenerations,omitempty"` // Workflow reruns before this result
+	Model         string             `json:"model,omitempty"`         // Model that answered, a fallback if the judge model was unavailable
+}
+
+// RationaleStep is one numbered reasoning step of an answer.
+type RationaleStep struct {
+	Step        int    `json:"step"`
+	Instruction string `json:"instruction,omitempty"` // What the prompt asked for in this step
+	Reasoning   string `json:"reasoning"`
+}
+
+// Candidate is one expert answer of a validation trial.
+type Candidate struct {
+	Expert int    `json:"expert"` // Number of the expert in the consolidation prompt
+	Model  string `json:"model"`  // provider:model that produced the answer
+	Result string `json:"result"`
+}
+
+// CritiqueRound is one critique of a draft in a reflection step and whether it led to a revision.
+type CritiqueRound struct {
+	Round    int    `json:"round"`
+	Critique string `json:"critique"`
+	Revised  bool   `json:"revised"`
+}
@@ -2,72 +2,104 @@ package similarity
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"os"
+	"strconv"
 
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
 	openai "github.com/sashabaranov/go-openai" // Corrected import path if using this popular client
 )
 
-// Define which keys from InputData should be embedded
-var embedKeys = []string{"Asset", "Category", "Property", "Asset Description"}
-
-// getOpenAIEmbedding generates an embedding using the specified OpenAI model.
-func getOpenAIEmbedding(ctx context.Context, input InputData, apiKey string) ([]float32, error) {
-	// Filter input data based on embedKeys and format it as a string
-	var parts []string
-	// Use a map for easier key lookup
-	dataMap := map[string]string{
-		"Asset":             input.Asset,
-		"Category":          input.Category,
-		"Property":          input.Property,
-		"Asset Description": input.AssetDescription,
+// embedInput embeds the input fields selected by the reference file's embedding format.
+func embedInput(ctx context.Context, input InputData, format ReferenceMetadata) ([]float32, error) {
+	textToEmbed := format.EmbeddingText(InputValues(input))
+	if textToEmbed == "" {
+		return nil, fmt.Errorf("no valid data found for embedding based on embed keys %v", format.EmbedKeys)
 	}
 
-	// Construct the text to embed based on specified keys
-	for _, key := range embedKeys {
-		if val, ok := dataMap[key]; ok && val != "" {
-			// Simple string representation, adjust formatting as needed
-			parts = append(parts, fmt.Sprintf("%s: %s", key, val))
-		}
-	}
-	textToEmbed := strings.Join(parts, ", ")
-	if textToEmbed == "" {
-		return nil, fmt.Errorf("no valid data found for embedding based on embedKeys")
+	embedding, err := embedText(ctx, textToEmbed)
+	if err != nil {
+		// It's helpful to log the text that failed
+		log.Printf("Failed to embed text: %s", textToEmbed)
+		return nil, err
 	}
 
-	// --- Call OpenAI API (Corrected Usage) ---
-	client := openai.NewClient(apiKey)
+	// Return the embedding
+	return embedding, nil
+}
 
-	// Use the correct model name as a string literal
-	// Use openai.EmbeddingModel constants if preferred and available for v3 models
-	// e.g. if openai.TextEmbedding3Large exists, use that. Otherwise, use the string.
-	model := openai.LargeEmbedding3 // Defaulting to Ada v2 for example, CHECK constant for v3 large
-	// modelName := "text-embedding-3-large" // Explicit string name
+// Embedding model and dimensions used when EMBEDDING_MODEL and EMBEDDING_DIMENSIONS are unset,
+// and assumed for reference files that don't record theirs.
+const (
+	DefaultEmbeddingModel      = string(openai.LargeEmbedding3)
+	DefaultEmbeddingDimensions = 256 // Match dimensions used in Python code
+)
 
-	dimensions := 256 // Match dimensions used in Python code
+// ErrEmbeddingMismatch is returned when a reference library was embedded with a different model or
+// dimensions than queries are, so their similarities would be meaningless.
+var ErrEmbeddingMismatch = errors.New("embedding model mismatch")
 
-	// Create the embedding request using the standard struct
-	req := openai.EmbeddingRequest{
-		Input:      []string{textToEmbed},
-		Model:      openai.EmbeddingModel(model), // Cast string to the EmbeddingModel type
-		Dimensions: dimensions,
-		// EncodingFormat is also available if needed, e.g., openai.EncodingFormatFloat
-	}
+// ErrNoEmbedding is returned when the configured embedding can't be used for lack of credentials.
+var ErrNoEmbedding = errors.New("no embedding configured")
 
-	// Call the CreateEmbeddings method
-	resp, err := client.CreateEmbeddings(ctx, req)
-	if err != nil {
-		// It's helpful to log the text that failed
-		log.Printf("Failed to embed text: %s", textToEmbed)
-		return nil, fmt.Errorf("openai CreateEmbeddings failed: %w", err)
+// EmbeddingSpec identifies how vectors are made: two vectors are only comparable if their specs match.
+type EmbeddingSpec struct {
+	Model      string `json:"model"`
+	Dimensions int    `json:"dimensions"`
+}
+
+func (s EmbeddingSpec) String() string {
+	return fmt.Sprintf("%s/%d", s.Model, s.Dimensions)
+}
+
+// ConfiguredEmbedding returns the embedding that queries are made with: EMBEDDING_MODEL and
+// EMBEDDING_DIMENSIONS, or the defaults.
+func ConfiguredEmbedding() EmbeddingSpec {
+	spec := EmbeddingSpec{Model: DefaultEmbeddingModel, Dimensions: DefaultEmbeddingDimensions}
+	if m := os.Getenv("EMBEDDING_MODEL"); m != "" {
+		spec.Model = m
+	}
+	if v := os.Getenv("EMBEDDING_DIMENSIONS"); v != "" {
+		if d, err := strconv.Atoi(v); err == nil && d > 0 {
+			spec.Dimensions = d
+		} else {
+			log.Printf("Warning: ignoring invalid EMBEDDING_DIMENSIONS %q", v)
+		}
 	}
+	return spec
+}
 
-	// Check response structure
-	if len(resp.Data) == 0 || len(resp.Data[0].Embedding) == 0 {
-		return nil, fmt.Errorf("received empty embedding from openai")
+// checkEmbedding returns ErrEmbeddingMismatch if the index can't be searched with query vectors of spec.
+func (idx *ReferenceIndex) checkEmbedding(spec EmbeddingSpec) error {
+	lib := idx.Metadata.Embedding()
+	if idx.dim > 0 && (idx.Metadata == nil || idx.Metadata.Dimensions == 0) {
+		lib.Dimensions = idx.dim // Legacy files: trust the vectors over the assumed default
 	}
+	if lib != spec {
+		return fmt.Errorf("%w: %s was embedded with %s, queries are embedded with %s; re-embed it with \"reftool reembed\"", ErrEmbeddingMismatch, idx.Path, lib, spec)
+	}
+	return nil
+}
+
+// embedText embeds free text with the configured embedding, which the reference files must share.
+func embedText(ctx context.Context, text string) ([]float32, error) {
+	return EmbedText(ctx, text, ConfiguredEmbedding())
+}
 
-	// Return the first embedding
-	return resp.Data[0].Embedding, nil
-}
\ No newline at end of file
+// EmbedText embeds text with the given embedding. The built-in HashedEmbeddingModel is computed in
+// process; OpenAI models need OPENAI_API_KEY, other providers use their configured key.
+func EmbedText(ctx context.Context, text string, spec EmbeddingSpec) ([]float32, error) {
+	if spec.Model == HashedEmbeddingModel {
+		return hashedEmbedding(text, spec.Dimensions), nil
+	}
+	var apiKey string
+	if llm.ParseModelSpec(spec.Model).Provider == llm.ProviderOpenAI {
+		if apiKey = os.Getenv("OPENAI_API_KEY"); apiKey == "" {
+			return nil, fmt.Errorf("%w: OPENAI_API_KEY environment variable not set", ErrNoEmbedding)
+		}
+	}
+	// --- Call the embedding API through the llm layer (quota-enforced) ---
+	return llm.CreateEmbedding(ctx, text, apiKey, openai.EmbeddingModel(spec.Model), spec.Dimensions)
+}
@@ -0,0 +1,76 @@
+package similarity
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// HashedEmbeddingModel is a built-in embedding that needs no network: words and word pairs are
+// hashed into the vector's dimensions with sublinear term frequencies. Indexes of libraries made
+// with it weight the dimensions by inverse document frequency, making the search TF-IDF.
+const HashedEmbeddingModel = "builtin:hashed-tfidf"
+
+// bigramWeight is the weight of a word pair relative to a single word.
+const bigramWeight = 0.5
+
+// hashedEmbedding returns the hashed term frequency vector of text. Each term lands in one
+// dimension with a hash-derived sign, so collisions tend to cancel out rather than add up.
+func hashedEmbedding(text string, dims int) []float32 {
+	vec := make([]float32, dims)
+	tokens := tokenize(text)
+	add := func(term string, weight float32) {
+		h := fnv.New32a()
+		h.Write([]byte(term))
+		sum := h.Sum32()
+		if sum&(1<<31) != 0 {
+			weight = -weight
+		}
+		vec[int(sum&(1<<31-1))%dims] += weight
+	}
+	for i, t := range tokens {
+		add(t, 1)
+		if i > 0 {
+			add(tokens[i-1]+" "+t, bigramWeight)
+		}
+	}
+	for i, v := range vec {
+		if v != 0 {
+			// Sublinear term frequency: a term repeated ten times isn't ten times as telling
+			vec[i] = float32(math.Copysign(1+math.Log(math.Abs(float64(v))), float64(v)))
+		}
+	}
+	return vec
+}
+
+// applyIDF weights every dimension of the unit rows by its inverse document frequency among the
+// searchable rows, renormalises the rows, and returns the weights for queries.
+func (idx *ReferenceIndex) applyIDF() []float32 {
+	df := make([]float64, idx.dim)
+	n := 0
+	for i, ok := range idx.valid {
+		if !ok {
+			continue
+		}
+		n++
+		for j, v := range idx.row(i) {
+			if v != 0 {
+				df[j]++
+			}
+		}
+	}
+	idf := make([]float32, idx.dim)
+	for j := range idf {
+		idf[j] = float32(math.Log(float64(n+1)/(df[j]+1)) + 1)
+	}
+	for i, ok := range idx.valid {
+		if !ok {
+			continue
+		}
+		row := idx.row(i)
+		for j := range row {
+			row[j] *= idf[j]
+		}
+		idx.valid[i] = normalizeInto(row, row)
+	}
+	return idf
+}
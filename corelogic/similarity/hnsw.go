@@ -0,0 +1,240 @@
+package similarity
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// HNSWParams tunes the approximate index. Zero fields take the defaults.
+type HNSWParams struct {
+	M              int // Links per node on the upper layers; twice as many on the bottom layer
+	EfConstruction int // Candidate list size while inserting; higher builds a better graph, slower
+	EfSearch       int // Candidate list size while searching; higher raises recall, slower
+}
+
+const (
+	defaultHNSWM              = 16
+	defaultHNSWEfConstruction = 100
+	defaultHNSWEfSearch       = 64
+)
+
+func (p HNSWParams) withDefaults() HNSWParams {
+	if p.M <= 0 {
+		p.M = defaultHNSWM
+	}
+	if p.EfConstruction <= 0 {
+		p.EfConstruction = defaultHNSWEfConstruction
+	}
+	if p.EfSearch <= 0 {
+		p.EfSearch = defaultHNSWEfSearch
+	}
+	return p
+}
+
+// hnswIndex is a hierarchical navigable small world graph over the unit vectors of a
+// ReferenceIndex. Similarity is the dot product, so larger scores are closer. The graph is built
+// once and only read afterwards, so searches may run concurrently.
+type hnswIndex struct {
+	params   HNSWParams
+	dim      int
+	vectors  []float32   // Shared with the ReferenceIndex
+	links    [][][]int32 // links[node][layer] lists the node's neighbours; nil for unindexed rows
+	entry    int32       // Entry point on the top layer, -1 for an empty graph
+	maxLayer int
+	visits   sync.Pool // *visitSet reused across searches
+}
+
+// visitSet marks visited nodes by stamping them with the current generation, so it is cleared in
+// constant time between searches.
+type visitSet struct {
+	gen   uint32
+	marks []uint32
+}
+
+func (v *visitSet) reset() {
+	v.gen++
+	if v.gen == 0 { // Wrapped around; stale marks could collide
+		clear(v.marks)
+		v.gen = 1
+	}
+}
+
+// visit marks node and reports whether it was unvisited.
+func (v *visitSet) visit(node int32) bool {
+	if v.marks[node] == v.gen {
+		return false
+	}
+	v.marks[node] = v.gen
+	return true
+}
+
+func buildHNSW(vectors []float32, dim int, valid []bool, params HNSWParams) *hnswIndex {
+	h := &hnswIndex{
+		params:  params.withDefaults(),
+		dim:     dim,
+		vectors: vectors,
+		links:   make([][][]int32, len(valid)),
+		entry:   -1,
+	}
+	n := len(valid)
+	h.visits.New = func() any { return &visitSet{marks: make([]uint32, n)} }
+
+	// A fixed seed keeps the graph, and so the results, identical across reloads of the same file
+	rng := rand.New(rand.NewPCG(1, uint64(n)))
+	levelMult := 1 / math.Log(float64(h.params.M))
+	visited := h.visits.Get().(*visitSet)
+	for i, ok := range valid {
+		if !ok {
+			continue
+		}
+		level := int(-math.Log(1-rng.Float64()) * levelMult)
+		h.insert(int32(i), level, visited)
+	}
+	h.visits.Put(visited)
+	return h
+}
+
+func (h *hnswIndex) vec(node int32) []float32 {
+	i := int(node)
+	return h.vectors[i*h.dim : (i+1)*h.dim]
+}
+
+func (h *hnswIndex) insert(node int32, level int, visited *visitSet) {
+	h.links[node] = make([][]int32, level+1)
+	if h.entry < 0 {
+		h.entry, h.maxLayer = node, level
+		return
+	}
+
+	q := h.vec(node)
+	ep := scoredItem{index: h.entry, score: dot(q, h.vec(h.entry))}
+	for layer := h.maxLayer; layer > level; layer-- {
+		ep = h.greedy(q, ep, layer)
+	}
+	for layer := min(level, h.maxLayer); layer >= 0; layer-- {
+		candidates := h.searchLayer(q, ep, h.params.EfConstruction, layer, visited)
+		neighbours := h.selectNeighbours(candidates, h.params.M)
+		h.links[node][layer] = neighbours
+		maxLinks := h.params.M
+		if layer == 0 {
+			maxLinks *= 2
+		}
+		for _, nb := range neighbours {
+			h.connect(nb, node, layer, maxLinks)
+		}
+		ep = candidates[0]
+	}
+	if level > h.maxLayer {
+		h.entry, h.maxLayer = node, level
+	}
+}
+
+// connect adds a link from -> to on layer, pruning from's links back to maxLinks when full.
+func (h *hnswIndex) connect(from, to int32, layer, maxLinks int) {
+	links := append(h.links[from][layer], to)
+	if len(links) > maxLinks {
+		v := h.vec(from)
+		candidates := make([]scoredItem, len(links))
+		for i, nb := range links {
+			candidates[i] = scoredItem{index: nb, score: dot(v, h.vec(nb))}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+		links = h.selectNeighbours(candidates, maxLinks)
+	}
+	h.links[from][layer] = links
+}
+
+// selectNeighbours picks up to m of the best-first candidates, preferring ones that are closer to
+// the query than to any already picked neighbour so links spread in different directions. Skipped
+// candidates fill any remaining slots.
+func (h *hnswIndex) selectNeighbours(candidates []scoredItem, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var skipped []int32
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		v := h.vec(c.index)
+		diverse := true
+		for _, s := range selected {
+			if dot(v, h.vec(s)) > c.score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, c.index)
+		} else {
+			skipped = append(skipped, c.index)
+		}
+	}
+	for _, c := range skipped {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+// greedy walks layer from ep towards q until no neighbour is closer.
+func (h *hnswIndex) greedy(q []float32, ep scoredItem, layer int) scoredItem {
+	for moved := true; moved; {
+		moved = false
+		for _, nb := range h.links[ep.index][layer] {
+			if s := dot(q, h.vec(nb)); s > ep.score {
+				ep = scoredItem{index: nb, score: s}
+				moved = true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns up to ef nodes of layer closest to q, best first, by a best-first
+// expansion from ep.
+func (h *hnswIndex) searchLayer(q []float32, ep scoredItem, ef, layer int, visited *visitSet) []scoredItem {
+	visited.reset()
+	visited.visit(ep.index)
+	// candidates holds negated scores so its min-heap root is the closest unexpanded node
+	candidates := scoreHeap{{index: ep.index, score: -ep.score}}
+	results := newTopK(ef)
+	results.offer(ep.index, ep.score)
+	for len(candidates) > 0 {
+		c := candidates.pop()
+		if results.full() && -c.score < results.worst() {
+			break
+		}
+		for _, nb := range h.links[c.index][layer] {
+			if !visited.visit(nb) {
+				continue
+			}
+			s := dot(q, h.vec(nb))
+			if !results.full() || s > results.worst() {
+				candidates.push(scoredItem{index: nb, score: -s})
+				results.offer(nb, s)
+			}
+		}
+	}
+	return results.sorted()
+}
+
+// search returns the approximate k nearest nodes to the unit query q, best first.
+func (h *hnswIndex) search(q []float32, k int) []scoredItem {
+	if h.entry < 0 {
+		return nil
+	}
+	ep := scoredItem{index: h.entry, score: dot(q, h.vec(h.entry))}
+	for layer := h.maxLayer; layer > 0; layer-- {
+		ep = h.greedy(q, ep, layer)
+	}
+	visited := h.visits.Get().(*visitSet)
+	results := h.searchLayer(q, ep, max(h.params.EfSearch, k), 0, visited)
+	h.visits.Put(visited)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
@@ -0,0 +1,380 @@
+package similarity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReferenceIndex is an immutable, search-ready copy of one reference file. Vectors are normalised
+// at load time into one contiguous float32 block, so a search scores items with a single dot
+// product each and keeps the best ones in a bounded heap.
+type ReferenceIndex struct {
+	Path     string
+	Metadata *ReferenceMetadata // nil for legacy files
+	Items    []ReferenceData
+	LoadedAt time.Time
+
+	dim     int               // Dimension of the indexed vectors
+	vectors []float32         // len(Items)*dim unit vectors, row i belongs to Items[i]; zero rows are not searchable
+	valid   []bool            // Whether row i holds a usable vector
+	quant   *quantizedVectors // int8 codes scanned by Search before rescoring; nil to scan vectors
+	ann     *hnswIndex        // Approximate index serving Search; nil for a full scan
+	idf     []float32         // Dimension weights applied to queries (HashedEmbeddingModel); nil for none
+	lexical *bm25Index        // Inverted index of the items' text for lexical and hybrid search
+	modTime time.Time
+	size    int64
+}
+
+// IndexOptions controls how a ReferenceIndex is built.
+type IndexOptions struct {
+	ANN      bool // Build an HNSW graph and serve Search from it instead of a full scan
+	HNSW     HNSWParams
+	Quantize bool // Scan int8 codes and rescore the best candidates in float32; ignored with ANN
+	IDF      bool // Weight dimensions by inverse document frequency, for HashedEmbeddingModel vectors
+}
+
+// indexEntry holds the current index of a file; the pointer is swapped atomically on reload.
+type indexEntry struct {
+	current atomic.Pointer[ReferenceIndex]
+	loadMu  sync.Mutex // Serialises loads of this file; searches never take it
+}
+
+var (
+	indexesMu sync.Mutex
+	indexes   = map[string]*indexEntry{}
+)
+
+// GetReferenceIndex returns the index of a reference file, loading it on first use.
+func GetReferenceIndex(path string) (*ReferenceIndex, error) {
+	indexesMu.Lock()
+	entry, ok := indexes[path]
+	if !ok {
+		entry = &indexEntry{}
+		indexes[path] = entry
+	}
+	indexesMu.Unlock()
+
+	if idx := entry.current.Load(); idx != nil {
+		return idx, nil
+	}
+	entry.loadMu.Lock()
+	defer entry.loadMu.Unlock()
+	if idx := entry.current.Load(); idx != nil {
+		return idx, nil // Loaded by a concurrent caller while we waited
+	}
+	idx, err := loadReferenceIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	entry.current.Store(idx)
+	return idx, nil
+}
+
+// ReloadReferenceIndexes reloads every loaded index whose file changed on disk (or all of them
+// with force) and returns the reloaded paths. A file that fails to load keeps its previous index.
+func ReloadReferenceIndexes(force bool) ([]string, error) {
+	indexesMu.Lock()
+	entries := make(map[string]*indexEntry, len(indexes))
+	for path, entry := range indexes {
+		entries[path] = entry
+	}
+	indexesMu.Unlock()
+
+	var reloaded []string
+	var errs []error
+	for path, entry := range entries {
+		ok, err := reloadIfChanged(path, entry, force)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			reloaded = append(reloaded, path)
+		}
+	}
+	sort.Strings(reloaded)
+	if len(errs) > 0 {
+		return reloaded, fmt.Errorf("failed to reload %d reference files: %v", len(errs), errs)
+	}
+	return reloaded, nil
+}
+
+func reloadIfChanged(path string, entry *indexEntry, force bool) (bool, error) {
+	entry.loadMu.Lock()
+	defer entry.loadMu.Unlock()
+	old := entry.current.Load()
+	if old != nil && !force {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		if info.ModTime().Equal(old.modTime) && info.Size() == old.size {
+			return false, nil
+		}
+	}
+	idx, err := loadReferenceIndex(path)
+	if err != nil {
+		return false, err
+	}
+	entry.current.Store(idx)
+	log.Printf("Reloaded reference index %s (%d items)", path, len(idx.Items))
+	return true, nil
+}
+
+// WatchReferenceIndexes polls the loaded reference files every interval and reloads changed ones
+// until ctx is done.
+func WatchReferenceIndexes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ReloadReferenceIndexes(false); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+}
+
+func loadReferenceIndex(path string) (*ReferenceIndex, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat reference data file %s: %w", path, err)
+	}
+	file, err := LoadReferenceFile(path)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	opts := indexOptionsFromEnv(len(file.Items))
+	// Binary files carry their int8 codes; reuse them rather than quantizing again
+	stored := file.quantized
+	opts.Quantize = stored != nil && !opts.ANN
+	opts.IDF = file.Metadata.Embedding().Model == HashedEmbeddingModel
+	idx := newReferenceIndex(file.Items, opts, stored)
+	idx.Path = path
+	idx.Metadata = file.Metadata
+	idx.modTime = info.ModTime()
+	idx.size = info.Size()
+	if skipped := len(idx.Items) - idx.Searchable(); skipped > 0 {
+		log.Printf("Warning: %d items of %s have no usable %d-dimensional embedding and are not searchable", skipped, path, idx.dim)
+	}
+	if idx.ann != nil {
+		log.Printf("Built approximate index for %s (%d items) in %s", path, len(idx.Items), time.Since(start).Round(time.Millisecond))
+	}
+	return idx, nil
+}
+
+// indexOptionsFromEnv enables the approximate index for files with at least REFERENCE_ANN_MIN_ITEMS
+// items. It is off when the variable is unset, since approximate results may differ from a full scan.
+func indexOptionsFromEnv(items int) IndexOptions {
+	v := os.Getenv("REFERENCE_ANN_MIN_ITEMS")
+	if v == "" {
+		return IndexOptions{}
+	}
+	minItems, err := strconv.Atoi(v)
+	if err != nil || minItems < 0 {
+		log.Printf("Warning: ignoring invalid REFERENCE_ANN_MIN_ITEMS %q", v)
+		return IndexOptions{}
+	}
+	return IndexOptions{ANN: items >= minItems}
+}
+
+// NewReferenceIndex builds an index over items. The embeddings move into the index, so the items'
+// Embedding fields are cleared. Vectors whose dimension differs from the most common one are not
+// searchable.
+func NewReferenceIndex(items []ReferenceData, opts IndexOptions) *ReferenceIndex {
+	return newReferenceIndex(items, opts, nil)
+}
+
+func newReferenceIndex(items []ReferenceData, opts IndexOptions, stored *quantizedVectors) *ReferenceIndex {
+	idx := &ReferenceIndex{
+		Items:    items,
+		LoadedAt: time.Now(),
+		dim:      commonDimension(items),
+		valid:    make([]bool, len(items)),
+	}
+	idx.vectors = make([]float32, len(items)*idx.dim)
+	for i := range items {
+		if len(items[i].Embedding) == idx.dim {
+			idx.valid[i] = normalizeInto(idx.row(i), items[i].Embedding)
+		}
+		// The embeddings live on in vectors; Items keep metadata only
+		items[i].Embedding = nil
+	}
+	if opts.IDF && idx.dim > 0 {
+		// Reweighted rows no longer match stored codes
+		idx.idf, stored = idx.applyIDF(), nil
+	}
+	docs := make([]string, len(items))
+	for i := range items {
+		docs[i] = items[i].lexicalText()
+	}
+	idx.lexical = buildBM25(docs)
+	switch {
+	case idx.dim == 0:
+	case opts.ANN:
+		idx.ann = buildHNSW(idx.vectors, idx.dim, idx.valid, opts.HNSW)
+	case opts.Quantize && stored != nil && len(stored.codes) == len(idx.vectors) && len(stored.scales) == len(items):
+		idx.quant = stored
+	case opts.Quantize:
+		idx.quant = quantize(idx.vectors, idx.dim)
+	}
+	return idx
+}
+
+// commonDimension returns the most frequent embedding length among items, 0 if none has one.
+func commonDimension(items []ReferenceData) int {
+	counts := map[int]int{}
+	best := 0
+	for _, item := range items {
+		n := len(item.Embedding)
+		if n == 0 {
+			continue
+		}
+		counts[n]++
+		if counts[n] > counts[best] || (counts[n] == counts[best] && n < best) {
+			best = n
+		}
+	}
+	return best
+}
+
+// Dimension returns the dimension of the indexed vectors.
+func (idx *ReferenceIndex) Dimension() int {
+	return idx.dim
+}
+
+// Searchable returns the number of items with a usable vector.
+func (idx *ReferenceIndex) Searchable() int {
+	n := 0
+	for _, ok := range idx.valid {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (idx *ReferenceIndex) row(i int) []float32 {
+	return idx.vectors[i*idx.dim : (i+1)*idx.dim]
+}
+
+// normalizeInto writes the unit-length form of vec into dst and reports false for a zero vector.
+func normalizeInto(dst, vec []float32) bool {
+	mag := magnitude(vec)
+	if mag == 0 {
+		return false
+	}
+	for i, v := range vec {
+		dst[i] = float32(float64(v) / mag)
+	}
+	return true
+}
+
+// Search returns the topK items most similar to query by cosine similarity, best first. It is
+// served by the approximate index when one was built, by a quantized scan when the index is
+// quantized, otherwise by a full scan.
+func (idx *ReferenceIndex) Search(query []float32, topK int) []ResultWithScore {
+	q, ok := idx.prepareQuery(query)
+	if !ok || topK <= 0 {
+		return nil
+	}
+	return idx.results(idx.vectorHits(q, topK))
+}
+
+func (idx *ReferenceIndex) vectorHits(q []float32, k int) []scoredItem {
+	switch {
+	case idx.ann != nil:
+		return idx.ann.search(q, k)
+	case idx.quant != nil:
+		return idx.scanQuantized(q, k)
+	}
+	return idx.scan(q, k)
+}
+
+// minFusionDepth is the least number of candidates each ranking contributes to a hybrid search.
+const minFusionDepth = 50
+
+// HybridSearch returns the topK items best ranked by reciprocal rank fusion of their cosine
+// similarity to query and their BM25 score for queryText. With a nil query it is a lexical
+// search, which needs no embedding; items sharing no term with queryText then aren't returned.
+func (idx *ReferenceIndex) HybridSearch(query []float32, queryText string, topK int) []ResultWithScore {
+	if topK <= 0 {
+		return nil
+	}
+	depth := max(4*topK, minFusionDepth)
+	var rankings [][]scoredItem
+	var q []float32
+	ok := false
+	if query != nil {
+		if q, ok = idx.prepareQuery(query); ok {
+			rankings = append(rankings, idx.vectorHits(q, depth))
+		}
+	}
+	lexical := idx.lexical.search(queryText, depth)
+	rankings = append(rankings, lexical)
+	lexicalScores := make(map[int32]float32, len(lexical))
+	for _, hit := range lexical {
+		lexicalScores[hit.index] = hit.score
+	}
+
+	fused := fuseRankings(topK, rankings...)
+	results := make([]ResultWithScore, len(fused))
+	for i, hit := range fused {
+		results[i] = ResultWithScore{Data: idx.Items[hit.index], Lexical: float64(lexicalScores[hit.index]), Fused: float64(hit.score), index: hit.index}
+		if ok && idx.valid[hit.index] {
+			results[i].Score = math.Max(-1, math.Min(1, float64(dot(q, idx.row(int(hit.index))))))
+		}
+	}
+	return results
+}
+
+// Quantized reports whether Search scans int8 codes.
+func (idx *ReferenceIndex) Quantized() bool {
+	return idx.quant != nil
+}
+
+// SearchExact is Search over the full-precision vectors of every item, without the approximate
+// index or quantization.
+func (idx *ReferenceIndex) SearchExact(query []float32, topK int) []ResultWithScore {
+	q, ok := idx.prepareQuery(query)
+	if !ok || topK <= 0 {
+		return nil
+	}
+	return idx.results(idx.scan(q, topK))
+}
+
+func (idx *ReferenceIndex) prepareQuery(query []float32) ([]float32, bool) {
+	if len(query) != idx.dim {
+		if idx.dim > 0 {
+			log.Printf("Warning: %d-dimensional query against %s, which is indexed at %d dimensions", len(query), idx.Path, idx.dim)
+		}
+		return nil, false
+	}
+	q := make([]float32, len(query))
+	copy(q, query)
+	for i, w := range idx.idf {
+		q[i] *= w
+	}
+	return q, normalizeInto(q, q)
+}
+
+func (idx *ReferenceIndex) results(hits []scoredItem) []ResultWithScore {
+	results := make([]ResultWithScore, len(hits))
+	for i, hit := range hits {
+		score := math.Max(-1, math.Min(1, float64(hit.score)))
+		results[i] = ResultWithScore{Data: idx.Items[hit.index], Score: score, index: hit.index}
+	}
+	return results
+}
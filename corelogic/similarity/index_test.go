@@ -0,0 +1,178 @@
+package similarity
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// randomItems returns n items with clustered random embeddings of dimension dim, which is closer
+// to real embeddings than uniform noise and gives the HNSW graph something to navigate.
+func randomItems(rng *rand.Rand, n, dim int) []ReferenceData {
+	clusters := make([][]float32, max(n/100, 1))
+	for c := range clusters {
+		clusters[c] = randomVector(rng, dim)
+	}
+	items := make([]ReferenceData, n)
+	for i := range items {
+		center := clusters[rng.IntN(len(clusters))]
+		vec := make([]float32, dim)
+		for d := range vec {
+			vec[d] = center[d] + 0.5*float32(rng.NormFloat64())
+		}
+		items[i] = ReferenceData{ID: fmt.Sprintf("item-%d", i), Embedding: vec}
+	}
+	return items
+}
+
+func randomVector(rng *rand.Rand, dim int) []float32 {
+	vec := make([]float32, dim)
+	for d := range vec {
+		vec[d] = float32(rng.NormFloat64())
+	}
+	return vec
+}
+
+func resultIndexes(results []ResultWithScore) []int32 {
+	out := make([]int32, len(results))
+	for i, r := range results {
+		out[i] = r.index
+	}
+	return out
+}
+
+func TestTopK(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	scores := make([]float32, 1000)
+	for i := range scores {
+		scores[i] = rng.Float32()
+	}
+	for _, k := range []int{1, 10, 1000, 2000} {
+		top := newTopK(k)
+		for i, s := range scores {
+			top.offer(int32(i), s)
+		}
+		got := top.sorted()
+
+		want := slices.Clone(scores)
+		slices.SortFunc(want, func(a, b float32) int { return -cmpFloat(a, b) })
+		want = want[:min(k, len(want))]
+		if len(got) != len(want) {
+			t.Fatalf("k=%d: got %d items, want %d", k, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].score != want[i] || scores[got[i].index] != got[i].score {
+				t.Fatalf("k=%d: item %d = %+v, want score %v", k, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func cmpFloat(a, b float32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func TestScanParallelMatchesSerial(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	idx := NewReferenceIndex(randomItems(rng, 2*parallelScanMinItems, 32), IndexOptions{})
+	for range 10 {
+		q, ok := idx.prepareQuery(randomVector(rng, 32))
+		if !ok {
+			t.Fatal("query not searchable")
+		}
+		got := idx.scan(q, 10)
+		want := idx.scanRange(q, 10, 0, len(idx.valid)).sorted()
+		if !slices.Equal(got, want) {
+			t.Fatalf("parallel scan = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const (
+		n       = 5000
+		dim     = 64
+		k       = 10
+		queries = 100
+	)
+	rng := rand.New(rand.NewPCG(5, 6))
+	idx := NewReferenceIndex(randomItems(rng, n, dim), IndexOptions{ANN: true})
+	if idx.ann == nil {
+		t.Fatal("no HNSW graph was built")
+	}
+
+	found := 0
+	for range queries {
+		query := randomVector(rng, dim)
+		exact := resultIndexes(idx.SearchExact(query, k))
+		approx := resultIndexes(idx.Search(query, k))
+		for _, i := range approx {
+			if slices.Contains(exact, i) {
+				found++
+			}
+		}
+	}
+	recall := float64(found) / float64(queries*k)
+	t.Logf("HNSW recall@%d over %d items: %.3f", k, n, recall)
+	if recall < 0.9 {
+		t.Errorf("HNSW recall@%d = %.3f, want at least 0.9", k, recall)
+	}
+}
+
+func BenchmarkTopK(b *testing.B) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	scores := make([]float32, 100_000)
+	for i := range scores {
+		scores[i] = rng.Float32()
+	}
+	for _, k := range []int{10, 100} {
+		b.Run(fmt.Sprintf("k=%d", k), func(b *testing.B) {
+			for b.Loop() {
+				top := newTopK(k)
+				for i, s := range scores {
+					top.offer(int32(i), s)
+				}
+				top.sorted()
+			}
+		})
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	const dim = 256
+	rng := rand.New(rand.NewPCG(9, 10))
+	idx := NewReferenceIndex(randomItems(rng, 50_000, dim), IndexOptions{})
+	q, _ := idx.prepareQuery(randomVector(rng, dim))
+	b.Run("serial", func(b *testing.B) {
+		for b.Loop() {
+			idx.scanRange(q, 10, 0, len(idx.valid)).sorted()
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for b.Loop() {
+			idx.scan(q, 10)
+		}
+	})
+}
+
+func BenchmarkHNSWSearch(b *testing.B) {
+	const dim = 256
+	rng := rand.New(rand.NewPCG(11, 12))
+	idx := NewReferenceIndex(randomItems(rng, 50_000, dim), IndexOptions{ANN: true})
+	queries := make([][]float32, 100)
+	for i := range queries {
+		queries[i] = randomVector(rng, dim)
+	}
+	i := 0
+	for b.Loop() {
+		idx.Search(queries[i%len(queries)], 10)
+		i++
+	}
+}
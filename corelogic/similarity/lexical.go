@@ -0,0 +1,119 @@
+package similarity
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: term frequency saturation and document length normalisation.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// rrfK damps the reciprocal rank fusion scores 1/(rrfK+rank), so that the top ranks of one list
+// don't drown the other.
+const rrfK = 60
+
+// tokenize splits text into lower-case runs of letters and digits, so part names like "DRAM" or
+// "CAN-FD" match whatever their case or punctuation.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+type posting struct {
+	doc int32
+	tf  float32
+}
+
+// bm25Index is an inverted index scoring documents for a query with Okapi BM25.
+type bm25Index struct {
+	postings map[string][]posting
+	docLen   []float32
+	avgLen   float32
+}
+
+func buildBM25(docs []string) *bm25Index {
+	b := &bm25Index{postings: map[string][]posting{}, docLen: make([]float32, len(docs))}
+	var total float32
+	for i, doc := range docs {
+		tokens := tokenize(doc)
+		b.docLen[i] = float32(len(tokens))
+		total += b.docLen[i]
+		counts := map[string]float32{}
+		for _, t := range tokens {
+			counts[t]++
+		}
+		for t, n := range counts {
+			b.postings[t] = append(b.postings[t], posting{doc: int32(i), tf: n})
+		}
+	}
+	if len(docs) > 0 {
+		b.avgLen = total / float32(len(docs))
+	}
+	return b
+}
+
+// search returns the k documents scoring highest for the query, best first. Documents sharing no
+// term with the query are left out.
+func (b *bm25Index) search(query string, k int) []scoredItem {
+	n := float64(len(b.docLen))
+	scores := map[int32]float32{}
+	seen := map[string]bool{}
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		list := b.postings[term]
+		if len(list) == 0 {
+			continue
+		}
+		df := float64(len(list))
+		idf := float32(math.Log(1 + (n-df+0.5)/(df+0.5)))
+		for _, p := range list {
+			norm := bm25K1 * (1 - bm25B + bm25B*b.docLen[p.doc]/b.avgLen)
+			scores[p.doc] += idf * p.tf * (bm25K1 + 1) / (p.tf + norm)
+		}
+	}
+	t := newTopK(k)
+	for doc, s := range scores {
+		t.offer(doc, s)
+	}
+	return t.sorted()
+}
+
+// lexicalText is the text of an item that lexical search matches against: its descriptive fields.
+func (r *ReferenceData) lexicalText() string {
+	return strings.Join([]string{r.Asset, r.Category, r.Property, r.AssetDescription, r.Threat,
+		r.DamageScenario, r.ThreatScenario, r.AttackSteps}, "\n")
+}
+
+// fuseRankings combines best-first rankings by reciprocal rank fusion and returns the k best
+// items with their fused scores.
+func fuseRankings(k int, rankings ...[]scoredItem) []scoredItem {
+	fused := map[int32]float32{}
+	for _, ranking := range rankings {
+		for rank, item := range ranking {
+			fused[item.index] += 1 / float32(rrfK+rank+1)
+		}
+	}
+	out := make([]scoredItem, 0, len(fused))
+	for index, score := range fused {
+		out = append(out, scoredItem{index: index, score: score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].index < out[j].index
+	})
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out
+}
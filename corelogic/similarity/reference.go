@@ -0,0 +1,139 @@
+package similarity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Default embedding text format: "Key: value" pairs joined with ", ".
+const (
+	DefaultEmbedTemplate  = "{key}: {value}"
+	DefaultEmbedSeparator = ", "
+)
+
+// ReferenceMetadata describes how the embeddings of a reference file were made, so that queries
+// are embedded the same way.
+type ReferenceMetadata struct {
+	EmbedKeys  []string `json:"embed_keys"`           // Item fields embedded, in order
+	Template   string   `json:"template,omitempty"`   // Per-field text, with {key} and {value} placeholders
+	Separator  string   `json:"separator,omitempty"`  // Between the per-field texts
+	Model      string   `json:"model,omitempty"`      // Embedding model; empty means DefaultEmbeddingModel
+	Dimensions int      `json:"dimensions,omitempty"` // Embedding dimensions; 0 means DefaultEmbeddingDimensions
+}
+
+// ReferenceFile is the on-disk reference format: metadata plus items.
+// Legacy files are a bare JSON array of items without metadata; binary files are described in binary.go.
+type ReferenceFile struct {
+	Metadata *ReferenceMetadata `json:"metadata,omitempty"`
+	Items    []ReferenceData    `json:"items"`
+
+	quantized *quantizedVectors // int8 codes stored in a binary file
+}
+
+// Embedding returns the model and dimensions the file's vectors were made with. Files from before
+// they were recorded used the defaults.
+func (m *ReferenceMetadata) Embedding() EmbeddingSpec {
+	spec := EmbeddingSpec{Model: DefaultEmbeddingModel, Dimensions: DefaultEmbeddingDimensions}
+	if m != nil && m.Model != "" {
+		spec.Model = m.Model
+	}
+	if m != nil && m.Dimensions > 0 {
+		spec.Dimensions = m.Dimensions
+	}
+	return spec
+}
+
+// EmbeddingText builds the text to embed from field values, skipping empty fields.
+func (m ReferenceMetadata) EmbeddingText(values map[string]string) string {
+	template := m.Template
+	if template == "" {
+		template = DefaultEmbedTemplate
+	}
+	separator := m.Separator
+	if separator == "" {
+		separator = DefaultEmbedSeparator
+	}
+	var parts []string
+	for _, key := range m.EmbedKeys {
+		if val := values[key]; val != "" {
+			parts = append(parts, strings.NewReplacer("{key}", key, "{value}", val).Replace(template))
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+// InputValues returns the input's fields keyed by their JSON names (the config data keys).
+func InputValues(input InputData) map[string]string {
+	b, _ := json.Marshal(input)
+	var values map[string]string
+	_ = json.Unmarshal(b, &values)
+	return values
+}
+
+// ShotValues returns an item's fields other than the embedding, keyed by their JSON names (the
+// config's shot keys).
+func ShotValues(item ReferenceData) map[string]any {
+	item.Embedding = nil
+	b, _ := json.Marshal(item)
+	var values map[string]any
+	_ = json.Unmarshal(b, &values)
+	delete(values, "embedding")
+	return values
+}
+
+// LoadReferenceFile reads a reference file in any format. Legacy files have nil Metadata.
+func LoadReferenceFile(filePath string) (*ReferenceFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference data file %s: %w", filePath, err)
+	}
+	if bytes.HasPrefix(data, binaryMagic) {
+		file, err := decodeBinaryReference(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode binary reference data from %s: %w", filePath, err)
+		}
+		return file, nil
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []ReferenceData
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal reference data from %s: %w", filePath, err)
+		}
+		return &ReferenceFile{Items: items}, nil
+	}
+	var file ReferenceFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reference data from %s: %w", filePath, err)
+	}
+	return &file, nil
+}
+
+// WriteReferenceFile writes a reference file in the metadata format.
+func WriteReferenceFile(filePath string, file *ReferenceFile) error {
+	if file.Metadata == nil || len(file.Metadata.EmbedKeys) == 0 {
+		return fmt.Errorf("reference file %s needs metadata with the embed keys", filePath)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first so readers never see a partial file
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// embedFormat returns the format the file's embeddings were made with. Legacy files don't record it;
+// they are assumed to use the analysis' embed keys in the default format.
+func (idx *ReferenceIndex) embedFormat(embedKeys []string) ReferenceMetadata {
+	if idx.Metadata != nil && len(idx.Metadata.EmbedKeys) > 0 {
+		return *idx.Metadata
+	}
+	return ReferenceMetadata{EmbedKeys: embedKeys}
+}
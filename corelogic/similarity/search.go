@@ -2,27 +2,36 @@ package similarity
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
-	"sort"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
 )
 
-// --- Cosine Similarity Calculation ---
+// Search modes, set with REFERENCE_SEARCH_MODE.
+const (
+	SearchHybrid  = "hybrid"  // Fuse vector and lexical rankings; lexical only without a usable embedding
+	SearchVector  = "vector"  // Cosine similarity only; fails when the query can't be embedded
+	SearchLexical = "lexical" // BM25 only; needs no embedding at all
+)
 
-func dotProduct(a, b []float32) (float64, error) {
-	if len(a) != len(b) {
-		return 0, fmt.Errorf("vectors must have the same dimension")
-	}
-	var sum float64 = 0
-	for i := range a {
-		sum += float64(a[i]) * float64(b[i])
+// ConfiguredSearchMode returns REFERENCE_SEARCH_MODE, SearchHybrid by default.
+func ConfiguredSearchMode() string {
+	switch mode := os.Getenv("REFERENCE_SEARCH_MODE"); mode {
+	case SearchHybrid, SearchVector, SearchLexical:
+		return mode
+	case "":
+	default:
+		log.Printf("Warning: ignoring invalid REFERENCE_SEARCH_MODE %q", mode)
 	}
-	return sum, nil
+	return SearchHybrid
 }
 
+// --- Vector helpers ---
+
 func magnitude(vec []float32) float64 {
 	var sumSq float64 = 0
 	for _, val := range vec {
@@ -31,105 +40,165 @@ func magnitude(vec []float32) float64 {
 	return math.Sqrt(sumSq)
 }
 
-func cosineSimilarity(a, b []float32) (float64, error) {
-	dot, err := dotProduct(a, b)
+// --- Main Similarity Search Function ---
+
+// FindTopKShotsFile finds the top K similar items from a reference data file, by relevance alone.
+func FindTopKShotsFile(ctx context.Context, input InputData, referenceDataPath string, embedKeys []string, topK int) (*SimilarityResult, error) {
+	return FindShotsFile(ctx, input, referenceDataPath, embedKeys, ShotOptions{MaxShots: topK})
+}
+
+// FindShotsFile picks shots for the input from a reference data file and, with opts.AnalysisType,
+// from the calling tenant's own library of the analysis; see ShotOptions and ConfigureTenantShots.
+// The input is embedded in the format recorded in the file, or, for legacy files without
+// metadata, from embedKeys (the analysis config's EmbedKeys) in the default format.
+// Items are ranked by ConfiguredSearchMode.
+func FindShotsFile(ctx context.Context, input InputData, referenceDataPath string, embedKeys []string, opts ShotOptions) (*SimilarityResult, error) {
+	// 1. Get the Reference Index
+	// NOTE: 'referenceDataPath' based on the task type (e.g., damage scenario)
+	// The index is loaded once and kept in memory; see GetReferenceIndex
+	index, err := GetReferenceIndex(referenceDataPath)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("failed to load reference data: %w", err)
 	}
+	if len(index.Items) == 0 {
+		return nil, fmt.Errorf("no reference items loaded from %s", referenceDataPath)
+	}
+	log.Printf("Searching %d reference items from %s", len(index.Items), referenceDataPath)
 
-	magA := magnitude(a)
-	magB := magnitude(b)
-
-	if magA == 0 || magB == 0 {
-		// Handle zero vectors - similarity is arguably 0 or undefined.
-		// Returning 0 is common practice in some libraries.
-		return 0, nil
-		// Alternatively: return 0, fmt.Errorf("cannot compute similarity with zero vector")
+	format := index.embedFormat(embedKeys)
+	if index.Metadata == nil {
+		log.Printf("Warning: %s has no embedding metadata; assuming embed keys %v", referenceDataPath, embedKeys)
+	} else if !sameKeys(format.EmbedKeys, embedKeys) {
+		log.Printf("Warning: %s was embedded with keys %v, config has %v; using the file's keys", referenceDataPath, format.EmbedKeys, embedKeys)
 	}
 
-	similarity := dot / (magA * magB)
-	// Clamp similarity to [-1, 1] due to potential floating point inaccuracies
-	return math.Max(-1.0, math.Min(1.0, similarity)), nil
-}
+	// 2. Get Input Embedding, in the same format as the reference embeddings
+	mode := ConfiguredSearchMode()
+	inputEmbedding, err := index.queryEmbedding(ctx, mode, func() ([]float32, error) {
+		return embedInput(ctx, input, format)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input embedding: %w", err)
+	}
 
-// --- Data Loading ---
+	// 3. Rank candidates, then pick relevant, varied shots that fit the budget
+	candidates := opts.MaxShots
+	if opts.Diversity > 0 || opts.MinScore > 0 || opts.TokenBudget > 0 {
+		candidates = max(4*opts.MaxShots, minShotCandidates)
+	}
+	var resultsWithScores []ResultWithScore
+	if mode == SearchVector {
+		resultsWithScores = index.Search(inputEmbedding, candidates)
+	} else {
+		// The lexical query is the bare values, without the embedding template's key names
+		lexicalFormat := ReferenceMetadata{EmbedKeys: format.EmbedKeys, Template: "{value}", Separator: "\n"}
+		resultsWithScores = index.HybridSearch(inputEmbedding, lexicalFormat.EmbeddingText(InputValues(input)), candidates)
+	}
+	shared := tenantShotsConfig()
+	tenant := tenantCandidates(ctx, shared, opts.AnalysisType, inputEmbedding, candidates)
+	globalWeight := 1.0 // Nothing to blend with
+	if len(tenant) > 0 {
+		globalWeight = shared.GlobalWeight
+	}
+	var pool []shotCandidate
+	if globalWeight > 0 {
+		// A tenant shot replaces the global item with its ID
+		replaced := make(map[string]bool, len(tenant))
+		for _, c := range tenant {
+			replaced[c.Data.ID] = true
+		}
+		for _, c := range index.shotCandidates(resultsWithScores, globalWeight) {
+			if !replaced[c.Data.ID] {
+				pool = append(pool, c)
+			}
+		}
+	}
+	pool = append(pool, tenant...)
+	finalShots, scores := selectShots(pool, opts, inputEmbedding != nil)
 
-// loadReferenceData reads reference data from a JSON file.
-// Assumes the JSON file contains a list of ReferenceData objects.
-func loadReferenceData(filePath string) ([]ReferenceData, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read reference data file %s: %w", filePath, err)
+	log.Printf("Returning %d shots of %d candidates (%d from the tenant library)", len(finalShots), len(pool), len(tenant))
+	result := &SimilarityResult{
+		Shots:  finalShots,
+		Scores: scores,
 	}
 
-	var referenceItems []ReferenceData
-	err = json.Unmarshal(data, &referenceItems)
+	return result, nil
+}
+
+// tenantCandidates returns the k shots of the calling tenant's library of the analysis most similar
+// to the input embedding. Without a store, weight, analysis or embedding there are none; a failing
+// store is logged and skipped, so the reference file still serves.
+func tenantCandidates(ctx context.Context, cfg TenantShotsConfig, analysisType string, inputEmbedding []float32, k int) []shotCandidate {
+	if cfg.Store == nil || cfg.TenantWeight <= 0 || analysisType == "" || inputEmbedding == nil {
+		return nil
+	}
+	results, err := cfg.Store.SearchShots(ctx, analysisType, ConfiguredEmbedding(), inputEmbedding, k)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal reference data from %s: %w", filePath, err)
+		log.Printf("Warning: tenant shots of %s unavailable: %v", analysisType, err)
+		return nil
 	}
-	return referenceItems, nil
+	return tenantShotCandidates(results, cfg.TenantWeight)
 }
 
-// --- Main Similarity Search Function ---
-
-// FindTopKShotsFile finds the top K similar items from a reference data file.
-func FindTopKShotsFile(ctx context.Context, input InputData, referenceDataPath string, topK int) (*SimilarityResult, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+// SearchReferenceText finds the top K reference items most similar to a free-text query, ranked
+// by ConfiguredSearchMode.
+func SearchReferenceText(ctx context.Context, referenceDataPath string, query string, topK int) ([]ResultWithScore, error) {
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
 	}
-
-	// 1. Get Input Embedding
-	inputEmbedding, err := getOpenAIEmbedding(ctx, input, apiKey)
+	index, err := GetReferenceIndex(referenceDataPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get input embedding: %w", err)
+		return nil, err
 	}
-	log.Println("Successfully retrieved input embedding")
-
-	// 2. Load Reference Data from File
-	// NOTE: 'referenceDataPath' based on the task type (e.g., damage scenario)
-	referenceItems, err := loadReferenceData(referenceDataPath)
+	mode := ConfiguredSearchMode()
+	queryEmbedding, err := index.queryEmbedding(ctx, mode, func() ([]float32, error) {
+		return embedText(ctx, query)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to load reference data: %w", err)
+		return nil, fmt.Errorf("failed to get query embedding: %w", err)
 	}
-	if len(referenceItems) == 0 {
-		return nil, fmt.Errorf("no reference items loaded from %s", referenceDataPath)
+	if mode == SearchVector {
+		return index.Search(queryEmbedding, topK), nil
 	}
-	log.Printf("Loaded %d reference items from %s", len(referenceItems), referenceDataPath)
+	return index.HybridSearch(queryEmbedding, query, topK), nil
+}
 
-	// 3. Calculate Similarities
-	resultsWithScores := make([]ResultWithScore, 0, len(referenceItems))
-	for _, item := range referenceItems {
-		similarity, err := cosineSimilarity(inputEmbedding, item.Embedding)
-		if err != nil {
-			log.Printf("Warning: could not calculate similarity for item %s: %v", item.ID, err)
-			continue // Skip item if similarity calculation fails
+// queryEmbedding embeds a query for the search mode. In hybrid mode a library embedded unlike the
+// queries (ErrEmbeddingMismatch), no embedding configured (ErrNoEmbedding), or an unreachable or
+// failing embedding provider (llm.IsProviderFailure) leaves the query without an embedding so the
+// search falls back to lexical matching; in vector mode these are errors. An exhausted quota or a
+// cancelled context is always returned: the caller's LLM calls would fail the same way.
+func (idx *ReferenceIndex) queryEmbedding(ctx context.Context, mode string, embed func() ([]float32, error)) ([]float32, error) {
+	if mode == SearchLexical {
+		return nil, nil
+	}
+	err := idx.checkEmbedding(ConfiguredEmbedding())
+	if err == nil {
+		var embedding []float32
+		if embedding, err = embed(); err == nil {
+			return embedding, nil
 		}
-		resultsWithScores = append(resultsWithScores, ResultWithScore{Data: item, Score: similarity})
 	}
-
-	// 4. Sort by Similarity Score (Descending)
-	sort.Slice(resultsWithScores, func(i, j int) bool {
-		return resultsWithScores[i].Score > resultsWithScores[j].Score // Higher score first
-	})
-
-	// 5. Get Top K Results
-	actualTopK := topK
-	if len(resultsWithScores) < topK {
-		actualTopK = len(resultsWithScores)
+	if mode == SearchVector || ctx.Err() != nil || !lexicalFallback(err) {
+		return nil, err
 	}
+	log.Printf("Warning: %v; ranking %s by lexical match only", err, idx.Path)
+	return nil, nil
+}
 
-	finalShots := make([]ReferenceData, actualTopK)
-	for i := 0; i < actualTopK; i++ {
-		finalShots[i] = resultsWithScores[i].Data
-		// Optionally add the score back to the result if needed elsewhere
-		// finalShots[i].SimilarityScore = resultsWithScores[i].Score
-	}
+// lexicalFallback reports whether a hybrid search may rank by lexical match alone after err.
+func lexicalFallback(err error) bool {
+	return errors.Is(err, ErrEmbeddingMismatch) || errors.Is(err, ErrNoEmbedding) || llm.IsProviderFailure(err)
+}
 
-	log.Printf("Returning top %d shots", actualTopK)
-	result := &SimilarityResult{
-		Shots: finalShots,
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-
-	return result, nil
-}
\ No newline at end of file
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
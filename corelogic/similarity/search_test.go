@@ -0,0 +1,96 @@
+package similarity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+)
+
+// writeReferenceFile writes a reference library embedded with the local test model.
+func writeReferenceFile(t *testing.T, items []ReferenceData) string {
+	t.Helper()
+	file := ReferenceFile{
+		Metadata: &ReferenceMetadata{EmbedKeys: []string{"Asset", "Asset Description"}, Model: "local:test-embed", Dimensions: 4},
+		Items:    items,
+	}
+	b, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "reference.json")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFindShotsFileWithFailingEmbedder(t *testing.T) {
+	// The embedding provider answers every request with a server error until it is closed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"message": "overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	t.Setenv("LOCAL_LLM_BASE_URL", server.URL+"/v1")
+	t.Setenv("EMBEDDING_MODEL", "local:test-embed")
+	t.Setenv("EMBEDDING_DIMENSIONS", "4")
+	t.Setenv("REFERENCE_SEARCH_MODE", SearchHybrid)
+
+	path := writeReferenceFile(t, []ReferenceData{
+		{ID: "brake", Embedding: []float32{1, 0, 0, 0}, Asset: "Brake ECU", AssetDescription: "Controls the hydraulic brakes"},
+		{ID: "radio", Embedding: []float32{0, 1, 0, 0}, Asset: "Infotainment head unit", AssetDescription: "Plays the radio"},
+		{ID: "gateway", Embedding: []float32{0, 0, 1, 0}, Asset: "Telematics gateway", AssetDescription: "Connects the vehicle to the backend"},
+	})
+	input := InputData{Asset: "Brake ECU", AssetDescription: "hydraulic brakes"}
+	opts := ShotOptions{MaxShots: 1}
+
+	wantLexical := func(t *testing.T, ctx context.Context) {
+		t.Helper()
+		result, err := FindShotsFile(ctx, input, path, nil, opts)
+		if err != nil {
+			t.Fatalf("FindShotsFile returned error: %v", err)
+		}
+		if len(result.Shots) != 1 || result.Shots[0].ID != "brake" {
+			t.Fatalf("FindShotsFile shots = %+v, want the brake item by lexical match", result.Shots)
+		}
+	}
+
+	t.Run("server error", func(t *testing.T) {
+		wantLexical(t, context.Background())
+	})
+
+	t.Run("quota exhausted", func(t *testing.T) {
+		llm.SetTenantLimits("search-test", llm.TenantLimits{Tenant: llm.Limits{TokensPerDay: 1}})
+		ctx := llm.WithCaller(context.Background(), "search-test", "")
+		_, err := FindShotsFile(ctx, input, path, nil, opts)
+		if !errors.Is(err, llm.ErrRateLimited) {
+			t.Fatalf("FindShotsFile error = %v, want ErrRateLimited", err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := FindShotsFile(ctx, input, path, nil, opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("FindShotsFile error = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		server.Close()
+		wantLexical(t, context.Background())
+	})
+
+	t.Run("vector mode", func(t *testing.T) {
+		t.Setenv("REFERENCE_SEARCH_MODE", SearchVector)
+		if _, err := FindShotsFile(context.Background(), input, path, nil, opts); err == nil {
+			t.Fatal("FindShotsFile succeeded in vector mode without an embedding")
+		}
+	})
+}
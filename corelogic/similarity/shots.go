@@ -0,0 +1,188 @@
+package similarity
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// ShotOptions controls how FindShotsFile picks shots among the best ranked reference items.
+type ShotOptions struct {
+	MaxShots    int      // Most shots returned
+	MinScore    float64  // Items less similar to the input by cosine are dropped; 0 keeps all. Not applied when the input couldn't be embedded
+	Diversity   float64  // Maximal marginal relevance trade-off, 0-1: 0 ranks by relevance alone, higher values skip items like those already picked
+	TokenBudget int      // Estimated prompt tokens all shots may take together; 0 for no limit
+	BudgetKeys  []string // Fields of a shot that reach the prompt and count against TokenBudget; empty counts all
+
+	AnalysisType string // Analysis whose tenant shots are blended in (see ConfigureTenantShots); empty for the file only
+}
+
+// Libraries a shot can come from.
+const (
+	ShotSourceGlobal = "global" // The analysis' reference file, shared by all tenants
+	ShotSourceTenant = "tenant" // The calling tenant's own library
+)
+
+// ShotScore records why a shot was chosen.
+type ShotScore struct {
+	ID         string  `json:"id"`
+	Source     string  `json:"source"`            // ShotSourceGlobal or ShotSourceTenant
+	Similarity float64 `json:"similarity"`        // Cosine similarity to the input; 0 when it couldn't be embedded
+	Lexical    float64 `json:"lexical,omitempty"` // BM25 score of the input's text
+	Fused      float64 `json:"fused,omitempty"`   // Reciprocal rank fusion score of a hybrid search
+	Relevance  float64 `json:"relevance"`         // Similarity, or without an embedding Lexical scaled so the best candidate has 1, times the library's weight
+	MMR        float64 `json:"mmr"`               // Relevance less the diversity penalty, when the shot was picked
+	Tokens     int     `json:"tokens"`            // Estimated prompt tokens of the shot
+}
+
+// minShotCandidates is the least number of ranked items the shots are picked from.
+const minShotCandidates = 20
+
+// shotCandidate is a ranked item that may become a shot.
+type shotCandidate struct {
+	ResultWithScore
+	source string
+	weight float64   // Weight of the candidate's library
+	vec    []float32 // Unit vector compared for diversity; nil to compare words
+}
+
+// shotCandidates wraps ranked items of the index.
+func (idx *ReferenceIndex) shotCandidates(results []ResultWithScore, weight float64) []shotCandidate {
+	candidates := make([]shotCandidate, len(results))
+	for i, r := range results {
+		candidates[i] = shotCandidate{ResultWithScore: r, source: ShotSourceGlobal, weight: weight}
+		if idx.valid[r.index] {
+			candidates[i].vec = idx.row(int(r.index))
+		}
+	}
+	return candidates
+}
+
+// tenantShotCandidates wraps shots found in a tenant library, moving their embeddings out of Data.
+func tenantShotCandidates(results []ResultWithScore, weight float64) []shotCandidate {
+	candidates := make([]shotCandidate, len(results))
+	for i, r := range results {
+		candidates[i] = shotCandidate{ResultWithScore: r, source: ShotSourceTenant, weight: weight}
+		vec := make([]float32, len(r.Data.Embedding))
+		if normalizeInto(vec, r.Data.Embedding) {
+			candidates[i].vec = vec
+		}
+		candidates[i].Data.Embedding = nil
+	}
+	return candidates
+}
+
+// selectShots picks up to opts.MaxShots of the ranked candidates by maximal marginal relevance:
+// each pick maximises (1-Diversity)*relevance - Diversity*(highest similarity to a picked shot),
+// among the candidates that still fit the token budget. Relevance is the cosine similarity to the
+// input, or when the input couldn't be embedded its BM25 score relative to the best candidate's,
+// times the weight of the candidate's library. Without diversity, candidates of a single library
+// keep the order of the search that ranked them.
+func selectShots(candidates []shotCandidate, opts ShotOptions, embedded bool) ([]ReferenceData, []ShotScore) {
+	if embedded && opts.MinScore > 0 {
+		kept := candidates[:0:0]
+		for _, c := range candidates {
+			if c.Score >= opts.MinScore {
+				kept = append(kept, c)
+			}
+		}
+		candidates = kept
+	}
+	if len(candidates) == 0 || opts.MaxShots <= 0 {
+		return nil, nil
+	}
+
+	relevance := make([]float64, len(candidates))
+	tokens := make([]int, len(candidates))
+	var bestLexical float64
+	inOrder := opts.Diversity == 0
+	for _, c := range candidates {
+		bestLexical = math.Max(bestLexical, c.Lexical)
+		inOrder = inOrder && c.source == candidates[0].source
+	}
+	for i, c := range candidates {
+		relevance[i] = c.Score
+		if !embedded && bestLexical > 0 {
+			relevance[i] = c.Lexical / bestLexical
+		}
+		relevance[i] *= c.weight
+		tokens[i] = shotTokens(c.Data, opts.BudgetKeys)
+	}
+
+	var shots []ReferenceData
+	var scores []ShotScore
+	picked := make([]bool, len(candidates))
+	redundancy := make([]float64, len(candidates)) // Highest similarity to a picked shot
+	budget := opts.TokenBudget
+	for len(shots) < opts.MaxShots {
+		best, bestMMR := -1, math.Inf(-1)
+		for i := range candidates {
+			if picked[i] || (opts.TokenBudget > 0 && tokens[i] > budget) {
+				continue
+			}
+			mmr := (1-opts.Diversity)*relevance[i] - opts.Diversity*redundancy[i]
+			if mmr > bestMMR {
+				best, bestMMR = i, mmr
+			}
+			if inOrder {
+				break // The first candidate that fits
+			}
+		}
+		if best < 0 {
+			break
+		}
+		picked[best] = true
+		budget -= tokens[best]
+		c := candidates[best]
+		shots = append(shots, c.Data)
+		scores = append(scores, ShotScore{ID: c.Data.ID, Source: c.source, Similarity: c.Score, Lexical: c.Lexical, Fused: c.Fused, Relevance: relevance[best], MMR: bestMMR, Tokens: tokens[best]})
+		if opts.Diversity > 0 {
+			for i := range candidates {
+				if !picked[i] {
+					redundancy[i] = math.Max(redundancy[i], candidateSimilarity(candidates[i], c))
+				}
+			}
+		}
+	}
+	return shots, scores
+}
+
+// candidateSimilarity is the cosine similarity of two candidates' vectors, or the overlap of their
+// words when either has none.
+func candidateSimilarity(a, b shotCandidate) float64 {
+	if a.vec != nil && len(a.vec) == len(b.vec) {
+		return float64(dot(a.vec, b.vec))
+	}
+	wordsA, wordsB := map[string]bool{}, map[string]bool{}
+	for _, t := range tokenize(a.Data.lexicalText()) {
+		wordsA[t] = true
+	}
+	for _, t := range tokenize(b.Data.lexicalText()) {
+		wordsB[t] = true
+	}
+	shared := 0
+	for t := range wordsA {
+		if wordsB[t] {
+			shared++
+		}
+	}
+	if union := len(wordsA) + len(wordsB) - shared; union > 0 {
+		return float64(shared) / float64(union)
+	}
+	return 0
+}
+
+// shotTokens estimates the prompt tokens of a shot's fields, at about four characters per token.
+func shotTokens(item ReferenceData, keys []string) int {
+	values := ShotValues(item)
+	if len(keys) > 0 {
+		filtered := make(map[string]any, len(keys))
+		for _, key := range keys {
+			if v, ok := values[key]; ok {
+				filtered[key] = v
+			}
+		}
+		values = filtered
+	}
+	b, _ := json.Marshal(values)
+	return len(b)/4 + 1
+}
@@ -0,0 +1,119 @@
+package similarity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrTenantShotsDisabled is returned when tenant shots are changed but no TenantShotStore is configured.
+var ErrTenantShotsDisabled = errors.New("tenant shot libraries are not enabled")
+
+// TenantShotStore holds every tenant's own reference shots, per analysis type. Implementations take
+// the tenant from the context (llm.CallerFromContext) and must never return another tenant's shots.
+type TenantShotStore interface {
+	// SearchShots returns up to k shots of the analysis embedded with spec, most similar to the
+	// query first, with Score set to their cosine similarity and Data.Embedding to their vector.
+	// Without a tenant in ctx it returns nothing.
+	SearchShots(ctx context.Context, analysisType string, spec EmbeddingSpec, query []float32, k int) ([]ResultWithScore, error)
+	// UpsertShots inserts the shots, or replaces those with the same ID; each carries its embedding.
+	UpsertShots(ctx context.Context, analysisType string, spec EmbeddingSpec, shots []ReferenceData) error
+	// DeleteShots removes the shots with the given IDs and returns how many existed.
+	DeleteShots(ctx context.Context, analysisType string, ids []string) (int, error)
+}
+
+// TenantShotsConfig blends tenant shots into shot selection. The weights scale the relevance of
+// shots from each library; a zero weight leaves that library out, though the global library still
+// serves tenants without shots of their own.
+type TenantShotsConfig struct {
+	Store        TenantShotStore
+	TenantWeight float64
+	GlobalWeight float64
+}
+
+var (
+	tenantShotsMu sync.RWMutex
+	tenantShots   = TenantShotsConfig{GlobalWeight: 1}
+)
+
+// ConfigureTenantShots sets the tenant shot store and the library weights.
+func ConfigureTenantShots(cfg TenantShotsConfig) {
+	tenantShotsMu.Lock()
+	defer tenantShotsMu.Unlock()
+	tenantShots = cfg
+}
+
+func tenantShotsConfig() TenantShotsConfig {
+	tenantShotsMu.RLock()
+	defer tenantShotsMu.RUnlock()
+	return tenantShots
+}
+
+// upsertConcurrency bounds the embedding requests in flight for one UpsertTenantShots call.
+const upsertConcurrency = 4
+
+// UpsertTenantShots embeds shots of the calling tenant and stores them in its library of the
+// analysis. They are embedded in the format of the analysis' reference file at referenceDataPath
+// (see FindShotsFile) with the configured embedding, so input embeddings can be compared to them.
+func UpsertTenantShots(ctx context.Context, analysisType string, referenceDataPath string, embedKeys []string, shots []ReferenceData) error {
+	store := tenantShotsConfig().Store
+	if store == nil {
+		return ErrTenantShotsDisabled
+	}
+	index, err := GetReferenceIndex(referenceDataPath)
+	if err != nil {
+		return fmt.Errorf("failed to load reference data: %w", err)
+	}
+	format := index.embedFormat(embedKeys)
+	spec := ConfiguredEmbedding()
+
+	// Refuse the whole batch before embedding anything
+	texts := make([]string, len(shots))
+	for i := range shots {
+		texts[i] = format.EmbeddingText(stringValues(ShotValues(shots[i])))
+		if texts[i] == "" {
+			return fmt.Errorf("shot %s has none of the embed keys %v", shots[i].ID, format.EmbedKeys)
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(upsertConcurrency)
+	for i := range shots {
+		g.Go(func() error {
+			embedding, err := EmbedText(gctx, texts[i], spec)
+			if err != nil {
+				return fmt.Errorf("failed to embed shot %s: %w", shots[i].ID, err)
+			}
+			shots[i].Embedding = embedding
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return store.UpsertShots(ctx, analysisType, spec, shots)
+}
+
+// DeleteTenantShots removes shots from the calling tenant's library of the analysis and returns
+// how many existed.
+func DeleteTenantShots(ctx context.Context, analysisType string, ids []string) (int, error) {
+	store := tenantShotsConfig().Store
+	if store == nil {
+		return 0, ErrTenantShotsDisabled
+	}
+	return store.DeleteShots(ctx, analysisType, ids)
+}
+
+// stringValues formats the values of a shot for its embedding text, as reftool build does.
+func stringValues(values map[string]any) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		if v != nil {
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
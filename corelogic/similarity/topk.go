@@ -0,0 +1,210 @@
+package similarity
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// parallelScanMinItems is the index size from which a full scan is split across cores; below it
+// the goroutine overhead outweighs the gain.
+const parallelScanMinItems = 8192
+
+// scoredItem is a row of a ReferenceIndex with its similarity to the query.
+type scoredItem struct {
+	index int32
+	score float32
+}
+
+// scoreHeap is a binary min-heap on score.
+type scoreHeap []scoredItem
+
+func (h *scoreHeap) push(item scoredItem) {
+	*h = append(*h, item)
+	s := *h
+	i := len(s) - 1
+	for i > 0 {
+		parent := (i - 1) / 2
+		if s[parent].score <= s[i].score {
+			break
+		}
+		s[parent], s[i] = s[i], s[parent]
+		i = parent
+	}
+}
+
+func (h *scoreHeap) pop() scoredItem {
+	s := *h
+	top := s[0]
+	last := len(s) - 1
+	s[0] = s[last]
+	*h = s[:last]
+	h.down(0)
+	return top
+}
+
+func (h scoreHeap) down(i int) {
+	for {
+		smallest := i
+		if l := 2*i + 1; l < len(h) && h[l].score < h[smallest].score {
+			smallest = l
+		}
+		if r := 2*i + 2; r < len(h) && h[r].score < h[smallest].score {
+			smallest = r
+		}
+		if smallest == i {
+			return
+		}
+		h[i], h[smallest] = h[smallest], h[i]
+		i = smallest
+	}
+}
+
+// topK keeps the k best-scoring items seen so far; the worst of them sits at the heap root.
+type topK struct {
+	k    int
+	heap scoreHeap
+}
+
+func newTopK(k int) *topK {
+	return &topK{k: k, heap: make(scoreHeap, 0, min(k, 256))}
+}
+
+func (t *topK) full() bool {
+	return len(t.heap) >= t.k
+}
+
+// worst returns the lowest kept score; only meaningful once the heap is full.
+func (t *topK) worst() float32 {
+	return t.heap[0].score
+}
+
+func (t *topK) offer(index int32, score float32) {
+	if len(t.heap) < t.k {
+		t.heap.push(scoredItem{index: index, score: score})
+		return
+	}
+	if score <= t.heap[0].score {
+		return
+	}
+	t.heap[0] = scoredItem{index: index, score: score}
+	t.heap.down(0)
+}
+
+// sorted returns the kept items best first, ties broken by row order for stable results.
+func (t *topK) sorted() []scoredItem {
+	out := append([]scoredItem(nil), t.heap...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].index < out[j].index
+	})
+	return out
+}
+
+// dot is the inner product of two equal-length vectors, unrolled by four.
+func dot(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// rescoreFactor is how many int8 candidates per wanted result a quantized scan rescores in float32.
+const rescoreFactor = 4
+
+// scan scores every searchable row against the unit query q and returns the k best, best first.
+func (idx *ReferenceIndex) scan(q []float32, k int) []scoredItem {
+	return idx.parallelTopK(k, func(start, end int) *topK {
+		return idx.scanRange(q, k, start, end)
+	}).sorted()
+}
+
+// scanQuantized picks candidates by scoring the int8 codes, then rescores them with the
+// full-precision vectors and returns the k best, best first.
+func (idx *ReferenceIndex) scanQuantized(q []float32, k int) []scoredItem {
+	qCodes := make([]int8, len(q))
+	qScale := quantizeRow(qCodes, q)
+	n := max(k*rescoreFactor, 32)
+	candidates := idx.parallelTopK(n, func(start, end int) *topK {
+		return idx.scanRangeInt8(qCodes, qScale, n, start, end)
+	})
+	rescored := newTopK(k)
+	for _, c := range candidates.heap {
+		rescored.offer(c.index, dot(q, idx.row(int(c.index))))
+	}
+	return rescored.sorted()
+}
+
+// parallelTopK runs scanRange over all rows and returns the k best. Large indexes are split into
+// one chunk per core, each with its own heap, merged at the end.
+func (idx *ReferenceIndex) parallelTopK(k int, scanRange func(start, end int) *topK) *topK {
+	n := len(idx.valid)
+	workers := runtime.GOMAXPROCS(0)
+	if n < parallelScanMinItems || workers < 2 {
+		return scanRange(0, n)
+	}
+
+	chunk := (n + workers - 1) / workers
+	partial := make([]*topK, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start := w * chunk
+		if start >= n {
+			break
+		}
+		end := min(start+chunk, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partial[w] = scanRange(start, end)
+		}()
+	}
+	wg.Wait()
+
+	merged := newTopK(k)
+	for _, t := range partial {
+		if t == nil {
+			continue
+		}
+		for _, item := range t.heap {
+			merged.offer(item.index, item.score)
+		}
+	}
+	return merged
+}
+
+func (idx *ReferenceIndex) scanRange(q []float32, k, start, end int) *topK {
+	t := newTopK(k)
+	dim := idx.dim
+	for i := start; i < end; i++ {
+		if !idx.valid[i] {
+			continue
+		}
+		t.offer(int32(i), dot(q, idx.vectors[i*dim:(i+1)*dim]))
+	}
+	return t
+}
+
+func (idx *ReferenceIndex) scanRangeInt8(qCodes []int8, qScale float32, k, start, end int) *topK {
+	t := newTopK(k)
+	dim := idx.dim
+	codes, scales := idx.quant.codes, idx.quant.scales
+	for i := start; i < end; i++ {
+		if !idx.valid[i] {
+			continue
+		}
+		t.offer(int32(i), float32(dotInt8(qCodes, codes[i*dim:(i+1)*dim]))*qScale*scales[i])
+	}
+	return t
+}
@@ -81,6 +81,7 @@ func main() {
 
 		start := time.Now() // Start timer
 		var result interface{}
+		var runInfo *similarity.RunInfo
 		var workflowErr error
 
 		log.Printf("\n==================== STARTING GO Test: %s ====================", analysisType)
@@ -88,17 +89,17 @@ func main() {
 		// Call the appropriate workflow function
 		switch analysisType {
 		case config.DamageScenarioAnalysis:
-			result, workflowErr = workflows.GenerateDamageScenario(context.Background(), inputData, systemInfo, baseDataPath)
+			result, runInfo, workflowErr = workflows.GenerateDamageScenario(context.Background(), inputData, systemInfo, baseDataPath)
 		case config.ImpactScoresAnalysis:
-			result, workflowErr = workflows.GenerateImpactScores(context.Background(), inputData, systemInfo, baseDataPath)
+			result, runInfo, workflowErr = workflows.GenerateImpactScores(context.Background(), inputData, systemInfo, baseDataPath)
 		case config.ThreatScenarioAnalysis:
-			result, workflowErr = workflows.GenerateThreatScenario(context.Background(), inputData, systemInfo, baseDataPath)
+			result, runInfo, workflowErr = workflows.GenerateThreatScenario(context.Background(), inputData, systemInfo, baseDataPath)
 		case config.AttackStepsAnalysis:
-			result, workflowErr = workflows.GenerateAttackSteps(context.Background(), inputData, systemInfo, baseDataPath)
+			result, runInfo, workflowErr = workflows.GenerateAttackSteps(context.Background(), inputData, systemInfo, baseDataPath)
 		case config.FeasibilityAnalysis:
-			result, workflowErr = workflows.GenerateFeasibility(context.Background(), inputData, systemInfo, baseDataPath)
+			result, runInfo, workflowErr = workflows.GenerateFeasibility(context.Background(), inputData, systemInfo, baseDataPath)
 		case config.AttackTreeAnalysis:
-			result, workflowErr = workflows.GenerateAttackTree(context.Background(), inputData, systemInfo, baseDataPath)
+			result, runInfo, workflowErr = workflows.GenerateAttackTree(context.Background(), inputData, systemInfo, baseDataPath)
 		default:
 			log.Printf("Skipping unrecognized analysis type: %s", analysisType)
 			continue // Skip to next iteration
@@ -118,6 +119,7 @@ func main() {
 				resultStr = resultStr[:maxLen] + "..."
 			}
 			fmt.Printf("Result Snippet: %s\n", resultStr)
+			fmt.Printf("Answered by: %s (fallback used: %t)\n", runInfo.Model, runInfo.FallbackUsed)
 		}
 		fmt.Println("---------------------------------------------")
 
@@ -0,0 +1,285 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/catalog"
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// Defaults for StepAgent when the config leaves them unset.
+const (
+	defaultAgentSteps   = 4
+	defaultToolResults  = 5
+	maxToolResults      = 10
+	maxLoggedToolResult = 1000 // Characters of a tool result kept in the run info
+)
+
+const agentToolsNote = `
+
+You can call tools to look up reference examples, the project's existing analysis data and threat or
+technique catalogs. Use them when they help you to be specific and consistent with the project; don't
+call them for information you already have. When you have what you need, answer in the format required above.`
+
+// Project data kinds offered by the get_project_data tool.
+const (
+	ProjectAssets          = "assets"
+	ProjectDamageScenarios = "damage_scenarios"
+	ProjectThreatScenarios = "threat_scenarios"
+	ProjectAttackSteps     = "attack_steps"
+)
+
+// ProjectData gives the agent access to the existing assets and scenarios of the project under analysis.
+type ProjectData interface {
+	// Lookup returns the items of a kind (e.g. ProjectAssets) matching the query; an empty query returns all.
+	Lookup(ctx context.Context, kind string, query string) ([]map[string]any, error)
+}
+
+// StaticProjectData is project data supplied with the request, keyed by kind.
+type StaticProjectData map[string][]map[string]any
+
+// Lookup returns the items whose values contain every word of the query (case-insensitive).
+func (d StaticProjectData) Lookup(ctx context.Context, kind string, query string) ([]map[string]any, error) {
+	items, ok := d[kind]
+	if !ok {
+		return nil, nil
+	}
+	words := strings.Fields(strings.ToLower(query))
+	var matches []map[string]any
+	for _, item := range items {
+		b, _ := json.Marshal(item)
+		text := strings.ToLower(string(b))
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, item)
+		}
+	}
+	return matches, nil
+}
+
+type projectDataKey struct{}
+
+// WithProjectData makes the project's data available to agent steps run with the returned context.
+func WithProjectData(ctx context.Context, data ProjectData) context.Context {
+	return context.WithValue(ctx, projectDataKey{}, data)
+}
+
+// projectDataFromContext returns the project data given with WithProjectData or, failing that,
+// the stored data of the project given with WithProject.
+func projectDataFromContext(ctx context.Context) ProjectData {
+	if data, ok := ctx.Value(projectDataKey{}).(ProjectData); ok {
+		return data
+	}
+	if projectID := projectFromContext(ctx); projectID != "" {
+		if store := configuredProjectStore(); store != nil {
+			return storedProjectData{store: store, projectID: projectID}
+		}
+	}
+	return nil
+}
+
+// agentTool is a tool definition together with its implementation.
+type agentTool struct {
+	def llm.Tool
+	run func(ctx context.Context, cfg *config.ModelConfig, args map[string]any) (any, error)
+}
+
+var agentTools = map[string]agentTool{
+	config.ToolSearchReference: {
+		def: llm.Tool{
+			Name:        config.ToolSearchReference,
+			Description: "Semantic search in a reference library of analysed examples. Returns the most similar items with their similarity score.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query":   map[string]any{"type": "string", "description": "Free-text description of what to look for"},
+					"library": map[string]any{"type": "string", "enum": referenceLibraries(), "description": "Analysis whose reference examples to search; defaults to the current analysis"},
+					"limit":   map[string]any{"type": "integer", "description": "Number of results, at most 10"},
+				},
+				"required": []string{"query"},
+			},
+		},
+		run: searchReferenceTool,
+	},
+	config.ToolProjectData: {
+		def: llm.Tool{
+			Name:        config.ToolProjectData,
+			Description: "Fetch the existing assets, damage scenarios, threat scenarios or attack steps of the project under analysis.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"kind":  map[string]any{"type": "string", "enum": []string{ProjectAssets, ProjectDamageScenarios, ProjectThreatScenarios, ProjectAttackSteps}},
+					"query": map[string]any{"type": "string", "description": "Optional words the items must contain"},
+				},
+				"required": []string{"kind"},
+			},
+		},
+		run: projectDataTool,
+	},
+	config.ToolCatalog: {
+		def: llm.Tool{
+			Name:        config.ToolCatalog,
+			Description: "Look up entries of the STRIDE threat catalog, the UN R155 Annex 5 threat groups or the catalog of automotive attack techniques.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"catalog": map[string]any{"type": "string", "enum": catalog.Names(), "description": "Catalog to search; all catalogs if omitted"},
+					"query":   map[string]any{"type": "string", "description": "Words to look for; the whole catalog if omitted"},
+				},
+			},
+		},
+		run: catalogTool,
+	},
+}
+
+// referenceLibraries lists the analyses that have a reference library.
+func referenceLibraries() []string {
+	return []string{config.DamageScenarioAnalysis, config.ImpactScoresAnalysis, config.ThreatScenarioAnalysis, config.AttackStepsAnalysis, config.FeasibilityAnalysis}
+}
+
+// executeAgent answers the base prompt with the analysis' tools available, and logs every tool call in runInfo.
+func executeAgent(ctx context.Context, cfg *config.ModelConfig, systemPrompt, userMessage string, apiKey string, llmModel string, runInfo *similarity.RunInfo) (string, error) {
+	names := cfg.AgentTools
+	if len(names) == 0 {
+		names = []string{config.ToolSearchReference, config.ToolProjectData, config.ToolCatalog}
+	}
+	var tools []llm.Tool
+	for _, name := range names {
+		tool, ok := agentTools[name]
+		if !ok {
+			return "", fmt.Errorf("unknown agent tool %q for %s", name, cfg.AnalysisType)
+		}
+		tools = append(tools, tool.def)
+	}
+	maxSteps := cfg.MaxAgentSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultAgentSteps
+	}
+
+	handle := func(ctx context.Context, call llm.ToolCall) (string, error) {
+		tool, ok := agentTools[call.Name]
+		if !ok {
+			return "", fmt.Errorf("unknown tool %q", call.Name)
+		}
+		args := map[string]any{}
+		if strings.TrimSpace(call.Arguments) != "" {
+			if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+				return "", fmt.Errorf("arguments are not a JSON object: %w", err)
+			}
+		}
+		result, err := tool.run(ctx, cfg, args)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	resp, calls, err := llm.ChatWithTools(ctx, llm.ToolChatRequest{
+		ChatRequest: llm.ChatRequest{SystemPrompt: systemPrompt + agentToolsNote, UserPrompt: userMessage, APIKey: apiKey, Model: llmModel, Temperature: 0.1},
+		Tools:       tools,
+		Handle:      handle,
+		MaxRounds:   maxSteps,
+	})
+	for _, c := range calls {
+		entry := similarity.ToolCallLog{Round: c.Round, Name: c.Name, Arguments: c.Arguments, Result: c.Result}
+		entry.Result = truncateRunes(entry.Result, maxLoggedToolResult)
+		if c.Err != nil {
+			entry.Error = c.Err.Error()
+		}
+		runInfo.ToolCalls = append(runInfo.ToolCalls, entry)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Agent step for %s finished after %d tool calls", cfg.AnalysisType, len(calls))
+	recordAnsweringModel(runInfo, resp)
+	return resp.Content, nil
+}
+
+func searchReferenceTool(ctx context.Context, cfg *config.ModelConfig, args map[string]any) (any, error) {
+	query, _ := args["query"].(string)
+	referencePath := cfg.ReferenceDataJSONFile
+	if library, _ := args["library"].(string); library != "" && library != cfg.AnalysisType {
+		libraryCfg, err := config.GetConfig(library, filepath.Dir(cfg.ReferenceDataJSONFile))
+		if err != nil {
+			return nil, err
+		}
+		referencePath = libraryCfg.ReferenceDataJSONFile
+	}
+	limit := intArg(args, "limit", defaultToolResults)
+
+	results, err := similarity.SearchReferenceText(ctx, referencePath, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]map[string]any, 0, len(results))
+	for _, r := range results {
+		b, _ := json.Marshal(r.Data)
+		var item map[string]any
+		_ = json.Unmarshal(b, &item)
+		delete(item, "embedding")
+		for k, v := range item {
+			if v == "" {
+				delete(item, k)
+			}
+		}
+		item["score"] = r.Score
+		if r.Lexical > 0 {
+			item["lexical_score"] = r.Lexical
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func projectDataTool(ctx context.Context, cfg *config.ModelConfig, args map[string]any) (any, error) {
+	data := projectDataFromContext(ctx)
+	if data == nil {
+		return nil, fmt.Errorf("no project data is available for this analysis")
+	}
+	kind, _ := args["kind"].(string)
+	query, _ := args["query"].(string)
+	items, err := data.Lookup(ctx, kind, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > maxToolResults*2 {
+		items = items[:maxToolResults*2]
+	}
+	return items, nil
+}
+
+func catalogTool(ctx context.Context, cfg *config.ModelConfig, args map[string]any) (any, error) {
+	name, _ := args["catalog"].(string)
+	query, _ := args["query"].(string)
+	return catalog.Search(name, query, 0), nil
+}
+
+// intArg reads an integer argument, clamped to 1..maxToolResults.
+func intArg(args map[string]any, key string, def int) int {
+	n, ok := args[key].(float64)
+	if !ok || n < 1 {
+		return def
+	}
+	if n > maxToolResults {
+		return maxToolResults
+	}
+	return int(n)
+}
@@ -13,13 +13,13 @@ import (
 
 // GenerateAttackTree performs the attack tree generation workflow.
 // Returns the raw ASCII attack tree string or error.
-func GenerateAttackTree(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (string, error) {
+func GenerateAttackTree(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (string, *similarity.RunInfo, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" { return "", fmt.Errorf("OPENAI_API_KEY environment variable not set") }
+	if apiKey == "" { return "", nil, fmt.Errorf("OPENAI_API_KEY environment variable not set") }
 
 	// Check required inputs
 	if inputData.ThreatScenario == "" || inputData.AttackVector == "" {
-		return "", fmt.Errorf("missing required input fields 'ThreatScenario' or 'AttackVector' for attack tree workflow")
+		return "", nil, fmt.Errorf("missing required input fields 'ThreatScenario' or 'AttackVector' for attack tree workflow")
 	}
 
 	analysisType := config.AttackTreeAnalysis
@@ -27,7 +27,7 @@ func GenerateAttackTree(ctx context.Context, inputData similarity.InputData, sys
 
 	// 1. Get Configuration
 	cfg, err := config.GetConfig(analysisType, baseDataPath)
-	if err != nil { return "", fmt.Errorf("workflow error getting config: %w", err) }
+	if err != nil { return "", nil, fmt.Errorf("workflow error getting config: %w", err) }
 
 	// 2. Find Similar Shots (Not needed for attack tree based on prompt analysis)
 	// Attack tree prompt doesn't have {shots} placeholder [cite: 109]
@@ -35,9 +35,9 @@ func GenerateAttackTree(ctx context.Context, inputData similarity.InputData, sys
 
 	// 3. Execute the core workflow steps using the helper
 	llmModel := openai.GPT4o // Or get from config?
-	rawLLMResponse, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
+	rawLLMResponse, runInfo, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
 	if err != nil {
-		return "", fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
+		return "", nil, fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
 	}
 
 	// 4. Perform final parsing specific to this workflow
@@ -46,5 +46,5 @@ func GenerateAttackTree(ctx context.Context, inputData similarity.InputData, sys
 	processedResponse := rawLLMResponse
 
 	log.Printf("Workflow completed for: %s", analysisType)
-	return processedResponse, nil
+	return processedResponse, runInfo, nil
 }
\ No newline at end of file
@@ -15,40 +15,56 @@ import (
 )
 
 // GenerateDamageScenario performs the damage scenario analysis workflow.
-func GenerateDamageScenario(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (string, error) {
+func GenerateDamageScenario(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (string, *similarity.RunInfo, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" { return "", fmt.Errorf("OPENAI_API_KEY environment variable not set") }
+	if apiKey == "" { return "", nil, fmt.Errorf("OPENAI_API_KEY environment variable not set") }
 
 	analysisType := config.DamageScenarioAnalysis
 	log.Printf("Starting workflow for: %s", analysisType)
 
 	// 1. Get Configuration
 	cfg, err := config.GetConfig(analysisType, baseDataPath)
-	if err != nil { return "", fmt.Errorf("workflow error getting config: %w", err) }
+	if err != nil { return "", nil, fmt.Errorf("workflow error getting config: %w", err) }
 
 	// 2. Find Similar Shots
-	shotsResult, err := similarity.FindTopKShotsFile(ctx, inputData, cfg.ReferenceDataJSONFile, 5)
+	shotsResult, err := findShots(ctx, cfg, inputData)
 	if err != nil {
-		log.Printf("Warning: Error finding shots from file %s: %v. Proceeding without shots.", cfg.ReferenceDataJSONFile, err)
-		shotsResult = &similarity.SimilarityResult{Shots: []similarity.ReferenceData{}}
+		return "", nil, fmt.Errorf("workflow error finding shots: %w", err)
 	}
 
 	// 3. Execute the core workflow steps using the helper
 	llmModel := openai.GPT4o // Or get from config?
-	rawLLMResponse, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
+	rawLLMResponse, runInfo, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
 	if err != nil {
-		return "", fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
+		return "", nil, fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
 	}
 
 	// 4. Perform final parsing specific to this workflow
-	// Damage scenario (validation) expects the result after the last '####'
-	processedResponse, err := parseFinalStepResponse(rawLLMResponse, "####")
-    if err != nil {
-        log.Printf("Warning: could not parse final step for %s, returning raw response: %v", analysisType, err)
-        processedResponse = rawLLMResponse // Fallback to raw
-    }
+	// Damage scenario (validation) expects the result after the last '####',
+	// or in the damage_scenario field when the answer was schema-constrained
+	var processedResponse string
+	if runInfo.StructuredOutput {
+		if dict, err := decodeStructured(rawLLMResponse); err == nil {
+			processedResponse, _ = dict[config.DamageScenario].(string)
+		} else {
+			log.Printf("Warning: %v. Falling back to delimiter parsing.", err)
+		}
+	}
+	if processedResponse == "" {
+		processedResponse, err = parseFinalStepResponse(rawLLMResponse, "####")
+		if err != nil {
+			log.Printf("Warning: could not parse final step for %s, returning raw response: %v", analysisType, err)
+			processedResponse = rawLLMResponse // Fallback to raw
+		}
+	}
 
+	// Check the result against the analysis' guardrails, re-prompting on violations
+	checked, err := enforceGuardrails(ctx, cfg, inputData, map[string]any{config.DamageScenario: processedResponse}, rawLLMResponse, runInfo, apiKey, llmModel)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid damage scenario: %w", err)
+	}
+	processedResponse, _ = checked[config.DamageScenario].(string)
 
 	log.Printf("Workflow completed for: %s", analysisType)
-	return processedResponse, nil
+	return processedResponse, runInfo, nil
 }
\ No newline at end of file
@@ -0,0 +1,248 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/parser"
+	"github.com/amir-saatchi/rest-api/corelogic/prompts"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// ErrGuardrailViolation is returned when a result still breaks its guardrails after the retries.
+var ErrGuardrailViolation = errors.New("result violates output guardrails")
+
+const guardrailSystemPrompt = "You are an automotive cybersecurity expert correcting your own analysis so that it follows the required rules."
+
+// enforceGuardrails checks a parsed result against the analysis' guardrails. On a violation the
+// model is re-prompted with the specific problems, up to cfg.GuardrailRetries times.
+// Enum values that only differ in case or spacing are normalised without a retry.
+func enforceGuardrails(ctx context.Context, cfg *config.ModelConfig, inputData similarity.InputData, dict map[string]any, rawLLMResponse string, runInfo *similarity.RunInfo, apiKey string, llmModel string) (map[string]any, error) {
+	violations := checkGuardrails(cfg.Guardrails, dict)
+	if len(violations) == 0 {
+		return dict, nil
+	}
+
+	templateContent, err := prompts.LoadTemplate("guardrail_retry.txt")
+	if err != nil {
+		return nil, err
+	}
+	userMessage, err := formatUserInput(cfg, inputData)
+	if err != nil {
+		return nil, err
+	}
+	keys := resultKeys(cfg)
+	if len(keys) == 0 {
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+
+	answer := rawLLMResponse
+	for attempt := 1; attempt <= cfg.GuardrailRetries; attempt++ {
+		log.Printf("Guardrail violations in %s result (retry %d/%d): %v", cfg.AnalysisType, attempt, cfg.GuardrailRetries, violations)
+		runInfo.GuardrailViolations = append(runInfo.GuardrailViolations, violations...)
+		retryPrompt, err := prompts.FormatInstructionsPrompt(templateContent, map[string]any{
+			"violations":      "- " + strings.Join(violations, "\n- "),
+			"user_message":    userMessage,
+			"original_answer": answer,
+			"expected_keys":   strings.Join(keys, ", "),
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: guardrailSystemPrompt, UserPrompt: retryPrompt, APIKey: apiKey, Model: llmModel, Temperature: 0, Schema: outputSchema(cfg)})
+		if err != nil {
+			return nil, fmt.Errorf("guardrail retry call failed: %w", err)
+		}
+		runInfo.GuardrailRetries++
+		answer = resp.Content
+
+		var corrected map[string]any
+		if resp.Structured {
+			corrected, err = decodeStructured(resp.Content)
+		} else {
+			corrected, err = parser.ParseObject(resp.Content)
+		}
+		if err != nil {
+			violations = []string{fmt.Sprintf("the answer is not a valid JSON object (%v)", err)}
+			continue
+		}
+		violations = checkGuardrails(cfg.Guardrails, corrected)
+		if len(violations) == 0 {
+			return corrected, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrGuardrailViolation, strings.Join(violations, "; "))
+}
+
+// checkGuardrails returns a description of every broken rule. Enum values are normalised in place.
+func checkGuardrails(rules []config.Guardrail, dict map[string]any) []string {
+	var violations []string
+	for _, rule := range rules {
+		value, present := dict[rule.Key]
+		if rule.Rule == config.RuleRequired {
+			if !present || isEmptyValue(value) {
+				violations = append(violations, fmt.Sprintf("%q is missing or empty", rule.Key))
+			}
+			continue
+		}
+		if !present || value == nil {
+			continue // Reported by the required rule, if there is one
+		}
+		if problem := checkRule(rule, dict); problem != "" {
+			if rule.Message != "" {
+				problem += ": " + rule.Message
+			}
+			violations = append(violations, problem)
+		}
+	}
+	return violations
+}
+
+// CheckReferenceShot validates a reference item of the analysis, given by its shot keys. Every
+// shot key other than the ID key must be set, and the enum and range guardrails of the analysis
+// apply to the shot keys; the other guardrails are about the model's wording and don't bind
+// historical data. Enum values are normalised in place and integral strings checked by a range
+// rule become ints.
+func CheckReferenceShot(cfg *config.ModelConfig, shot map[string]any) []string {
+	required, values := referenceShotRules(cfg)
+	violations := checkGuardrails(append(required, values...), shot)
+	for _, rule := range values {
+		if s, ok := shot[rule.Key].(string); ok && rule.Rule == config.RuleRange {
+			if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				shot[rule.Key] = n
+			}
+		}
+	}
+	return violations
+}
+
+// referenceShotRules returns the rules CheckReferenceShot applies: presence of the shot keys, and
+// the value rules of the analysis' guardrails on them.
+func referenceShotRules(cfg *config.ModelConfig) (required, values []config.Guardrail) {
+	for _, key := range cfg.ShotsKeys {
+		if key != cfg.ShotIDKey() {
+			required = append(required, config.Guardrail{Key: key, Rule: config.RuleRequired})
+		}
+	}
+	for _, rule := range cfg.Guardrails {
+		if (rule.Rule == config.RuleEnum || rule.Rule == config.RuleRange) && slices.Contains(cfg.ShotsKeys, rule.Key) {
+			values = append(values, rule)
+		}
+	}
+	return required, values
+}
+
+func checkRule(rule config.Guardrail, dict map[string]any) string {
+	value := dict[rule.Key]
+	switch rule.Rule {
+	case config.RuleEnum:
+		if list, ok := value.([]any); ok {
+			for i, item := range list {
+				canonical, ok := matchEnum(item, rule.Values)
+				if !ok {
+					return fmt.Sprintf("%q contains %v, which is not one of %s", rule.Key, item, formatValues(rule.Values))
+				}
+				list[i] = canonical
+			}
+			return ""
+		}
+		canonical, ok := matchEnum(value, rule.Values)
+		if !ok {
+			return fmt.Sprintf("%q is %v, which is not one of %s", rule.Key, value, formatValues(rule.Values))
+		}
+		dict[rule.Key] = canonical
+	case config.RuleRange:
+		n, ok := toNumber(value)
+		if !ok || n != math.Trunc(n) || n < rule.Min || n > rule.Max {
+			return fmt.Sprintf("%q is %v, which is not an integer from %v to %v", rule.Key, value, rule.Min, rule.Max)
+		}
+	case config.RuleContains, config.RuleNotContains:
+		text := strings.ToLower(fmt.Sprint(value))
+		contains := strings.Contains(text, strings.ToLower(rule.Text))
+		if rule.Rule == config.RuleContains && !contains {
+			return fmt.Sprintf("%q does not contain %q", rule.Key, rule.Text)
+		}
+		if rule.Rule == config.RuleNotContains && contains {
+			return fmt.Sprintf("%q contains %q", rule.Key, rule.Text)
+		}
+	case config.RulePattern:
+		re := rule.Regexp()
+		if re == nil {
+			log.Printf("Warning: Uncompiled guardrail pattern for %q", rule.Key)
+			return ""
+		}
+		text, ok := value.(string)
+		if !ok || !re.MatchString(text) {
+			return fmt.Sprintf("%q has the wrong format", rule.Key)
+		}
+	default:
+		log.Printf("Warning: Unknown guardrail rule %q for %q", rule.Rule, rule.Key)
+	}
+	return ""
+}
+
+// matchEnum finds the allowed value equal to v, ignoring case and spacing of strings
+// and the difference between numbers and numeric strings.
+func matchEnum(v any, allowed []any) (any, bool) {
+	if n, ok := toNumber(v); ok {
+		for _, a := range allowed {
+			if an, ok := toNumber(a); ok && an == n {
+				return a, true
+			}
+		}
+	}
+	if s, ok := v.(string); ok {
+		norm := strings.ToLower(strings.Join(strings.Fields(s), " "))
+		for _, a := range allowed {
+			if as, ok := a.(string); ok && strings.ToLower(as) == norm {
+				return a, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func isEmptyValue(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(t) == ""
+	case []any:
+		return len(t) == 0
+	}
+	return false
+}
+
+func formatValues(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%q", fmt.Sprint(v))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
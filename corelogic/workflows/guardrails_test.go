@@ -0,0 +1,105 @@
+package workflows
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+)
+
+func guardrailsOf(t *testing.T, analysisType string) []config.Guardrail {
+	t.Helper()
+	cfg, err := config.AnalysisConfig(analysisType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Guardrails
+}
+
+func TestCheckGuardrails(t *testing.T) {
+	tests := []struct {
+		name          string
+		analysisType  string
+		dict          map[string]any
+		wantViolation []string // Substrings of the expected violations, in order
+	}{
+		{
+			name:         "single attack step",
+			analysisType: config.AttackStepsAnalysis,
+			dict:         map[string]any{"vulnerability": "Unauthenticated CAN bus", config.AttackSteps: "1. Send a spoofed CAN frame"},
+		},
+		{
+			name:         "several attack steps",
+			analysisType: config.AttackStepsAnalysis,
+			dict:         map[string]any{"vulnerability": "Open debug port", config.AttackSteps: "1. Connect to the JTAG port 2. Dump the firmware"},
+		},
+		{
+			name:          "attack steps not enumerated",
+			analysisType:  config.AttackStepsAnalysis,
+			dict:          map[string]any{"vulnerability": "Open debug port", config.AttackSteps: "Connect to the JTAG port and dump the firmware"},
+			wantViolation: []string{`"attack_steps" has the wrong format: attack steps must be enumerated`},
+		},
+		{
+			name:          "missing fields",
+			analysisType:  config.AttackStepsAnalysis,
+			dict:          map[string]any{config.AttackSteps: ""},
+			wantViolation: []string{`"vulnerability" is missing or empty`, `"attack_steps" is missing or empty`, `"attack_steps" has the wrong format`},
+		},
+		{
+			name:          "damage scenario without cause",
+			analysisType:  config.DamageScenarioAnalysis,
+			dict:          map[string]any{config.DamageScenario: "The vehicle brakes unexpectedly"},
+			wantViolation: []string{`does not contain "CAUSED BY"`},
+		},
+		{
+			name:          "threat scenario wording and vectors",
+			analysisType:  config.ThreatScenarioAnalysis,
+			dict:          map[string]any{config.ThreatScenario: "Spoofing of CAN frames due to missing authentication", "attack_vectors": []any{"remote", "Bluetooth"}},
+			wantViolation: []string{`contains "due to"`, `contains Bluetooth, which is not one of`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := checkGuardrails(guardrailsOf(t, tt.analysisType), tt.dict)
+			if len(violations) != len(tt.wantViolation) {
+				t.Fatalf("violations = %q, want %d", violations, len(tt.wantViolation))
+			}
+			for i, want := range tt.wantViolation {
+				if !strings.Contains(violations[i], want) {
+					t.Errorf("violation %d = %q, want it to contain %q", i, violations[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckGuardrailsNormalisesValues(t *testing.T) {
+	dict := map[string]any{
+		config.ThreatScenario: "Spoofing of CAN frames",
+		"attack_vectors":      []any{"remote", " physical "},
+	}
+	if violations := checkGuardrails(guardrailsOf(t, config.ThreatScenarioAnalysis), dict); len(violations) > 0 {
+		t.Fatalf("violations = %q, want none", violations)
+	}
+	vectors := dict["attack_vectors"].([]any)
+	if vectors[0] != "Remote" || vectors[1] != "Physical" {
+		t.Errorf("attack vectors = %q, want the canonical spelling", vectors)
+	}
+}
+
+func TestCheckGuardrailsImpactRange(t *testing.T) {
+	rules := guardrailsOf(t, config.ImpactScoresAnalysis)
+	dict := map[string]any{
+		config.SafetyImpact:         4.0,
+		config.FinancialImpact:      "2",
+		config.OperationalImpact:    5.0,
+		config.PrivacyImpact:        2.5,
+		config.OEMFinancialImpact:   1.0,
+		config.OEMOperationalImpact: 1.0,
+		config.OEMIPImpact:          1.0,
+	}
+	violations := checkGuardrails(rules, dict)
+	if len(violations) != 2 || !strings.Contains(violations[0], config.OperationalImpact) || !strings.Contains(violations[1], config.PrivacyImpact) {
+		t.Errorf("violations = %q, want the out-of-range operational and fractional privacy impact", violations)
+	}
+}
@@ -14,41 +14,44 @@ import (
 )
 
 // GenerateImpactScores performs the impact score analysis workflow.
-func GenerateImpactScores(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (similarity.DictResult, error) {
+func GenerateImpactScores(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (similarity.DictResult, *similarity.RunInfo, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" { return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set") }
+	if apiKey == "" { return nil, nil, fmt.Errorf("OPENAI_API_KEY environment variable not set") }
 
 	analysisType := config.ImpactScoresAnalysis
 	log.Printf("Starting workflow for: %s", analysisType)
 
 	// 1. Get Configuration
 	cfg, err := config.GetConfig(analysisType, baseDataPath)
-	if err != nil { return nil, fmt.Errorf("workflow error getting config: %w", err) }
+	if err != nil { return nil, nil, fmt.Errorf("workflow error getting config: %w", err) }
 
 	// 2. Find Similar Shots
-	shotsResult, err := similarity.FindTopKShotsFile(ctx, inputData, cfg.ReferenceDataJSONFile, 5)
+	shotsResult, err := findShots(ctx, cfg, inputData)
 	if err != nil {
-		log.Printf("Warning: Error finding shots for %s: %v. Proceeding without shots.", analysisType, err)
-		shotsResult = &similarity.SimilarityResult{Shots: []similarity.ReferenceData{}}
+		return nil, nil, fmt.Errorf("workflow error finding shots: %w", err)
 	}
 
 	// 3. Execute the core workflow steps using the helper
 	llmModel := openai.GPT4o // Or get from config?
-	rawLLMResponse, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
+	rawLLMResponse, runInfo, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
 	if err != nil {
-		return nil, fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
+		return nil, nil, fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
 	}
 
 	// 4. Perform final parsing specific to this workflow
 	// Impact scores prompt asks for a dictionary in the last step [cite: 42]
-	impactScores, err := parseDictResponse(rawLLMResponse, "####")
+	impactScores, err := parseWorkflowDict(ctx, cfg, rawLLMResponse, runInfo, apiKey, llmModel, "####")
 	if err != nil {
 		log.Printf("Failed to parse Impact Scores response dictionary: %v", err)
-		return nil, fmt.Errorf("could not parse dictionary from LLM for impact scores")
+		return nil, nil, fmt.Errorf("could not parse dictionary from LLM for impact scores: %w", err)
 	}
 
-	// Optional: Validate/convert score types here if needed before returning map[string]interface{}
+	// Check the result against the analysis' guardrails, re-prompting on violations
+	impactScores, err = enforceGuardrails(ctx, cfg, inputData, impactScores, rawLLMResponse, runInfo, apiKey, llmModel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid impact scores: %w", err)
+	}
 
 	log.Printf("Workflow completed for: %s", analysisType)
-	return impactScores, nil
+	return impactScores, runInfo, nil
 }
\ No newline at end of file
@@ -0,0 +1,82 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/parser"
+	"github.com/amir-saatchi/rest-api/corelogic/prompts"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// ErrJudgeRejected is returned when the judge scores a result below the threshold of an analysis
+// configured with config.JudgeActionReject. The result and its scores are still returned.
+var ErrJudgeRejected = errors.New("result rejected by quality judge")
+
+const judgeSystemPrompt = "You are a strict, fair reviewer of automotive cybersecurity analyses according to ISO 21434. You score results against a rubric."
+
+// judgeResult scores a workflow result against the rubric of its analysis.
+func judgeResult(ctx context.Context, cfg *config.ModelConfig, inputData similarity.InputData, systemInfo map[string]string, result any, apiKey string, llmModel string) (*similarity.JudgeResult, error) {
+	templateContent, err := prompts.LoadTemplate("judge.txt")
+	if err != nil {
+		return nil, err
+	}
+	userMessage, err := formatUserInput(cfg, inputData)
+	if err != nil {
+		return nil, err
+	}
+	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result for judging: %w", err)
+	}
+	var rubric strings.Builder
+	for _, c := range cfg.Rubric {
+		rubric.WriteString(fmt.Sprintf("- %s: %s\n", c.Name, c.Description))
+	}
+
+	judgePrompt, err := prompts.FormatInstructionsPrompt(templateContent, map[string]any{
+		"analysis_type": cfg.AnalysisType,
+		"system_type":   systemInfo[config.SystemType],
+		"system_desc":   systemInfo[config.SystemDesc],
+		"user_message":  userMessage,
+		"result":        string(resultJSON),
+		"rubric":        strings.TrimSpace(rubric.String()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	schema := &llm.JSONSchema{Name: "judge", Schema: config.JudgeSchema(cfg.Rubric), Strict: true}
+	resp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: judgeSystemPrompt, UserPrompt: judgePrompt, APIKey: apiKey, Model: llmModel, Temperature: 0, Schema: schema})
+	if err != nil {
+		return nil, fmt.Errorf("judge call failed: %w", err)
+	}
+
+	answer, err := parser.ParseObject(resp.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse judge answer: %w", err)
+	}
+	rawScores, _ := answer["scores"].(map[string]any)
+	verdict := &similarity.JudgeResult{
+		Scores:    make(map[string]float64, len(cfg.Rubric)),
+		Threshold: cfg.JudgeThreshold,
+		Model:     resp.Model,
+	}
+	verdict.Rationale, _ = answer["rationale"].(string)
+	var total float64
+	for _, c := range cfg.Rubric {
+		score, ok := rawScores[c.Name].(float64)
+		if !ok || score < config.JudgeScoreMin || score > config.JudgeScoreMax {
+			return nil, fmt.Errorf("judge answer has no valid score for %q: %v", c.Name, rawScores[c.Name])
+		}
+		verdict.Scores[c.Name] = score
+		total += score
+	}
+	verdict.Mean = total / float64(len(cfg.Rubric))
+	verdict.Passed = verdict.Mean >= cfg.JudgeThreshold
+	return verdict, nil
+}
@@ -0,0 +1,68 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestJudgeModelOf(t *testing.T) {
+	if got := judgeModelOf(&config.ModelConfig{}); got != openai.GPT4o {
+		t.Errorf("judge model without configuration = %q, want %q", got, openai.GPT4o)
+	}
+	if got := judgeModelOf(&config.ModelConfig{JudgeModel: "local:judge"}); got != "local:judge" {
+		t.Errorf("configured judge model = %q, want %q", got, "local:judge")
+	}
+}
+
+func TestJudgeResultUsesConfiguredModel(t *testing.T) {
+	declared, err := config.AnalysisConfig(config.DamageScenarioAnalysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *declared
+	cfg.JudgeModel = "local:judge-test"
+
+	scores := make(map[string]float64, len(cfg.Rubric))
+	for _, c := range cfg.Rubric {
+		scores[c.Name] = 4
+	}
+	scores[cfg.Rubric[0].Name] = 2
+	answer, _ := json.Marshal(map[string]any{"scores": scores, "rationale": "Too generic"})
+
+	var gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gotModel = req.Model
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: string(answer)}}},
+		})
+	}))
+	defer server.Close()
+	t.Setenv("LOCAL_LLM_BASE_URL", server.URL+"/v1")
+
+	input := similarity.InputData{Asset: "Brake ECU", AssetDescription: "Controls the hydraulic brakes"}
+	result := map[string]any{config.DamageScenario: "The vehicle brakes unexpectedly CAUSED BY spoofed CAN frames"}
+	verdict, err := judgeResult(context.Background(), &cfg, input, nil, result, "", judgeModelOf(&cfg))
+	if err != nil {
+		t.Fatalf("judgeResult: %v", err)
+	}
+	if gotModel != "judge-test" || verdict.Model != "judge-test" {
+		t.Errorf("judged by %q (recorded %q), want the configured judge-test", gotModel, verdict.Model)
+	}
+	wantMean := (4*float64(len(cfg.Rubric)) - 2) / float64(len(cfg.Rubric))
+	if verdict.Mean != wantMean || verdict.Passed != (wantMean >= cfg.JudgeThreshold) {
+		t.Errorf("verdict = %+v, want mean %.2f", verdict, wantMean)
+	}
+}
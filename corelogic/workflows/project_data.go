@@ -0,0 +1,129 @@
+package workflows
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// ProjectStore holds the assets and analysis results of the tenant's projects, e.g. in the tenant
+// database. Implementations take the tenant from the context (llm.CallerFromContext).
+type ProjectStore interface {
+	// Lookup returns up to limit items of a kind of the project whose values contain every word
+	// of the query (case-insensitive), most recently recorded first; an empty query matches all.
+	Lookup(ctx context.Context, projectID, kind, query string, limit int) ([]map[string]any, error)
+	// Record stores an item of a kind for the project, replacing the item with the same key.
+	Record(ctx context.Context, projectID, kind, key string, item map[string]any) error
+}
+
+var (
+	projectStoreMu sync.RWMutex
+	projectStore   ProjectStore
+)
+
+// ConfigureProjectStore sets the store behind WithProject.
+func ConfigureProjectStore(store ProjectStore) {
+	projectStoreMu.Lock()
+	defer projectStoreMu.Unlock()
+	projectStore = store
+}
+
+func configuredProjectStore() ProjectStore {
+	projectStoreMu.RLock()
+	defer projectStoreMu.RUnlock()
+	return projectStore
+}
+
+type projectKey struct{}
+
+// WithProject runs analyses with the returned context for a project: the agent's project data
+// tool reads the project's items from the configured ProjectStore, and RunAnalysis records the
+// analysed asset and the result there. Project data given with WithProjectData takes precedence
+// for lookups.
+func WithProject(ctx context.Context, projectID string) context.Context {
+	return context.WithValue(ctx, projectKey{}, projectID)
+}
+
+func projectFromContext(ctx context.Context) string {
+	projectID, _ := ctx.Value(projectKey{}).(string)
+	return projectID
+}
+
+// storedProjectData is the data of one project in a ProjectStore.
+type storedProjectData struct {
+	store     ProjectStore
+	projectID string
+}
+
+func (d storedProjectData) Lookup(ctx context.Context, kind string, query string) ([]map[string]any, error) {
+	return d.store.Lookup(ctx, d.projectID, kind, query, maxToolResults*2)
+}
+
+// projectResults maps analyses to the kind of project item their results are, and to the item
+// field that holds a result which is a bare string.
+var projectResults = map[string]struct{ kind, field string }{
+	config.DamageScenarioAnalysis: {ProjectDamageScenarios, config.DamageScenario},
+	config.ThreatScenarioAnalysis: {ProjectThreatScenarios, config.ThreatScenario},
+	config.AttackStepsAnalysis:    {ProjectAttackSteps, config.AttackSteps},
+}
+
+// recordProjectResult stores the analysed asset and the result in the store of the project in
+// ctx, if there is one. Failures are logged: the result is still good for the caller.
+func recordProjectResult(ctx context.Context, analysisType string, inputData similarity.InputData, result any) {
+	projectID := projectFromContext(ctx)
+	store := configuredProjectStore()
+	if projectID == "" || store == nil {
+		return
+	}
+
+	if inputData.Asset != "" {
+		asset := map[string]any{config.Asset: inputData.Asset}
+		if inputData.Category != "" {
+			asset[config.Category] = inputData.Category
+		}
+		if inputData.AssetDescription != "" {
+			asset[config.AssetDescription] = inputData.AssetDescription
+		}
+		if err := store.Record(ctx, projectID, ProjectAssets, strings.ToLower(inputData.Asset), asset); err != nil {
+			log.Printf("Warning: could not record asset %q of project %s: %v", inputData.Asset, projectID, err)
+		}
+	}
+
+	target, ok := projectResults[analysisType]
+	if !ok {
+		return
+	}
+	if s, isString := result.(string); isString {
+		result = map[string]any{target.field: s}
+	}
+	item := map[string]any{}
+	for _, v := range []any{inputData, result} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("Warning: could not record %s result of project %s: %v", analysisType, projectID, err)
+			return
+		}
+		var fields map[string]any
+		if json.Unmarshal(b, &fields) != nil {
+			continue // Not an object
+		}
+		for k, v := range fields {
+			if v != nil && v != "" {
+				item[k] = v
+			}
+		}
+	}
+	// Map keys are marshalled sorted, so the same result always gets the same key
+	b, _ := json.Marshal(item)
+	sum := sha256.Sum256(b)
+	if err := store.Record(ctx, projectID, target.kind, hex.EncodeToString(sum[:16]), item); err != nil {
+		log.Printf("Warning: could not record %s result of project %s: %v", analysisType, projectID, err)
+	}
+}
@@ -0,0 +1,86 @@
+package workflows
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// stepMarkerRegex matches the "*** Step N:####" markers of the templates and of answers
+// (also "STEP N:####", "Step N:####:" and missing asterisks).
+var stepMarkerRegex = regexp.MustCompile(`(?mi)^[ \t]*(?:\*{1,3}[ \t]*)?step[ \t]+(\d+)[ \t]*:?[ \t]*####:?`)
+
+// maxInstructionLength bounds the step instruction taken from a template.
+const maxInstructionLength = 200
+
+// extractRationale splits an answer into its numbered reasoning steps. Schema-constrained answers
+// carry them in the "steps" array, delimiter answers in "*** Step N:####" sections. Each step is
+// labelled with the instruction of the same step in templateContent, when found there.
+func extractRationale(content string, templateContent string) []similarity.RationaleStep {
+	var steps []similarity.RationaleStep
+	var structured map[string]any
+	if err := json.Unmarshal([]byte(content), &structured); err == nil {
+		list, _ := structured[config.ReasoningSteps].([]any)
+		for i, s := range list {
+			if text, ok := s.(string); ok && strings.TrimSpace(text) != "" {
+				steps = append(steps, similarity.RationaleStep{Step: i + 1, Reasoning: strings.TrimSpace(text)})
+			}
+		}
+	} else {
+		for _, sec := range splitSteps(content) {
+			if sec.text != "" {
+				steps = append(steps, similarity.RationaleStep{Step: sec.number, Reasoning: sec.text})
+			}
+		}
+	}
+
+	if len(steps) > 0 && templateContent != "" {
+		instructions := stepInstructions(templateContent)
+		for i := range steps {
+			steps[i].Instruction = instructions[steps[i].Step]
+		}
+	}
+	return steps
+}
+
+type stepSection struct {
+	number int
+	text   string
+}
+
+// splitSteps returns the text of every "Step N:####" section, in order.
+func splitSteps(text string) []stepSection {
+	matches := stepMarkerRegex.FindAllStringSubmatchIndex(text, -1)
+	sections := make([]stepSection, 0, len(matches))
+	for i, m := range matches {
+		number, err := strconv.Atoi(text[m[2]:m[3]])
+		if err != nil {
+			continue
+		}
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		sections = append(sections, stepSection{number: number, text: strings.TrimSpace(text[m[1]:end])})
+	}
+	return sections
+}
+
+// stepInstructions maps step numbers to the first paragraph of their instruction in a template.
+// The "Use the following format" placeholders come later in the templates and are skipped.
+func stepInstructions(templateContent string) map[int]string {
+	instructions := make(map[int]string)
+	for _, sec := range splitSteps(templateContent) {
+		if _, seen := instructions[sec.number]; seen || strings.HasPrefix(sec.text, "<") {
+			continue
+		}
+		paragraph, _, _ := strings.Cut(sec.text, "\n\n")
+		paragraph = strings.Join(strings.Fields(strings.ReplaceAll(paragraph, `\n`, " ")), " ")
+		instructions[sec.number] = truncateRunes(paragraph, maxInstructionLength)
+	}
+	return instructions
+}
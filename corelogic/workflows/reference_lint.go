@@ -0,0 +1,146 @@
+package workflows
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// Kinds of problems found in reference libraries.
+const (
+	IssueUnreadable       = "unreadable"        // The file is missing or can't be parsed
+	IssueEmbeddingModel   = "embedding_model"   // The file was embedded with another model than queries are
+	IssueMissingEmbedding = "missing_embedding" // The item has no embedding
+	IssueDimension        = "dimension"         // The embedding has the wrong number of dimensions
+	IssueZeroVector       = "zero_vector"       // The embedding is all zeros
+	IssueDuplicateID      = "duplicate_id"      // The ID is empty or used by an earlier item
+	IssueMissingField     = "missing_field"     // A shot key is missing or empty
+	IssueInvalidValue     = "invalid_value"     // A value breaks a range or enum guardrail, e.g. an impact score of 7
+)
+
+// ReferenceIssue is one problem of a reference library.
+type ReferenceIssue struct {
+	Row     int    `json:"row,omitempty"` // 1-based position of the item in the file; 0 for the file itself
+	ItemID  string `json:"item_id,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// ReferenceReport is the result of checking the reference library of one analysis.
+type ReferenceReport struct {
+	AnalysisType string           `json:"analysis_type"`
+	Path         string           `json:"path"`
+	Items        int              `json:"items"`
+	Issues       []ReferenceIssue `json:"issues,omitempty"`
+}
+
+// LintReferenceLibraries checks the reference library of every configured analysis in
+// baseDataPath. Reports are in the order of config.AnalysisTypes.
+func LintReferenceLibraries(baseDataPath string) []ReferenceReport {
+	var reports []ReferenceReport
+	for _, analysisType := range config.AnalysisTypes() {
+		cfg, err := config.GetConfig(analysisType, baseDataPath)
+		if err != nil {
+			declared, _ := config.AnalysisConfig(analysisType)
+			reports = append(reports, ReferenceReport{
+				AnalysisType: analysisType,
+				Path:         filepath.Join(baseDataPath, declared.ReferenceDataJSONFile),
+				Issues:       []ReferenceIssue{{Kind: IssueUnreadable, Message: err.Error()}},
+			})
+			continue
+		}
+		reports = append(reports, LintReferenceFile(cfg, cfg.ReferenceDataJSONFile))
+	}
+	return reports
+}
+
+// LintReferenceFile checks a reference file against an analysis: it must be embedded like the
+// queries, and every item needs an embedding of the recorded dimensions, a unique ID and the
+// analysis' shot keys, with values that pass its range and enum guardrails.
+func LintReferenceFile(cfg *config.ModelConfig, path string) ReferenceReport {
+	report := ReferenceReport{AnalysisType: cfg.AnalysisType, Path: path}
+	file, err := similarity.LoadReferenceFile(path)
+	if err != nil {
+		report.Issues = append(report.Issues, ReferenceIssue{Kind: IssueUnreadable, Message: err.Error()})
+		return report
+	}
+	report.Items = len(file.Items)
+	spec := file.Metadata.Embedding()
+	if configured := similarity.ConfiguredEmbedding(); spec != configured {
+		report.Issues = append(report.Issues, ReferenceIssue{Kind: IssueEmbeddingModel, Message: fmt.Sprintf("embedded with %s, queries are embedded with %s", spec, configured)})
+	}
+
+	required, values := referenceShotRules(cfg)
+	dim := spec.Dimensions
+	seen := map[string]int{}
+	for i, item := range file.Items {
+		add := func(kind, format string, args ...any) {
+			report.Issues = append(report.Issues, ReferenceIssue{Row: i + 1, ItemID: item.ID, Kind: kind, Message: fmt.Sprintf(format, args...)})
+		}
+
+		switch {
+		case len(item.Embedding) == 0:
+			add(IssueMissingEmbedding, "no embedding")
+		case len(item.Embedding) != dim:
+			add(IssueDimension, "embedding has %d dimensions, expected %d", len(item.Embedding), dim)
+		case isZeroVector(item.Embedding):
+			add(IssueZeroVector, "embedding is all zeros")
+		}
+
+		if item.ID == "" {
+			add(IssueDuplicateID, "empty id")
+		} else if first, ok := seen[item.ID]; ok {
+			add(IssueDuplicateID, "id %q is also used by row %d", item.ID, first)
+		} else {
+			seen[item.ID] = i + 1
+		}
+
+		shot := similarity.ShotValues(item)
+		for _, v := range checkGuardrails(required, shot) {
+			add(IssueMissingField, "%s", v)
+		}
+		for _, v := range checkGuardrails(values, shot) {
+			add(IssueInvalidValue, "%s", v)
+		}
+	}
+	return report
+}
+
+func (i ReferenceIssue) String() string {
+	if i.Row == 0 {
+		return fmt.Sprintf("%s: %s", i.Kind, i.Message)
+	}
+	return fmt.Sprintf("row %d (id %q): %s: %s", i.Row, i.ItemID, i.Kind, i.Message)
+}
+
+// Summary returns a one-line description of the report, e.g. for logs.
+func (r ReferenceReport) Summary() string {
+	if len(r.Issues) == 0 {
+		return fmt.Sprintf("%s: %s: %d items, no issues", r.AnalysisType, r.Path, r.Items)
+	}
+	counts := map[string]int{}
+	var kinds []string
+	for _, issue := range r.Issues {
+		if counts[issue.Kind] == 0 {
+			kinds = append(kinds, issue.Kind)
+		}
+		counts[issue.Kind]++
+	}
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", counts[k], k)
+	}
+	return fmt.Sprintf("%s: %s: %d items, %d issues (%s)", r.AnalysisType, r.Path, r.Items, len(r.Issues), strings.Join(parts, ", "))
+}
+
+func isZeroVector(vec []float32) bool {
+	for _, v := range vec {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
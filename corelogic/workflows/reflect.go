@@ -0,0 +1,106 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/prompts"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// defaultReflectRounds is used when a StepReflect analysis doesn't set ReflectRounds.
+const defaultReflectRounds = 1
+
+// noIssues is the critique's answer for a draft that needs no revision.
+const noIssues = "NO ISSUES"
+
+const critiqueSystemPrompt = "You are a strict reviewer of automotive cybersecurity analyses according to ISO 21434. You point out problems, you never rewrite the answer."
+
+// executeReflect drafts an answer with the base prompt, then runs critique-and-revise rounds until
+// the critique finds no issues or the rounds are used up. Every critique is recorded in runInfo.
+func executeReflect(ctx context.Context, cfg *config.ModelConfig, systemInfo map[string]string, systemPrompt, userMessage string, apiKey string, llmModel string, runInfo *similarity.RunInfo) (string, error) {
+	critiqueTemplate, err := prompts.LoadTemplate("reflect_critique.txt")
+	if err != nil {
+		return "", err
+	}
+	reviseTemplate, err := prompts.LoadTemplate("reflect_revise.txt")
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: systemPrompt, UserPrompt: userMessage, APIKey: apiKey, Model: llmModel, Temperature: 0.1, Schema: outputSchema(cfg)})
+	if err != nil {
+		return "", fmt.Errorf("draft call failed: %w", err)
+	}
+	recordAnsweringModel(runInfo, resp)
+	draft := resp.Content
+
+	rounds := cfg.ReflectRounds
+	if rounds <= 0 {
+		rounds = defaultReflectRounds
+	}
+	for round := 1; round <= rounds; round++ {
+		log.Printf("Reflection round %d/%d: critique", round, rounds)
+		critiquePrompt, err := prompts.FormatInstructionsPrompt(critiqueTemplate, map[string]any{
+			"analysis_type": cfg.AnalysisType,
+			"system_type":   systemInfo[config.SystemType],
+			"system_desc":   systemInfo[config.SystemDesc],
+			"user_message":  userMessage,
+			"wording_rules": formatWordingRules(cfg.WordingRules),
+			"draft":         draft,
+		})
+		if err != nil {
+			return "", err
+		}
+		critiqueResp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: critiqueSystemPrompt, UserPrompt: critiquePrompt, APIKey: apiKey, Model: llmModel, Temperature: 0.1})
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			// The draft is still a valid answer; keep it rather than failing the run
+			log.Printf("Warning: Critique call failed in round %d, keeping the current draft: %v", round, err)
+			break
+		}
+		critique := strings.TrimSpace(critiqueResp.Content)
+		if strings.HasPrefix(strings.ToUpper(critique), noIssues) {
+			runInfo.CritiqueTrace = append(runInfo.CritiqueTrace, similarity.CritiqueRound{Round: round, Critique: noIssues})
+			log.Printf("Reflection round %d: no issues found", round)
+			break
+		}
+
+		log.Printf("Reflection round %d/%d: revise", round, rounds)
+		revisePrompt, err := prompts.FormatInstructionsPrompt(reviseTemplate, map[string]any{
+			"user_message": userMessage,
+			"draft":        draft,
+			"critique":     critique,
+		})
+		if err != nil {
+			return "", err
+		}
+		revised, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: systemPrompt, UserPrompt: revisePrompt, APIKey: apiKey, Model: llmModel, Temperature: 0.1, Schema: outputSchema(cfg)})
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			log.Printf("Warning: Revision call failed in round %d, keeping the current draft: %v", round, err)
+			runInfo.CritiqueTrace = append(runInfo.CritiqueTrace, similarity.CritiqueRound{Round: round, Critique: critique})
+			break
+		}
+		runInfo.CritiqueTrace = append(runInfo.CritiqueTrace, similarity.CritiqueRound{Round: round, Critique: critique, Revised: true})
+		recordAnsweringModel(runInfo, revised)
+		draft = revised.Content
+	}
+	return draft, nil
+}
+
+// formatWordingRules renders the rules as a bulleted list for the critique prompt.
+func formatWordingRules(rules []string) string {
+	if len(rules) == 0 {
+		return "- No specific wording rules; follow the format required by the analysis."
+	}
+	return "- " + strings.Join(rules, "\n- ")
+}
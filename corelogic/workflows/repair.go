@@ -0,0 +1,75 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/parser"
+	"github.com/amir-saatchi/rest-api/corelogic/prompts"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// maxRepairAttempts bounds the repair round-trips for an unparsable answer.
+const maxRepairAttempts = 2
+
+const repairSystemPrompt = "You fix the syntax of malformed model outputs. You never change their content."
+
+// resultKeys lists the result fields of an analysis, taken from its output schema.
+func resultKeys(cfg *config.ModelConfig) []string {
+	props, _ := cfg.OutputSchema["properties"].(map[string]any)
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		if k != config.ReasoningSteps {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// repairDict sends an unparsable answer back to the model together with the parse error and
+// asks for a corrected dictionary. It returns the first repair that parses.
+func repairDict(ctx context.Context, cfg *config.ModelConfig, rawLLMResponse string, parseErr error, runInfo *similarity.RunInfo, apiKey string, llmModel string) (map[string]any, error) {
+	templateContent, err := prompts.LoadTemplate("output_repair.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := parseErr
+	for attempt := 1; attempt <= maxRepairAttempts; attempt++ {
+		log.Printf("Repairing unparsable %s answer (attempt %d/%d): %v", cfg.AnalysisType, attempt, maxRepairAttempts, lastErr)
+		repairPrompt, err := prompts.FormatInstructionsPrompt(templateContent, map[string]any{
+			"parse_error":     lastErr.Error(),
+			"expected_keys":   strings.Join(resultKeys(cfg), ", "),
+			"original_answer": rawLLMResponse,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: repairSystemPrompt, UserPrompt: repairPrompt, APIKey: apiKey, Model: llmModel, Temperature: 0, Schema: outputSchema(cfg)})
+		if err != nil {
+			return nil, fmt.Errorf("repair call failed: %w", err)
+		}
+		runInfo.Repairs++
+
+		var dict map[string]any
+		if resp.Structured {
+			dict, err = decodeStructured(resp.Content)
+		} else {
+			dict, err = parser.ParseObject(resp.Content)
+		}
+		if err == nil {
+			delete(dict, config.ReasoningSteps)
+			log.Printf("Repair attempt %d produced a parsable answer", attempt)
+			return dict, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("answer still unparsable after %d repair attempts: %w", maxRepairAttempts, lastErr)
+}
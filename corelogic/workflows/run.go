@@ -0,0 +1,141 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrInvalidStep is returned when a request opts in to an LLM step its analysis doesn't offer.
+var ErrInvalidStep = errors.New("invalid LLM step")
+
+type stepKey struct{}
+
+// WithStep makes analyses run with the returned context use step instead of their configured
+// LLM step, if they offer it (see config.ModelConfig.OptionalSteps).
+func WithStep(ctx context.Context, step string) context.Context {
+	return context.WithValue(ctx, stepKey{}, step)
+}
+
+// llmStep returns the LLM step to run the analysis with: the one requested in ctx, or the configured one.
+func llmStep(ctx context.Context, cfg *config.ModelConfig) (string, error) {
+	step, _ := ctx.Value(stepKey{}).(string)
+	if step == "" || step == cfg.LLMStep {
+		return cfg.LLMStep, nil
+	}
+	if !slices.Contains(cfg.OptionalSteps, step) {
+		return "", fmt.Errorf("%w: %s runs with %s or one of %v, not %s", ErrInvalidStep, cfg.AnalysisType, cfg.LLMStep, cfg.OptionalSteps, step)
+	}
+	return step, nil
+}
+
+// RunAnalysis runs the workflow for the given analysis type and returns its result.
+// It is the single entry point used by the HTTP API. Analyses with a rubric are scored by the
+// judge afterwards; depending on the configured JudgeAction a low-scoring result is flagged,
+// rejected with ErrJudgeRejected, or regenerated. Results of analyses run for a project (see
+// WithProject) are recorded in the project's data.
+func RunAnalysis(ctx context.Context, analysisType string, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (any, *similarity.RunInfo, error) {
+	result, runInfo, err := runAnalysis(ctx, analysisType, inputData, systemInfo, baseDataPath)
+	if err == nil {
+		recordProjectResult(ctx, analysisType, inputData, result)
+	}
+	return result, runInfo, err
+}
+
+func runAnalysis(ctx context.Context, analysisType string, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (any, *similarity.RunInfo, error) {
+	cfg, err := config.GetConfig(analysisType, baseDataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Refuse an unavailable step before any LLM call
+	if _, err := llmStep(ctx, cfg); err != nil {
+		return nil, nil, err
+	}
+	if len(cfg.Rubric) == 0 {
+		return generate(ctx, analysisType, inputData, systemInfo, baseDataPath)
+	}
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	judgeModel := judgeModelOf(cfg)
+
+	regenerations := 0
+	if cfg.JudgeAction == config.JudgeActionRegenerate {
+		regenerations = cfg.MaxRegenerations
+	}
+	var bestResult any
+	var bestRunInfo *similarity.RunInfo
+	for attempt := 0; attempt <= regenerations; attempt++ {
+		runCtx := ctx
+		if attempt > 0 {
+			// A regeneration must not be answered from the cache with the rejected result
+			runCtx = llm.WithCacheBypass(ctx)
+		}
+		result, runInfo, err := generate(runCtx, analysisType, inputData, systemInfo, baseDataPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		verdict, err := judgeResult(ctx, cfg, inputData, systemInfo, result, apiKey, judgeModel)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			// An unavailable judge shouldn't cost the user a valid result
+			log.Printf("Warning: Could not judge %s result: %v", analysisType, err)
+			return result, runInfo, nil
+		}
+		verdict.Regenerations = attempt
+		runInfo.Judge = verdict
+		log.Printf("Judge scored %s result %.2f (threshold %.2f): %v", analysisType, verdict.Mean, verdict.Threshold, verdict.Scores)
+		if verdict.Passed {
+			return result, runInfo, nil
+		}
+		if cfg.JudgeAction == config.JudgeActionReject {
+			return result, runInfo, fmt.Errorf("%w: mean score %.2f below %.2f", ErrJudgeRejected, verdict.Mean, verdict.Threshold)
+		}
+		if bestRunInfo == nil || verdict.Mean > bestRunInfo.Judge.Mean {
+			bestResult, bestRunInfo = result, runInfo
+		}
+		if attempt < regenerations {
+			log.Printf("Regenerating %s result (%d/%d) after a low judge score", analysisType, attempt+1, regenerations)
+		}
+	}
+	// Nothing passed: return the best-scoring result, flagged by Judge.Passed = false
+	return bestResult, bestRunInfo, nil
+}
+
+// judgeModelOf returns the model that judges results of cfg. llm.Chat falls back from it like
+// from the workflow's own model when it is unavailable.
+func judgeModelOf(cfg *config.ModelConfig) string {
+	if cfg.JudgeModel != "" {
+		return cfg.JudgeModel
+	}
+	return openai.GPT4o
+}
+
+// generate dispatches to the workflow for the given analysis type.
+func generate(ctx context.Context, analysisType string, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (any, *similarity.RunInfo, error) {
+	switch analysisType {
+	case config.DamageScenarioAnalysis:
+		return GenerateDamageScenario(ctx, inputData, systemInfo, baseDataPath)
+	case config.ImpactScoresAnalysis:
+		return GenerateImpactScores(ctx, inputData, systemInfo, baseDataPath)
+	case config.ThreatScenarioAnalysis:
+		return GenerateThreatScenario(ctx, inputData, systemInfo, baseDataPath)
+	case config.AttackStepsAnalysis:
+		return GenerateAttackSteps(ctx, inputData, systemInfo, baseDataPath)
+	case config.FeasibilityAnalysis:
+		return GenerateFeasibility(ctx, inputData, systemInfo, baseDataPath)
+	case config.AttackTreeAnalysis:
+		return GenerateAttackTree(ctx, inputData, systemInfo, baseDataPath)
+	default:
+		return nil, nil, fmt.Errorf("unknown analysis type: %s", analysisType)
+	}
+}
@@ -0,0 +1,84 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// structuredOutputNote replaces the delimiter instructions of the templates when the model is schema-constrained.
+const structuredOutputNote = `
+
+IMPORTANT: Instead of the "*** Step N:####" format of the instructions, answer with a single JSON object matching the provided schema.
+Put the reasoning of every step, in order, as separate strings in the "steps" array and the final result in the remaining fields.`
+
+// outputSchema returns the JSON schema request for the analysis, or nil if it has none. The llm
+// layer adds the JSON answer instructions for each model it tries that is schema-constrained.
+func outputSchema(cfg *config.ModelConfig) *llm.JSONSchema {
+	if cfg.OutputSchema == nil {
+		return nil
+	}
+	return &llm.JSONSchema{Name: cfg.AnalysisType, Schema: cfg.OutputSchema, Strict: true, Note: structuredOutputNote}
+}
+
+// decodeStructured parses a schema-constrained answer and strips the reasoning steps from the result.
+func decodeStructured(content string) (map[string]any, error) {
+	var dict map[string]any
+	if err := json.Unmarshal([]byte(content), &dict); err != nil {
+		return nil, fmt.Errorf("structured output is not valid JSON: %w", err)
+	}
+	delete(dict, config.ReasoningSteps)
+	return dict, nil
+}
+
+// structuredTrialResult renders a schema-constrained trial answer as expert input for consolidation:
+// the bare value when the result has a single field, otherwise the JSON of the result fields.
+func structuredTrialResult(content string) (string, error) {
+	dict, err := decodeStructured(content)
+	if err != nil {
+		return "", err
+	}
+	if len(dict) == 1 {
+		for _, v := range dict {
+			if s, ok := v.(string); ok {
+				return strings.TrimSpace(s), nil
+			}
+		}
+	}
+	b, err := json.Marshal(dict)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// parseWorkflowDict returns the result dictionary of a run. Schema-constrained answers are decoded
+// directly; everything else goes through the delimiter-based parseDictResponse, trying each
+// delimiter in turn. If nothing parses, the answer is sent back to the model for repair.
+func parseWorkflowDict(ctx context.Context, cfg *config.ModelConfig, rawLLMResponse string, runInfo *similarity.RunInfo, apiKey string, llmModel string, delimiters ...string) (map[string]any, error) {
+	if runInfo.StructuredOutput {
+		dict, err := decodeStructured(rawLLMResponse)
+		if err == nil {
+			return dict, nil
+		}
+		log.Printf("Warning: %v. Falling back to delimiter parsing.", err)
+	}
+
+	var parseErr error
+	for _, delimiter := range delimiters {
+		dict, err := parseDictResponse(rawLLMResponse, delimiter)
+		if err == nil {
+			return dict, nil
+		}
+		if parseErr == nil {
+			parseErr = err
+		}
+	}
+	return repairDict(ctx, cfg, rawLLMResponse, parseErr, runInfo, apiKey, llmModel)
+}
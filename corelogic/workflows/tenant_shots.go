@@ -0,0 +1,68 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// ErrInvalidShots is returned when shots given to UpsertTenantShots break the analysis' rules.
+var ErrInvalidShots = errors.New("invalid reference shots")
+
+// maxShotsPerUpsert bounds the shots, and so the embedding requests, of one UpsertTenantShots call.
+const maxShotsPerUpsert = 500
+
+// UpsertTenantShots checks shots like reference data (see CheckReferenceShot), then embeds and
+// stores them in the calling tenant's library of the analysis. Every shot needs an ID unique in
+// the call; a stored shot with the same ID is replaced. Nothing is stored if any shot is invalid.
+func UpsertTenantShots(ctx context.Context, analysisType string, shots []similarity.ReferenceData, baseDataPath string) error {
+	cfg, err := config.GetConfig(analysisType, baseDataPath)
+	if err != nil {
+		return err
+	}
+	if len(shots) == 0 || len(shots) > maxShotsPerUpsert {
+		return fmt.Errorf("%w: expected 1 to %d shots, got %d", ErrInvalidShots, maxShotsPerUpsert, len(shots))
+	}
+
+	var problems []string
+	seen := map[string]bool{}
+	for i := range shots {
+		id := shots[i].ID
+		switch {
+		case id == "":
+			problems = append(problems, fmt.Sprintf("shot %d: missing id", i+1))
+			continue
+		case seen[id]:
+			problems = append(problems, fmt.Sprintf("shot %q: duplicate id", id))
+			continue
+		}
+		seen[id] = true
+
+		values := similarity.ShotValues(shots[i])
+		if violations := CheckReferenceShot(cfg, values); len(violations) > 0 {
+			problems = append(problems, fmt.Sprintf("shot %q: %s", id, strings.Join(violations, "; ")))
+			continue
+		}
+		// Keep the normalised values
+		b, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		var shot similarity.ReferenceData
+		if err := json.Unmarshal(b, &shot); err != nil {
+			problems = append(problems, fmt.Sprintf("shot %q: %v", id, err))
+			continue
+		}
+		shot.ID = id
+		shots[i] = shot
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidShots, strings.Join(problems, "; "))
+	}
+	return similarity.UpsertTenantShots(ctx, analysisType, cfg.ReferenceDataJSONFile, cfg.EmbedKeys, shots)
+}
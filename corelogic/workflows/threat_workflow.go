@@ -12,37 +12,42 @@ import (
 )
 
 // GenerateThreatScenario performs the threat scenario analysis workflow.
-func GenerateThreatScenario(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (*similarity.ThreatScenarioResult, error) {
+func GenerateThreatScenario(ctx context.Context, inputData similarity.InputData, systemInfo map[string]string, baseDataPath string) (*similarity.ThreatScenarioResult, *similarity.RunInfo, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" { return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set") }
+	if apiKey == "" { return nil, nil, fmt.Errorf("OPENAI_API_KEY environment variable not set") }
 
 	analysisType := config.ThreatScenarioAnalysis
 	log.Printf("Starting workflow for: %s", analysisType)
 
 	// 1. Get Configuration
 	cfg, err := config.GetConfig(analysisType, baseDataPath)
-	if err != nil { return nil, fmt.Errorf("workflow error getting config: %w", err) }
+	if err != nil { return nil, nil, fmt.Errorf("workflow error getting config: %w", err) }
 
 	// 2. Find Similar Shots
-	shotsResult, err := similarity.FindTopKShotsFile(ctx, inputData, cfg.ReferenceDataJSONFile, 5)
+	shotsResult, err := findShots(ctx, cfg, inputData)
 	if err != nil {
-		log.Printf("Warning: Error finding shots for %s: %v. Proceeding without shots.", analysisType, err)
-		shotsResult = &similarity.SimilarityResult{Shots: []similarity.ReferenceData{}}
+		return nil, nil, fmt.Errorf("workflow error finding shots: %w", err)
 	}
 
 	// 3. Execute the core workflow steps using the helper
 	llmModel := openai.GPT4o // Or get from config?
-	rawLLMResponse, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
+	rawLLMResponse, runInfo, err := executeWorkflow(ctx, cfg, inputData, systemInfo, shotsResult, apiKey, llmModel)
 	if err != nil {
-		return nil, fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
+		return nil, nil, fmt.Errorf("core workflow execution failed for %s: %w", analysisType, err)
 	}
 
 	// 4. Perform final parsing specific to this workflow
 	// Threat scenario prompt asks for a dictionary in the last step [cite: 66]
-	parsedDict, err := parseDictResponse(rawLLMResponse, "####")
+	parsedDict, err := parseWorkflowDict(ctx, cfg, rawLLMResponse, runInfo, apiKey, llmModel, "####")
 	if err != nil {
 		log.Printf("Failed to parse Threat Scenario response dictionary: %v", err)
-		return nil, fmt.Errorf("could not parse dictionary from LLM for threat scenario")
+		return nil, nil, fmt.Errorf("could not parse dictionary from LLM for threat scenario: %w", err)
+	}
+
+	// Check the result against the analysis' guardrails, re-prompting on violations
+	parsedDict, err = enforceGuardrails(ctx, cfg, inputData, parsedDict, rawLLMResponse, runInfo, apiKey, llmModel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid threat scenario: %w", err)
 	}
 
 	// Convert parsed map to struct
@@ -68,9 +73,9 @@ func GenerateThreatScenario(ctx context.Context, inputData similarity.InputData,
 
 	if !parseOk {
 		// If neither key was found or valid, return a more specific error or the raw response perhaps
-		return nil, fmt.Errorf("failed to extract valid 'threat_scenario' or 'attack_vectors' from LLM response dictionary")
+		return nil, nil, fmt.Errorf("failed to extract valid 'threat_scenario' or 'attack_vectors' from LLM response dictionary")
 	}
 
 	log.Printf("Workflow completed for: %s", analysisType)
-	return result, nil
+	return result, runInfo, nil
 }
\ No newline at end of file
@@ -0,0 +1,85 @@
+package workflows
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+)
+
+// trialExtractor turns one StepValidate trial answer into the expert input shown to consolidation.
+type trialExtractor func(cfg *config.ModelConfig, resp *llm.ChatResponse) (string, error)
+
+var trialExtractors = map[string]trialExtractor{
+	config.TrialExtractFinalStep: extractFinalStep,
+	config.TrialExtractDict:      extractDict,
+}
+
+// lookupTrialExtractor returns the configured extractor; unset means TrialExtractFinalStep.
+func lookupTrialExtractor(cfg *config.ModelConfig) (trialExtractor, error) {
+	name := cfg.TrialExtractor
+	if name == "" {
+		name = config.TrialExtractFinalStep
+	}
+	extract, ok := trialExtractors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown trial extractor %q for %s", name, cfg.AnalysisType)
+	}
+	return extract, nil
+}
+
+// extractFinalStep returns the text after the last '####', or the bare result of a structured answer.
+func extractFinalStep(cfg *config.ModelConfig, resp *llm.ChatResponse) (string, error) {
+	if resp.Structured {
+		return structuredTrialResult(resp.Content)
+	}
+	result, err := parseFinalStepResponse(resp.Content, "####")
+	if err != nil {
+		return "", fmt.Errorf("failed to parse result using '####': %w", err)
+	}
+	return result, nil
+}
+
+// extractDict parses the result dictionary of the trial and renders it one "key: value" line per
+// field, so consolidation compares the experts field by field instead of as raw text.
+func extractDict(cfg *config.ModelConfig, resp *llm.ChatResponse) (string, error) {
+	var dict map[string]any
+	var err error
+	if resp.Structured {
+		dict, err = decodeStructured(resp.Content)
+	} else {
+		dict, err = parseDictResponse(resp.Content, "####")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	keys := resultKeys(cfg)
+	if len(keys) == 0 {
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	var lines []string
+	for _, k := range keys {
+		if v, ok := dict[k]; ok && v != nil {
+			lines = append(lines, fmt.Sprintf("%s: %v", k, v))
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("trial result has none of the keys %v", keys)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// formatExpertResponses numbers the expert inputs, indenting multi-line inputs under their number.
+func formatExpertResponses(expertResponses []string) string {
+	var sb strings.Builder
+	for i, resp := range expertResponses {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, strings.ReplaceAll(resp, "\n", "\n   ")))
+	}
+	return strings.TrimSpace(sb.String())
+}
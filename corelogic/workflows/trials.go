@@ -0,0 +1,87 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"golang.org/x/sync/errgroup"
+)
+
+// Defaults for sampled trials when the config leaves them unset.
+const (
+	defaultValidateTrials   = 3
+	defaultTrialConcurrency = 3
+)
+
+// errAllTrialsFailed is returned by runTrials when no trial succeeded.
+var errAllTrialsFailed = errors.New("all trials failed")
+
+// runTrials calls trial for i = 0..n-1 with at most concurrency calls in flight and returns the
+// results in trial order. A failed trial leaves its slot nil and is otherwise tolerated, unless no
+// trial succeeds: then errAllTrialsFailed is returned, wrapping the error of the first trial.
+// A cancelled ctx, an exhausted quota or an open circuit breaker would fail every other trial too,
+// so they stop launching new trials, cancel the running ones and are returned as the error.
+func runTrials[T any](ctx context.Context, n, concurrency int, trial func(ctx context.Context, i int) (T, error)) ([]*T, error) {
+	if concurrency <= 0 {
+		concurrency = defaultTrialConcurrency
+	}
+	results := make([]*T, n)
+	errs := make([]error, n)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i := 0; i < n; i++ {
+		if gctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			if gctx.Err() != nil {
+				return nil // Stopped while waiting for a slot
+			}
+			res, err := trial(gctx, i)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err() // Cancelled by the caller: stop the remaining trials
+				}
+				if gctx.Err() != nil {
+					return nil // Cancelled by another trial's error, which is returned
+				}
+				err = fmt.Errorf("trial %d/%d: %w", i+1, n, err)
+				if stopsTrials(err) {
+					return err
+				}
+				log.Printf("Warning: %v", err)
+				errs[i] = err
+				return nil
+			}
+			results[i] = &res // Each goroutine owns its slots, so no lock is needed
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, res := range results {
+		if res != nil {
+			return results, nil
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errAllTrialsFailed, err)
+		}
+	}
+	return nil, errAllTrialsFailed
+}
+
+// stopsTrials reports whether err would fail the remaining trials too.
+func stopsTrials(err error) bool {
+	var quotaErr *llm.QuotaError
+	return errors.As(err, &quotaErr) || errors.Is(err, llm.ErrCircuitOpen)
+}
@@ -0,0 +1,123 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+)
+
+func TestRunTrialsKeepsOrderAndToleratesFailures(t *testing.T) {
+	const n, concurrency = 6, 3
+	var inFlight, maxInFlight atomic.Int32
+	results, err := runTrials(context.Background(), n, concurrency, func(ctx context.Context, i int) (int, error) {
+		cur := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := maxInFlight.Load()
+			if cur <= old || maxInFlight.CompareAndSwap(old, cur) {
+				break
+			}
+		}
+		// Later trials finish first
+		time.Sleep(time.Duration(n-i) * time.Millisecond)
+		if i%2 == 1 {
+			return 0, fmt.Errorf("bad sample %d", i)
+		}
+		return i * 10, nil
+	})
+	if err != nil {
+		t.Fatalf("runTrials: %v", err)
+	}
+	if len(results) != n {
+		t.Fatalf("got %d results, want %d", len(results), n)
+	}
+	for i, res := range results {
+		switch {
+		case i%2 == 1 && res != nil:
+			t.Errorf("result %d = %d, want nil for a failed trial", i, *res)
+		case i%2 == 0 && (res == nil || *res != i*10):
+			t.Errorf("result %d = %v, want %d", i, res, i*10)
+		}
+	}
+	if got := maxInFlight.Load(); got > concurrency {
+		t.Errorf("%d trials in flight, want at most %d", got, concurrency)
+	}
+}
+
+func TestRunTrialsAllFailed(t *testing.T) {
+	errFirst := errors.New("unparseable sample")
+	_, err := runTrials(context.Background(), 3, 3, func(ctx context.Context, i int) (int, error) {
+		if i == 0 {
+			time.Sleep(5 * time.Millisecond) // The first trial's error is returned even if it fails last
+			return 0, errFirst
+		}
+		return 0, fmt.Errorf("failure %d", i)
+	})
+	if !errors.Is(err, errAllTrialsFailed) || !errors.Is(err, errFirst) {
+		t.Fatalf("runTrials error = %v, want errAllTrialsFailed wrapping the first trial's error", err)
+	}
+	if !strings.Contains(err.Error(), "trial 1/3") {
+		t.Errorf("runTrials error = %q, want it to name trial 1/3", err)
+	}
+}
+
+func TestRunTrialsStopsOnQuotaAndBreakerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"quota", fmt.Errorf("chat failed: %w", &llm.QuotaError{Limit: "requests_per_minute"})},
+		{"circuit open", fmt.Errorf("chat failed: %w", llm.ErrCircuitOpen)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, cancelled atomic.Int32
+			_, err := runTrials(context.Background(), 6, 2, func(ctx context.Context, i int) (int, error) {
+				calls.Add(1)
+				if i == 0 {
+					return 0, tt.err
+				}
+				// A trial in flight is cancelled instead of running to completion
+				select {
+				case <-ctx.Done():
+					cancelled.Add(1)
+					return 0, ctx.Err()
+				case <-time.After(5 * time.Second):
+					return i, nil
+				}
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("runTrials error = %v, want %v", err, tt.err)
+			}
+			if errors.Is(err, errAllTrialsFailed) {
+				t.Errorf("runTrials error = %v, want the stopping error itself", err)
+			}
+			if got := calls.Load(); got > 2 {
+				t.Errorf("%d trials started, want no new trials after the stopping error", got)
+			}
+			if calls.Load() == 2 && cancelled.Load() != 1 {
+				t.Errorf("the trial in flight was not cancelled")
+			}
+		})
+	}
+}
+
+func TestRunTrialsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := runTrials(ctx, 3, 3, func(ctx context.Context, i int) (int, error) {
+		if i == 0 {
+			cancel()
+		}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("runTrials error = %v, want context.Canceled", err)
+	}
+}
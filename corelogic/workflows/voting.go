@@ -0,0 +1,229 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+)
+
+// Defaults for StepSelfConsistency when the config leaves them unset.
+const (
+	defaultVoteSamples     = 5
+	defaultVoteTemperature = 0.7
+	defaultVoteAgreement   = 0.6
+)
+
+// executeSelfConsistency samples the base prompt several times, parses every sample and votes per field.
+// It returns the voted result as a JSON object and records agreement and review flags in runInfo.
+func executeSelfConsistency(ctx context.Context, cfg *config.ModelConfig, systemPrompt, userMessage string, apiKey string, llmModel string, runInfo *similarity.RunInfo) (string, error) {
+	numSamples := cfg.Samples
+	if numSamples <= 0 {
+		numSamples = defaultVoteSamples
+	}
+	temperature := cfg.SampleTemperature
+	if temperature <= 0 {
+		temperature = defaultVoteTemperature
+	}
+	threshold := cfg.AgreementThreshold
+	if threshold <= 0 {
+		threshold = defaultVoteAgreement
+	}
+
+	log.Printf("Drawing %d self-consistency samples", numSamples)
+	type sample struct {
+		dict map[string]any
+		resp *llm.ChatResponse
+	}
+	results, err := runTrials(ctx, numSamples, cfg.TrialConcurrency, func(ctx context.Context, i int) (sample, error) {
+		resp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: systemPrompt, UserPrompt: userMessage, APIKey: apiKey, Model: llmModel, Temperature: temperature, Schema: outputSchema(cfg)})
+		if err != nil {
+			return sample{}, err
+		}
+		dict, err := parseSample(resp)
+		if err != nil {
+			return sample{}, fmt.Errorf("failed to parse sample: %w", err)
+		}
+		return sample{dict: dict, resp: resp}, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("self-consistency sampling failed: %w", err)
+	}
+	var samples []map[string]any
+	var sampleContents []string
+	var lastResp *llm.ChatResponse
+	for _, res := range results {
+		if res != nil {
+			samples = append(samples, res.dict)
+			sampleContents = append(sampleContents, res.resp.Content)
+			lastResp = res.resp
+		}
+	}
+	voted, agreement := voteFields(samples)
+	recordAnsweringModel(runInfo, lastResp)
+	// The reasoning of the sample closest to the vote stands in for the voted result
+	closest := 0
+	for i, sample := range samples {
+		if matchingFields(sample, voted) > matchingFields(samples[closest], voted) {
+			closest = i
+		}
+	}
+	runInfo.Rationale = extractRationale(sampleContents[closest], systemPrompt)
+	runInfo.StructuredOutput = true // The voted result is already a JSON object
+	runInfo.Samples = len(samples)
+	runInfo.FieldAgreement = agreement
+	for field, score := range agreement {
+		if score < threshold {
+			runInfo.NeedsReview = append(runInfo.NeedsReview, field)
+		}
+	}
+	sort.Strings(runInfo.NeedsReview)
+	if len(runInfo.NeedsReview) > 0 {
+		log.Printf("Low agreement (< %.2f) on fields %v; flagged for human review", threshold, runInfo.NeedsReview)
+	}
+
+	votedJSON, err := json.Marshal(voted)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode voted result: %w", err)
+	}
+	return string(votedJSON), nil
+}
+
+// matchingFields counts the fields of sample that agree with the voted result.
+func matchingFields(sample, voted map[string]any) int {
+	n := 0
+	for field, v := range voted {
+		if s, ok := sample[field]; ok && normaliseVote(s) == normaliseVote(v) {
+			n++
+		}
+	}
+	return n
+}
+
+// parseSample reads one sampled answer, whichever output mode produced it.
+func parseSample(resp *llm.ChatResponse) (map[string]any, error) {
+	if resp.Structured {
+		return decodeStructured(resp.Content)
+	}
+	var firstErr error
+	for _, delimiter := range []string{"!!!!", "####"} {
+		dict, err := parseDictResponse(resp.Content, delimiter)
+		if err == nil {
+			return dict, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// voteFields combines samples field by field: numbers by median, everything else by majority.
+// The agreement of a field is the share of samples (out of all samples) that match the chosen value.
+func voteFields(samples []map[string]any) (map[string]any, map[string]float64) {
+	fields := map[string]bool{}
+	for _, s := range samples {
+		for k := range s {
+			fields[k] = true
+		}
+	}
+
+	voted := make(map[string]any, len(fields))
+	agreement := make(map[string]float64, len(fields))
+	for field := range fields {
+		var values []any
+		for _, s := range samples {
+			if v, ok := s[field]; ok && v != nil {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		value, matches := voteValue(values)
+		voted[field] = value
+		agreement[field] = float64(matches) / float64(len(samples))
+	}
+	return voted, agreement
+}
+
+// voteValue picks the consensus value and counts how many values agree with it.
+func voteValue(values []any) (any, int) {
+	if nums, ok := asNumbers(values); ok {
+		sorted := append([]float64(nil), nums...)
+		sort.Float64s(sorted)
+		median := sorted[len(sorted)/2]
+		if len(sorted)%2 == 0 {
+			// Scores are ordinal: round the midpoint of the middle pair up to the more conservative score
+			median = math.Ceil((sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2)
+		}
+		matches := 0
+		for _, n := range nums {
+			if n == median {
+				matches++
+			}
+		}
+		// Keep the first sample's spelling: schema-constrained answers give numbers, but dictionaries
+		// parsed from free text may quote them ("3")
+		if _, isString := values[0].(string); isString {
+			return strconv.FormatFloat(median, 'f', -1, 64), matches
+		}
+		return median, matches
+	}
+
+	// Majority vote on a normalised representation, returning the first original spelling
+	counts := map[string]int{}
+	firstSeen := map[string]any{}
+	var order []string
+	for _, v := range values {
+		key := normaliseVote(v)
+		if _, seen := firstSeen[key]; !seen {
+			firstSeen[key] = v
+			order = append(order, key)
+		}
+		counts[key]++
+	}
+	best := order[0]
+	for _, key := range order[1:] {
+		if counts[key] > counts[best] {
+			best = key
+		}
+	}
+	return firstSeen[best], counts[best]
+}
+
+// asNumbers converts values to floats if every value is numeric (including numeric strings like "3").
+func asNumbers(values []any) ([]float64, bool) {
+	nums := make([]float64, 0, len(values))
+	for _, v := range values {
+		switch n := v.(type) {
+		case float64:
+			nums = append(nums, n)
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, false
+			}
+			nums = append(nums, f)
+		default:
+			return nil, false
+		}
+	}
+	return nums, true
+}
+
+func normaliseVote(v any) string {
+	if s, ok := v.(string); ok {
+		return strings.ToLower(strings.Join(strings.Fields(s), " "))
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
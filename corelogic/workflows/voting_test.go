@@ -0,0 +1,67 @@
+package workflows
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVoteValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      []any
+		want        any
+		wantMatches int
+	}{
+		{"median", []any{1.0, 3.0, 2.0}, 2.0, 1},
+		{"median with agreement", []any{4.0, 2.0, 2.0, 1.0, 2.0}, 2.0, 3},
+		{"even count rounds up", []any{1.0, 2.0}, 2.0, 1},
+		{"even count tie", []any{2.0, 3.0, 3.0, 2.0}, 3.0, 2},
+		{"even count between", []any{1.0, 4.0}, 3.0, 0},
+		{"numeric strings", []any{"3", "3", "4"}, "3", 2},
+		{"padded numeric strings", []any{" 2", "2 ", "4"}, "2", 2},
+		{"mixed, string first", []any{"3", 4.0, 3.0}, "3", 2},
+		{"mixed, number first", []any{4.0, "4", "2"}, 4.0, 2},
+		{"majority", []any{"High", "Low", "high "}, "High", 2},
+		{"majority ignores spacing", []any{"Very  low", "very low", "Low"}, "Very  low", 2},
+		{"tie keeps the first seen", []any{"Low", "High"}, "Low", 1},
+		{"two-way tie", []any{"a", "b", "b", "a"}, "a", 2},
+		{"not all numbers", []any{"3", "many", "many"}, "many", 2},
+		{"booleans", []any{true, false, true}, true, 2},
+		{"lists", []any{[]any{"a", "b"}, []any{"a"}, []any{"a", "b"}}, []any{"a", "b"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matches := voteValue(tt.values)
+			if !reflect.DeepEqual(got, tt.want) || matches != tt.wantMatches {
+				t.Errorf("voteValue(%#v) = %#v, %d; want %#v, %d", tt.values, got, matches, tt.want, tt.wantMatches)
+			}
+		})
+	}
+}
+
+func TestVoteFields(t *testing.T) {
+	samples := []map[string]any{
+		{"safety_impact": 3.0, "financial_impact": "2", "threat": "Spoofing"},
+		{"safety_impact": 3.0, "financial_impact": 2.0, "threat": "spoofing"},
+		{"safety_impact": 4.0, "financial_impact": nil},
+		{"safety_impact": 3.0, "financial_impact": "1", "threat": "Tampering"},
+	}
+	voted, agreement := voteFields(samples)
+
+	wantVoted := map[string]any{"safety_impact": 3.0, "financial_impact": "2", "threat": "Spoofing"}
+	if !reflect.DeepEqual(voted, wantVoted) {
+		t.Errorf("voted = %#v, want %#v", voted, wantVoted)
+	}
+	// Agreement is out of all samples, counting the ones that left a field out as disagreeing
+	wantAgreement := map[string]float64{"safety_impact": 0.75, "financial_impact": 0.5, "threat": 0.5}
+	if !reflect.DeepEqual(agreement, wantAgreement) {
+		t.Errorf("agreement = %v, want %v", agreement, wantAgreement)
+	}
+}
+
+func TestVoteFieldsNoSamples(t *testing.T) {
+	voted, agreement := voteFields(nil)
+	if len(voted) != 0 || len(agreement) != 0 {
+		t.Errorf("voteFields(nil) = %v, %v; want empty", voted, agreement)
+	}
+}
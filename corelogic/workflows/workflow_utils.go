@@ -3,19 +3,94 @@ package workflows
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/amir-saatchi/rest-api/corelogic/config"
 	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/parser"
 	"github.com/amir-saatchi/rest-api/corelogic/prompts"
 	"github.com/amir-saatchi/rest-api/corelogic/similarity"
 )
 
-// executeWorkflow handles the common steps of context prep, LLM calls (Base/Validate),
-// and returns the RAW final response from the LLM for specific parsing by the caller.
+// Consolidation defaults for validate-type analyses that don't configure their own.
+const defaultConsolidationSystemPrompt = "You are an automotive cybersecurity expert tasked with consolidating expert answers and providing the single most correct one formatted as instructed."
+
+// ensembleConsolidationNote tells consolidation that the experts are different models.
+const ensembleConsolidationNote = "The expert answers come from different AI models with different blind spots: judge each on its merits, not by how many experts agree, and combine the correct parts where they complement each other."
+
+var defaultConsolidationContextKeys = []string{"system_type", "asset", "category", "property", "asset_description", "threat", "threat_scenario", "attack_vector", config.ExpertsRes}
+
+// Shot selection defaults for analyses that don't configure their own.
+const (
+	defaultMaxShots         = 5
+	defaultShotsDiversity   = 0.3
+	defaultShotsTokenBudget = 2000
+)
+
+// shotOptions returns how shots are picked for the analysis.
+func shotOptions(cfg *config.ModelConfig) similarity.ShotOptions {
+	opts := similarity.ShotOptions{
+		MaxShots:    cfg.MaxShots,
+		MinScore:    cfg.ShotsMinScore,
+		Diversity:   cfg.ShotsDiversity,
+		TokenBudget: cfg.ShotsTokenBudget,
+		BudgetKeys:  cfg.ShotsKeys,
+
+		AnalysisType: cfg.AnalysisType,
+	}
+	if opts.MaxShots <= 0 {
+		opts.MaxShots = defaultMaxShots
+	}
+	if opts.Diversity == 0 {
+		opts.Diversity = defaultShotsDiversity
+	} else if opts.Diversity < 0 {
+		opts.Diversity = 0
+	}
+	if opts.TokenBudget <= 0 {
+		opts.TokenBudget = defaultShotsTokenBudget
+	}
+	return opts
+}
+
+// findShots picks the shots for the input from the analysis' reference file. A failure is logged and
+// the analysis proceeds without shots, unless its LLM calls would fail as well: an exhausted quota,
+// an open circuit breaker or a cancelled request is returned.
+func findShots(ctx context.Context, cfg *config.ModelConfig, inputData similarity.InputData) (*similarity.SimilarityResult, error) {
+	shotsResult, err := similarity.FindShotsFile(ctx, inputData, cfg.ReferenceDataJSONFile, cfg.EmbedKeys, shotOptions(cfg))
+	if err == nil {
+		return shotsResult, nil
+	}
+	if ctx.Err() != nil || stopsTrials(err) {
+		return nil, err
+	}
+	log.Printf("Warning: Error finding shots for %s: %v. Proceeding without shots.", cfg.AnalysisType, err)
+	return &similarity.SimilarityResult{Shots: []similarity.ReferenceData{}}, nil
+}
+
+// truncateRunes shortens s to at most n characters, marking a cut with "...". It never splits a
+// multi-byte character, and drops the spaces before the mark.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for pos := range s {
+		if i == n {
+			return strings.TrimRightFunc(s[:pos], unicode.IsSpace) + "..."
+		}
+		i++
+	}
+	return s
+}
+
+// executeWorkflow handles the common steps of context prep, LLM calls (Base/Validate/Reflect/Agent/SelfConsistency),
+// and returns the RAW final response from the LLM for specific parsing by the caller,
+// together with the RunInfo describing which model produced it.
 func executeWorkflow(
 	ctx context.Context,
 	cfg *config.ModelConfig, // Configuration for the current analysis
@@ -24,23 +99,37 @@ func executeWorkflow(
 	shotsResult *similarity.SimilarityResult, // Results from similarity search
 	apiKey string, // OpenAI API Key
 	llmModel string, // Model ID (e.g., gpt-4o)
-) (string, error) { // Returns the raw final LLM response string
+) (string, *similarity.RunInfo, error) { // Returns the raw final LLM response string
+	runInfo := &similarity.RunInfo{AnalysisType: cfg.AnalysisType, RequestedModel: llmModel}
+	step, err := llmStep(ctx, cfg)
+	if err != nil {
+		return "", nil, err
+	}
 
 	// --- 1. Prepare Shots Context ---
 	var shotsForPrompt []map[string]any
 	if shotsResult != nil {
-		for _, shot := range shotsResult.Shots {
+		for i, shot := range shotsResult.Shots {
 			shotBytes, _ := json.Marshal(shot)
 			var shotMap map[string]any
 			_ = json.Unmarshal(shotBytes, &shotMap)
 			filteredShotMap := make(map[string]any)
+			var missing []string
 			for _, key := range cfg.ShotsKeys {
-				if val, ok := shotMap[key]; ok && val != nil {
+				if val, ok := shotMap[key]; ok && val != nil && val != "" {
 					filteredShotMap[key] = val
+				} else if key != cfg.ShotIDKey() {
+					missing = append(missing, key)
 				}
 			}
+			if len(missing) > 0 {
+				log.Printf("Warning: shot %s lacks %v; run \"reftool lint\" on %s", shot.ID, missing, cfg.ReferenceDataJSONFile)
+			}
 			if len(filteredShotMap) > 0 {
 				shotsForPrompt = append(shotsForPrompt, filteredShotMap)
+				if i < len(shotsResult.Scores) {
+					runInfo.Shots = append(runInfo.Shots, shotsResult.Scores[i])
+				}
 			}
 		}
 	}
@@ -67,57 +156,43 @@ func executeWorkflow(
 
 
 	// --- 3. Format Base User Message ---
-	userInputMap := make(map[string]any)
-	// Include only the keys relevant as direct input trigger, mirroring Python's approach more closely
-	// This might vary slightly per prompt, but often includes asset/desc/property etc.
-	// Let's use the keys specified in cfg.DataKeys as a basis for the user input context map.
-	inputDataMapBytes, _ := json.Marshal(inputData)
-	var inputDataMap map[string]any
-	_ = json.Unmarshal(inputDataMapBytes, &inputDataMap)
-
-	for _, key := range cfg.DataKeys { // Use DataKeys from config
-		if val, ok := inputDataMap[key]; ok && val != "" {
-			userInputMap[key] = val
-		}
-	}
-	// Special handling for attack_vector definition if needed (as in Python api.py _create_user_msg)
-	// This might need to be handled *before* this helper or passed in differently if critical
-	// For now, we assume the base userInputMap is sufficient for FormatUserMessage
-
-	userMessageContent, err := prompts.FormatUserMessage(userInputMap)
+	userMessageContent, err := formatUserInput(cfg, inputData)
 	if err != nil {
-		return "", fmt.Errorf("failed to format base user message: %w", err)
+		return "", nil, err
 	}
 
 	// --- 4. Execute LLM Step (Base or Validate) ---
 	var finalRawResponse string
+	var finalPrompt string // Prompt holding the step instructions of the final answer, for the rationale
 
-	if cfg.LLMStep == config.StepBase {
+	if step == config.StepBase {
 		// --- BASE ---
 		if len(cfg.PromptFiles) < 1 {
-			return "", fmt.Errorf("base step requires at least 1 prompt file in config")
+			return "", nil, fmt.Errorf("base step requires at least 1 prompt file in config")
 		}
 		templateName := cfg.PromptFiles[0]
 		log.Printf("Executing BASE step using template: %s", templateName)
 		templateContent, err := prompts.LoadTemplate(templateName)
 		if err != nil {
-			return "", fmt.Errorf("base workflow error loading template %s: %w", templateName, err)
+			return "", nil, fmt.Errorf("base workflow error loading template %s: %w", templateName, err)
 		}
 		formattedSystemPrompt, err := prompts.FormatInstructionsPrompt(templateContent, baseSystemPromptContext)
 		if err != nil {
-			return "", fmt.Errorf("base workflow error formatting system prompt: %w", err)
+			return "", nil, fmt.Errorf("base workflow error formatting system prompt: %w", err)
 		}
 
-		llmResponse, err := llm.CallChatCompletion(ctx, formattedSystemPrompt, userMessageContent, apiKey, llmModel, 0.1)
+		llmResponse, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: formattedSystemPrompt, UserPrompt: userMessageContent, APIKey: apiKey, Model: llmModel, Temperature: 0.1, Schema: outputSchema(cfg)})
 		if err != nil {
-			return "", fmt.Errorf("base workflow error during LLM call: %w", err)
+			return "", nil, fmt.Errorf("base workflow error during LLM call: %w", err)
 		}
-		finalRawResponse = llmResponse
+		finalRawResponse = llmResponse.Content
+		finalPrompt = formattedSystemPrompt
+		recordAnsweringModel(runInfo, llmResponse)
 
-	} else if cfg.LLMStep == config.StepValidate {
+	} else if step == config.StepValidate {
 		// --- VALIDATE ---
 		if len(cfg.PromptFiles) < 2 {
-			return "", fmt.Errorf("validation step requires 2 prompt files in config, found %d", len(cfg.PromptFiles))
+			return "", nil, fmt.Errorf("validation step requires 2 prompt files in config, found %d", len(cfg.PromptFiles))
 		}
 		baseTemplateName := cfg.PromptFiles[0]
 		validateTemplateName := cfg.PromptFiles[1]
@@ -126,39 +201,64 @@ func executeWorkflow(
 		// Load and Format BASE Prompt's System Instructions
 		baseTemplateContent, err := prompts.LoadTemplate(baseTemplateName)
 		if err != nil {
-			return "", fmt.Errorf("validate workflow error loading base template %s: %w", baseTemplateName, err)
+			return "", nil, fmt.Errorf("validate workflow error loading base template %s: %w", baseTemplateName, err)
 		}
 		baseFormattedSystemPrompt, err := prompts.FormatInstructionsPrompt(baseTemplateContent, baseSystemPromptContext)
 		if err != nil {
-			return "", fmt.Errorf("validate workflow error formatting base system prompt: %w", err)
+			return "", nil, fmt.Errorf("validate workflow error formatting base system prompt: %w", err)
+		}
+
+		extractTrial, err := lookupTrialExtractor(cfg)
+		if err != nil {
+			return "", nil, err
 		}
 
 		// --- Trials ---
-		numTrials := 3
-		expertResponses := []string{}
-		for i := 0; i < numTrials; i++ {
-			log.Printf("Validation trial %d/%d", i + 1, numTrials)
+		// Trials run concurrently; results keep trial order so the consolidation prompt is deterministic.
+		// In ensemble mode trial i goes to EnsembleModels[i % len], otherwise every trial uses llmModel.
+		numTrials := cfg.Trials
+		if numTrials <= 0 {
+			numTrials = defaultValidateTrials
+			if len(cfg.EnsembleModels) > 0 {
+				numTrials = len(cfg.EnsembleModels)
+			}
+		}
+		log.Printf("Running %d validation trials", numTrials)
+		trialResults, err := runTrials(ctx, numTrials, cfg.TrialConcurrency, func(ctx context.Context, i int) (similarity.Candidate, error) {
+			trialModel := llmModel
+			if len(cfg.EnsembleModels) > 0 {
+				trialModel = cfg.EnsembleModels[i%len(cfg.EnsembleModels)]
+			}
 			// Use base system prompt and formatted user input for trials
-			resp, err := llm.CallChatCompletion(ctx, baseFormattedSystemPrompt, userMessageContent, apiKey, llmModel, 0.5)
+			resp, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: baseFormattedSystemPrompt, UserPrompt: userMessageContent, APIKey: apiKey, Model: trialModel, Temperature: 0.5, Schema: outputSchema(cfg)})
 			if err != nil {
-				log.Printf("Warning: Validation trial %d failed: %v", i+1, err)
-				continue
+				return similarity.Candidate{}, fmt.Errorf("%s: %w", trialModel, err)
 			}
 
-			// Parse the specific result from the trial response based on the base prompt's structure.
-			// ASSUMPTION: All base prompts for VALIDATE workflows output their main result after the last '####'.
-			// This might need adjustment if base prompts output dictionaries (like attack_steps_base).
-			// For now, using parseFinalStepResponse as done for damage scenario.
-			trialResult, err := parseFinalStepResponse(resp, "####")
+			// Parse the specific result from the trial response with the analysis' extractor
+			trialResult, err := extractTrial(cfg, resp)
 			if err != nil {
-				log.Printf("Warning: Failed to parse result from trial %d using '####': %v", i+1, err)
-				continue
+				return similarity.Candidate{}, fmt.Errorf("%s: %w", trialModel, err)
 			}
-			if trialResult != "" {
-				expertResponses = append(expertResponses, trialResult)
-				log.Printf("Trial %d result added.", i+1)
-			} else {
-				log.Printf("Warning: Parsed empty result from trial %d.", i+1)
+			if trialResult == "" {
+				return similarity.Candidate{}, fmt.Errorf("%s: parsed empty result", trialModel)
+			}
+			answeredBy := llm.ModelSpec{Provider: resp.Provider, Model: resp.Model}.String()
+			return similarity.Candidate{Model: answeredBy, Result: trialResult}, nil
+		})
+		if errors.Is(err, errAllTrialsFailed) {
+			// Consolidation still gets to answer on its own, as it always has
+			log.Printf("Warning: %v", err)
+		} else if err != nil {
+			return "", nil, fmt.Errorf("validate workflow error during trials: %w", err)
+		}
+		expertResponses := []string{}
+		for i, res := range trialResults {
+			if res != nil {
+				expertResponses = append(expertResponses, res.Result)
+				res.Expert = len(expertResponses) // Matches the numbering in the consolidation prompt
+				runInfo.Candidates = append(runInfo.Candidates, *res)
+				log.Printf("Trial %d result from %s added.", i+1, res.Model)
 			}
 		}
 
@@ -166,81 +266,167 @@ func executeWorkflow(
 		aggregatedExpertsRes := "No valid responses generated in trials."
 		if len(expertResponses) > 0 {
 			// Add numbering like Python
-			var sb strings.Builder
-			for i, resp := range expertResponses {
-				sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, resp))
-			}
-			aggregatedExpertsRes = strings.TrimSpace(sb.String())
+			aggregatedExpertsRes = formatExpertResponses(expertResponses)
 		} else {
 			log.Println("Warning: All validation trials failed or produced no parsable result.")
 		}
 
-		// Prepare context specifically for the validation template
-		// Include fields referenced by the specific validation template being used
-		validationPromptContext := map[string]any{
-			"system_type":       	systemInfo[config.SystemType], 	// Use "system_type" key
-			"asset":            	inputData.Asset,               	// Use "asset" key
-			"category":         	inputData.Category,            	// Use "category" key
-			"property":         	inputData.Property,            	// Use "property" key
-			"asset_description": 	inputData.AssetDescription,    	// Use "asset_description" key
-			"threat":           	inputData.Threat,              	// Use "threat" key? Check template
-			"threat_scenario":   	inputData.ThreatScenario,      	// Use "threat_scenario" key? Check template
-			"attack_vector":     	inputData.AttackVector,        	// Use "attack_vector" key? Check template
-			"experts_res":       	aggregatedExpertsRes,        	// Use "experts_res" key
+		// Prepare context specifically for the validation template: the keys it references,
+		// taken from the base prompt context plus the aggregated expert answers
+		contextKeys := cfg.ConsolidationContextKeys
+		if len(contextKeys) == 0 {
+			contextKeys = defaultConsolidationContextKeys
+		}
+		validationPromptContext := make(map[string]any, len(contextKeys))
+		for _, key := range contextKeys {
+			if key == config.ExpertsRes {
+				validationPromptContext[key] = aggregatedExpertsRes
+			} else if val, ok := baseSystemPromptContext[key]; ok {
+				validationPromptContext[key] = val
+			} else {
+				log.Printf("Warning: Consolidation context key '%s' is not available for %s", key, cfg.AnalysisType)
+			}
 		}
 
 		// Load and format validation prompt (treating it as user message for the final call)
 		log.Printf("Executing validation consolidation using template: %s", validateTemplateName)
 		validateTemplateContent, err := prompts.LoadTemplate(validateTemplateName)
 		if err != nil {
-			return "", fmt.Errorf("validate workflow error loading validate template %s: %w", validateTemplateName, err)
+			return "", nil, fmt.Errorf("validate workflow error loading validate template %s: %w", validateTemplateName, err)
 		}
 		validateFormattedUserPrompt, err := prompts.FormatInstructionsPrompt(validateTemplateContent, validationPromptContext)
 		if err != nil {
-			return "", fmt.Errorf("validate workflow error formatting validate prompt: %w", err)
+			return "", nil, fmt.Errorf("validate workflow error formatting validate prompt: %w", err)
 		}
 
 		// Define the system prompt for the final consolidation call
-		finalSystemPrompt := "You are an automotive cybersecurity expert tasked with consolidating potential damage scenarios or attack steps based on expert input and providing the single most correct one formatted as instructed." // Make this more generic?
+		finalSystemPrompt := cfg.ConsolidationSystemPrompt
+		if finalSystemPrompt == "" {
+			finalSystemPrompt = defaultConsolidationSystemPrompt
+		}
+		if len(cfg.EnsembleModels) > 0 {
+			finalSystemPrompt += " " + ensembleConsolidationNote
+		}
 
 		log.Printf("Final User Prompt: %s", validateFormattedUserPrompt)
 		// Final LLM call for consolidation
-		llmResponse, err := llm.CallChatCompletion(ctx, finalSystemPrompt, validateFormattedUserPrompt, apiKey, llmModel, 0.1)
+		llmResponse, err := llm.Chat(ctx, llm.ChatRequest{SystemPrompt: finalSystemPrompt, UserPrompt: validateFormattedUserPrompt, APIKey: apiKey, Model: llmModel, Temperature: 0.1, Schema: outputSchema(cfg)})
+		if err != nil {
+			return "", nil, fmt.Errorf("validate workflow error during consolidation LLM call: %w", err)
+		}
+		finalRawResponse = llmResponse.Content
+		finalPrompt = validateFormattedUserPrompt
+		recordAnsweringModel(runInfo, llmResponse)
+
+	} else if step == config.StepReflect {
+		// --- REFLECT ---
+		if len(cfg.PromptFiles) < 1 {
+			return "", nil, fmt.Errorf("reflect step requires at least 1 prompt file in config")
+		}
+		templateName := cfg.PromptFiles[0]
+		log.Printf("Executing REFLECT step using template: %s", templateName)
+		templateContent, err := prompts.LoadTemplate(templateName)
+		if err != nil {
+			return "", nil, fmt.Errorf("reflect workflow error loading template %s: %w", templateName, err)
+		}
+		formattedSystemPrompt, err := prompts.FormatInstructionsPrompt(templateContent, baseSystemPromptContext)
+		if err != nil {
+			return "", nil, fmt.Errorf("reflect workflow error formatting system prompt: %w", err)
+		}
+
+		finalRawResponse, err = executeReflect(ctx, cfg, systemInfo, formattedSystemPrompt, userMessageContent, apiKey, llmModel, runInfo)
 		if err != nil {
-			return "", fmt.Errorf("validate workflow error during consolidation LLM call: %w", err)
+			return "", nil, fmt.Errorf("reflect workflow error: %w", err)
+		}
+		finalPrompt = formattedSystemPrompt
+
+	} else if step == config.StepAgent {
+		// --- AGENT ---
+		if len(cfg.PromptFiles) < 1 {
+			return "", nil, fmt.Errorf("agent step requires at least 1 prompt file in config")
+		}
+		templateName := cfg.PromptFiles[0]
+		log.Printf("Executing AGENT step using template: %s", templateName)
+		templateContent, err := prompts.LoadTemplate(templateName)
+		if err != nil {
+			return "", nil, fmt.Errorf("agent workflow error loading template %s: %w", templateName, err)
+		}
+		formattedSystemPrompt, err := prompts.FormatInstructionsPrompt(templateContent, baseSystemPromptContext)
+		if err != nil {
+			return "", nil, fmt.Errorf("agent workflow error formatting system prompt: %w", err)
+		}
+
+		finalRawResponse, err = executeAgent(ctx, cfg, formattedSystemPrompt, userMessageContent, apiKey, llmModel, runInfo)
+		if err != nil {
+			return "", nil, fmt.Errorf("agent workflow error: %w", err)
+		}
+		finalPrompt = formattedSystemPrompt
+
+	} else if step == config.StepSelfConsistency {
+		// --- SELF-CONSISTENCY ---
+		if len(cfg.PromptFiles) < 1 {
+			return "", nil, fmt.Errorf("self-consistency step requires at least 1 prompt file in config")
+		}
+		templateName := cfg.PromptFiles[0]
+		log.Printf("Executing SELF-CONSISTENCY step using template: %s", templateName)
+		templateContent, err := prompts.LoadTemplate(templateName)
+		if err != nil {
+			return "", nil, fmt.Errorf("self-consistency workflow error loading template %s: %w", templateName, err)
+		}
+		formattedSystemPrompt, err := prompts.FormatInstructionsPrompt(templateContent, baseSystemPromptContext)
+		if err != nil {
+			return "", nil, fmt.Errorf("self-consistency workflow error formatting system prompt: %w", err)
+		}
+
+		finalRawResponse, err = executeSelfConsistency(ctx, cfg, formattedSystemPrompt, userMessageContent, apiKey, llmModel, runInfo)
+		if err != nil {
+			return "", nil, fmt.Errorf("self-consistency workflow error: %w", err)
 		}
-		finalRawResponse = llmResponse
 
 	} else {
-		return "", fmt.Errorf("unknown LLM step type in config: %s", cfg.LLMStep)
+		return "", nil, fmt.Errorf("unknown LLM step type in config: %s", step)
+	}
+
+	// Keep the reasoning steps for reviewers; self-consistency already picked them from a representative sample
+	if runInfo.Rationale == nil {
+		runInfo.Rationale = extractRationale(finalRawResponse, finalPrompt)
 	}
 
 	// Return the raw response string - specific parsing happens in the calling workflow func
-	return finalRawResponse, nil
+	return finalRawResponse, runInfo, nil
+}
+
+// formatUserInput renders the analysis' DataKeys of the input as the user message.
+func formatUserInput(cfg *config.ModelConfig, inputData similarity.InputData) (string, error) {
+	userInputMap := make(map[string]any)
+	// Include only the keys relevant as direct input trigger, mirroring Python's approach more closely
+	// This might vary slightly per prompt, but often includes asset/desc/property etc.
+	// Let's use the keys specified in cfg.DataKeys as a basis for the user input context map.
+	inputDataMapBytes, _ := json.Marshal(inputData)
+	var inputDataMap map[string]any
+	_ = json.Unmarshal(inputDataMapBytes, &inputDataMap)
+
+	for _, key := range cfg.DataKeys { // Use DataKeys from config
+		if val, ok := inputDataMap[key]; ok && val != "" {
+			userInputMap[key] = val
+		}
+	}
+	// Special handling for attack_vector definition if needed (as in Python api.py _create_user_msg)
+	// This might need to be handled *before* this helper or passed in differently if critical
+	// For now, we assume the base userInputMap is sufficient for FormatUserMessage
+
+	userMessageContent, err := prompts.FormatUserMessage(userInputMap)
+	if err != nil {
+		return "", fmt.Errorf("failed to format base user message: %w", err)
+	}
+	return userMessageContent, nil
 }
 
-// Regex to find potential JSON objects or arrays in the text
-var jsonObjectRegex = regexp.MustCompile(`(?s)\{.*?\}`) // Non-greedy match for {}
-// var jsonArrayRegex = regexp.MustCompile(`(?s)\[.*?\]`)  // Non-greedy match for []
-
-// cleanJSONString attempts to clean up common issues in LLM-generated JSON-like strings
-func cleanJSONString(raw string) string {
-	// Remove markdown code fences
-	cleaned := strings.TrimPrefix(raw, "```json")
-	cleaned = strings.TrimPrefix(cleaned, "```")
-	cleaned = strings.TrimSuffix(cleaned, "```")
-	// Replace Python-style None/True/False if necessary (use with caution)
-	// cleaned = strings.ReplaceAll(cleaned, "None", "null")
-	// cleaned = strings.ReplaceAll(cleaned, "True", "true")
-	// cleaned = strings.ReplaceAll(cleaned, "False", "false")
-	// Replace single quotes with double quotes (common LLM mistake)
-	cleaned = strings.ReplaceAll(cleaned, "'", "\"")
-	// Remove trailing commas before closing braces/brackets (more complex regex needed for reliability)
-	// Basic attempt:
-	re := regexp.MustCompile(`,\s*([}\]])`)
-	cleaned = re.ReplaceAllString(cleaned, "$1")
-
-	return strings.TrimSpace(cleaned)
+// recordAnsweringModel notes in the run info which model produced the final answer.
+func recordAnsweringModel(runInfo *similarity.RunInfo, resp *llm.ChatResponse) {
+	runInfo.Model = llm.ModelSpec{Provider: resp.Provider, Model: resp.Model}.String()
+	runInfo.FallbackUsed = resp.FallbackUsed
+	runInfo.StructuredOutput = resp.Structured
 }
 
 // parseFinalStepResponse extracts the content after the last specified delimiter.
@@ -322,52 +508,37 @@ func parseDelimitedJSON(responseText string, startDelimiter string, endDelimiter
 
 
 // parseDictResponse - Modify to handle !!!! delimiter case more strictly
+// Dictionaries are read with the tolerant literal parser, so JSON, JSON5 and Python-style dicts
+// (single quotes, True/None, trailing commas) all parse without rewriting the text.
 func parseDictResponse(responseText string, delimiter string) (map[string]any, error) {
-	var jsonStr string
+	var segment string
 	var err error
 
 	// Special handling for !!!! delimiter based on feasibility prompt
 	if delimiter == "!!!!" {
-		jsonStr, err = parseDelimitedJSON(responseText, "!!!!", "!!!!")
+		segment, err = parseDelimitedJSON(responseText, "!!!!", "!!!!")
 		if err != nil {
 			// If we expect !!!!{...}!!!!, and can't find it, it's a parsing failure.
 			// Don't fall back to other methods for this specific case.
 			log.Printf("Failed to extract content between '!!!!' delimiters: %v. Raw Response: '%s'", err, responseText)
 			return nil, fmt.Errorf("could not find content between required '!!!!' delimiters: %w", err)
 		}
-		// Proceed directly to cleaning and unmarshalling jsonStr if found between delimiters
 	} else if delimiter == "####" { // Handle standard #### cases
 		// Try last segment first
-		jsonStr, err = parseFinalStepResponse(responseText, delimiter)
-		if err != nil {
-			log.Printf("Delimiter '%s' not found. Will search entire response for JSON object.", delimiter)
-			// Fallback: Search the entire response for a JSON object
-			jsonStr = jsonObjectRegex.FindString(responseText)
-			if jsonStr == "" {
-				log.Printf("Warning: No JSON object structure found in response via regex. Raw Response: '%s'", responseText)
-				return nil, fmt.Errorf("no JSON object found in LLM response using any method")
-			}
-		}
+		segment, _ = parseFinalStepResponse(responseText, delimiter)
 	} else {
-		// Default or unknown delimiter: just use regex search
-		log.Printf("Unknown delimiter '%s', attempting regex search for JSON object.", delimiter)
-		jsonStr = jsonObjectRegex.FindString(responseText)
-		if jsonStr == "" {
-			log.Printf("Warning: No JSON object structure found in response via regex. Raw Response: '%s'", responseText)
-			return nil, fmt.Errorf("no JSON object found in LLM response using regex")
-		}
+		// Default or unknown delimiter: search the whole response
+		log.Printf("Unknown delimiter '%s', searching entire response for an object.", delimiter)
+		segment = responseText
 	}
 
-	// Clean and Unmarshal the extracted/found jsonStr
-	cleanedJSON := cleanJSONString(jsonStr)
-	if cleanedJSON == "" {
-		return nil, fmt.Errorf("extracted JSON content was empty after cleaning (original extracted: '%s')", jsonStr)
+	resultDict, err := parser.ParseObject(segment)
+	if err != nil && segment != responseText && delimiter != "!!!!" {
+		log.Printf("No dictionary in final '%s' segment (%v). Searching entire response.", delimiter, err)
+		resultDict, err = parser.ParseObject(responseText)
 	}
-
-	var resultDict map[string]any
-	err = json.Unmarshal([]byte(cleanedJSON), &resultDict)
 	if err != nil {
-		log.Printf("Error unmarshalling JSON response. Cleaned text: '%s', Original extracted: '%s', Raw Response: '%s'", cleanedJSON, jsonStr, responseText)
+		log.Printf("Error parsing dictionary from LLM response: %v. Extracted: '%s', Raw Response: '%s'", err, segment, responseText)
 		return nil, fmt.Errorf("failed to parse dictionary from LLM response: %w", err)
 	}
 	return resultDict, nil
@@ -52,7 +52,7 @@ func (m *DBManager) GetDB(companyID string) (*gorm.DB, error) {
     }
 
     // Auto-migrate schema (for development only)
-    newDB.AutoMigrate(&models.Project{}, &models.User{})
+    newDB.AutoMigrate(&models.Project{}, &models.User{}, &models.LLMCacheEntry{}, &models.ProjectItem{})
 
     m.dbs[companyID] = newDB
     return newDB, nil
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantCacheStore is an llm.CacheStore backed by the calling tenant's database.
+// Calls without a tenant in the context are never cached.
+type TenantCacheStore struct {
+	Manager *DBManager
+}
+
+func (s *TenantCacheStore) tenantDB(ctx context.Context) (*gorm.DB, error) {
+	tenantID, _ := llm.CallerFromContext(ctx)
+	if tenantID == "" {
+		return nil, nil
+	}
+	tenantDB, err := s.Manager.GetDB(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("llm cache: %w", err)
+	}
+	return tenantDB.WithContext(ctx), nil
+}
+
+func (s *TenantCacheStore) Get(ctx context.Context, key string) (string, bool, error) {
+	tenantDB, err := s.tenantDB(ctx)
+	if err != nil || tenantDB == nil {
+		return "", false, err
+	}
+
+	var entry models.LLMCacheEntry
+	err = tenantDB.Where("key = ? AND (expires_at IS NULL OR expires_at > ?)", key, time.Now()).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return entry.Response, true, nil
+}
+
+func (s *TenantCacheStore) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	tenantDB, err := s.tenantDB(ctx)
+	if err != nil || tenantDB == nil {
+		return err
+	}
+
+	entry := models.LLMCacheEntry{Key: key, Response: value}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		entry.ExpiresAt = &expiresAt
+	}
+	return tenantDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"response", "expires_at", "updated_at"}),
+	}).Create(&entry).Error
+}
+
+// InitLLMCache configures the LLM response cache from the environment:
+// LLM_CACHE ("memory", "db" or empty to disable), LLM_CACHE_TTL (e.g. "24h") and, for the
+// memory store, LLM_CACHE_MAX_ENTRIES.
+func InitLLMCache() {
+	mode := os.Getenv("LLM_CACHE")
+	if mode == "" {
+		log.Println("LLM response cache disabled")
+		return
+	}
+
+	ttl := 24 * time.Hour
+	if raw := os.Getenv("LLM_CACHE_TTL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Printf("Warning: invalid LLM_CACHE_TTL %q, using %s: %v", raw, ttl, err)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	var store llm.CacheStore
+	switch mode {
+	case "memory":
+		maxEntries := llm.DefaultMemoryCacheEntries
+		if raw := os.Getenv("LLM_CACHE_MAX_ENTRIES"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				log.Printf("Warning: invalid LLM_CACHE_MAX_ENTRIES %q, using %d", raw, maxEntries)
+			} else {
+				maxEntries = parsed
+			}
+		}
+		store = llm.NewMemoryCacheStoreWithLimit(maxEntries)
+	case "db":
+		store = &TenantCacheStore{Manager: DBS_Manager}
+	default:
+		log.Printf("Warning: unknown LLM_CACHE mode %q, cache disabled", mode)
+		return
+	}
+
+	llm.ConfigureCache(llm.CacheConfig{Enabled: true, TTL: ttl, Store: store, MaxTemperature: 0.2})
+	log.Printf("LLM response cache enabled (store: %s, ttl: %s)", mode, ttl)
+}
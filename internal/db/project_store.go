@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/workflows"
+	"github.com/amir-saatchi/rest-api/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantProjectStore is a workflows.ProjectStore backed by the calling tenant's database.
+// Calls without a tenant in the context have no project data.
+type TenantProjectStore struct {
+	Manager *DBManager
+}
+
+func (s *TenantProjectStore) tenantDB(ctx context.Context, projectID string) (*gorm.DB, uuid.UUID, error) {
+	id, err := uuid.Parse(projectID)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("invalid project id %q: %w", projectID, err)
+	}
+	tenantID, _ := llm.CallerFromContext(ctx)
+	if tenantID == "" {
+		return nil, id, nil
+	}
+	tenantDB, err := s.Manager.GetDB(tenantID)
+	if err != nil {
+		return nil, id, fmt.Errorf("project data: %w", err)
+	}
+	return tenantDB.WithContext(ctx), id, nil
+}
+
+func (s *TenantProjectStore) Lookup(ctx context.Context, projectID, kind, query string, limit int) ([]map[string]any, error) {
+	tenantDB, id, err := s.tenantDB(ctx, projectID)
+	if err != nil || tenantDB == nil {
+		return nil, err
+	}
+
+	q := tenantDB.Model(&models.ProjectItem{}).Where("project_id = ? AND kind = ?", id, kind)
+	for _, word := range strings.Fields(query) {
+		q = q.Where("data::text ILIKE ?", "%"+escapeLike(word)+"%")
+	}
+	var rows []models.ProjectItem
+	if err := q.Order("updated_at DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		var item map[string]any
+		if err := json.Unmarshal([]byte(row.Data), &item); err != nil {
+			log.Printf("Warning: skipping unreadable %s item %s of project %s: %v", kind, row.Key, projectID, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (s *TenantProjectStore) Record(ctx context.Context, projectID, kind, key string, item map[string]any) error {
+	tenantDB, id, err := s.tenantDB(ctx, projectID)
+	if err != nil || tenantDB == nil {
+		return err
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	row := models.ProjectItem{ProjectID: id, Kind: kind, Key: key, Data: string(data)}
+	return tenantDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}, {Name: "kind"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
+	}).Create(&row).Error
+}
+
+// escapeLike escapes the LIKE wildcards in s, so they match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// InitProjectStore lets analyses run for a project look up and record the project's data in the
+// tenant databases.
+func InitProjectStore() {
+	workflows.ConfigureProjectStore(&TenantProjectStore{Manager: DBS_Manager})
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	"github.com/amir-saatchi/rest-api/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errNoTenant is returned when tenant shots are changed outside a tenant request.
+var errNoTenant = errors.New("tenant shots need a company_id")
+
+// maxHNSWDimensions is the largest vector pgvector can build an HNSW index on.
+const maxHNSWDimensions = 2000
+
+// TenantShotStore is a similarity.TenantShotStore backed by the calling tenant's database, where
+// shots are searched with pgvector. Every tenant's shots live in its own database, so no query can
+// reach another tenant's; calls without a tenant in the context have no shots.
+type TenantShotStore struct {
+	Manager *DBManager
+
+	migrated sync.Map // Tenant ID -> true once its table exists
+	indexed  sync.Map // "<tenant>/<dimensions>" -> true once its HNSW index exists
+}
+
+// tenantDB returns the database of the tenant in ctx, creating the shot table on first use.
+func (s *TenantShotStore) tenantDB(ctx context.Context) (*gorm.DB, string, error) {
+	tenantID, _ := llm.CallerFromContext(ctx)
+	if tenantID == "" {
+		return nil, "", errNoTenant
+	}
+	tenantDB, err := s.Manager.GetDB(tenantID)
+	if err != nil {
+		return nil, "", fmt.Errorf("tenant shots: %w", err)
+	}
+	tenantDB = tenantDB.WithContext(ctx)
+	if _, ok := s.migrated.Load(tenantID); !ok {
+		if err := tenantDB.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+			return nil, "", fmt.Errorf("tenant shots need the pgvector extension: %w", err)
+		}
+		if err := tenantDB.AutoMigrate(&models.ReferenceShot{}); err != nil {
+			return nil, "", fmt.Errorf("tenant shots: %w", err)
+		}
+		s.migrated.Store(tenantID, true)
+	}
+	return tenantDB, tenantID, nil
+}
+
+// ensureIndex creates the HNSW index over the shots of one dimension. The column holds vectors of
+// any dimension, so the index casts them and covers only rows of that dimension.
+func (s *TenantShotStore) ensureIndex(tenantDB *gorm.DB, tenantID string, dimensions int) {
+	key := tenantID + "/" + strconv.Itoa(dimensions)
+	if _, ok := s.indexed.Load(key); ok || dimensions > maxHNSWDimensions {
+		return
+	}
+	err := tenantDB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_reference_shots_hnsw_%[1]d ON reference_shots USING hnsw ((embedding::vector(%[1]d)) vector_cosine_ops) WHERE dimensions = %[1]d", dimensions)).Error
+	if err != nil {
+		log.Printf("Warning: no HNSW index on %d-dimensional shots of company_id %s, searches scan them: %v", dimensions, tenantID, err)
+		return
+	}
+	s.indexed.Store(key, true)
+}
+
+type shotRow struct {
+	ID         string
+	Data       string
+	Embedding  models.Vector
+	Similarity float64
+}
+
+func (s *TenantShotStore) SearchShots(ctx context.Context, analysisType string, spec similarity.EmbeddingSpec, query []float32, k int) ([]similarity.ResultWithScore, error) {
+	if tenantID, _ := llm.CallerFromContext(ctx); tenantID == "" {
+		return nil, nil
+	}
+	tenantDB, _, err := s.tenantDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The dimension is spelled out so the planner can match the partial HNSW index
+	distance := fmt.Sprintf("embedding::vector(%[1]d) <=> ?::vector(%[1]d)", spec.Dimensions)
+	q := models.Vector(query)
+	var rows []shotRow
+	err = tenantDB.Raw("SELECT id, data, embedding, 1 - ("+distance+") AS similarity FROM reference_shots"+
+		" WHERE analysis_type = ? AND model = ? AND dimensions = "+strconv.Itoa(spec.Dimensions)+
+		" ORDER BY "+distance+" LIMIT ?", q, analysisType, spec.Model, q, k).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]similarity.ResultWithScore, 0, len(rows))
+	for _, row := range rows {
+		var data similarity.ReferenceData
+		if err := json.Unmarshal([]byte(row.Data), &data); err != nil {
+			log.Printf("Warning: skipping unreadable tenant shot %s: %v", row.ID, err)
+			continue
+		}
+		data.ID = row.ID
+		data.Embedding = row.Embedding
+		results = append(results, similarity.ResultWithScore{Data: data, Score: row.Similarity})
+	}
+	return results, nil
+}
+
+func (s *TenantShotStore) UpsertShots(ctx context.Context, analysisType string, spec similarity.EmbeddingSpec, shots []similarity.ReferenceData) error {
+	tenantDB, tenantID, err := s.tenantDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]models.ReferenceShot, len(shots))
+	for i, shot := range shots {
+		if len(shot.Embedding) != spec.Dimensions {
+			return fmt.Errorf("shot %s has a %d-dimensional embedding, expected %d", shot.ID, len(shot.Embedding), spec.Dimensions)
+		}
+		embedding := shot.Embedding
+		shot.Embedding = nil
+		data, err := json.Marshal(shot)
+		if err != nil {
+			return err
+		}
+		rows[i] = models.ReferenceShot{
+			AnalysisType: analysisType,
+			ID:           shot.ID,
+			Data:         string(data),
+			Model:        spec.Model,
+			Dimensions:   spec.Dimensions,
+			Embedding:    embedding,
+		}
+	}
+	err = tenantDB.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "analysis_type"}, {Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"data", "model", "dimensions", "embedding", "updated_at"}),
+		}).CreateInBatches(&rows, 100).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.ensureIndex(tenantDB, tenantID, spec.Dimensions)
+	return nil
+}
+
+func (s *TenantShotStore) DeleteShots(ctx context.Context, analysisType string, ids []string) (int, error) {
+	tenantDB, _, err := s.tenantDB(ctx)
+	if err != nil {
+		return 0, err
+	}
+	result := tenantDB.Where("analysis_type = ? AND id IN ?", analysisType, ids).Delete(&models.ReferenceShot{})
+	return int(result.RowsAffected), result.Error
+}
+
+// InitTenantShots configures tenant shot libraries from the environment: TENANT_SHOTS ("db" to
+// enable, empty to disable) and the library weights TENANT_SHOTS_WEIGHT and GLOBAL_SHOTS_WEIGHT
+// (1 each by default). The tenant databases need the pgvector extension.
+func InitTenantShots() {
+	switch mode := os.Getenv("TENANT_SHOTS"); mode {
+	case "":
+		log.Println("Tenant shot libraries disabled")
+		return
+	case "db":
+	default:
+		log.Printf("Warning: unknown TENANT_SHOTS mode %q, tenant shot libraries disabled", mode)
+		return
+	}
+
+	cfg := similarity.TenantShotsConfig{
+		Store:        &TenantShotStore{Manager: DBS_Manager},
+		TenantWeight: weightFromEnv("TENANT_SHOTS_WEIGHT"),
+		GlobalWeight: weightFromEnv("GLOBAL_SHOTS_WEIGHT"),
+	}
+	similarity.ConfigureTenantShots(cfg)
+	log.Printf("Tenant shot libraries enabled (tenant weight %g, global weight %g)", cfg.TenantWeight, cfg.GlobalWeight)
+}
+
+func weightFromEnv(name string) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 1
+	}
+	w, err := strconv.ParseFloat(raw, 64)
+	if err != nil || w < 0 {
+		log.Printf("Warning: invalid %s %q, using 1", name, raw)
+		return 1
+	}
+	return w
+}
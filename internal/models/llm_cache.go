@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LLMCacheEntry is a cached LLM response, stored in the tenant's own database.
+type LLMCacheEntry struct {
+	Key       string     `gorm:"primaryKey;size:320"` // "<tenant>/<sha256 of provider, model, temperature, prompts>"
+	Response  string     `gorm:"not null"`
+	ExpiresAt *time.Time `gorm:"index"` // nil means no expiry
+
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
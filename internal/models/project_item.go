@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProjectItem is an asset or analysis result of a project (e.g. one of its damage scenarios),
+// stored in the tenant's database for later analyses of the project to look up.
+type ProjectItem struct {
+	ProjectID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Kind      string    `gorm:"primaryKey;size:32"`  // workflows.ProjectAssets, workflows.ProjectDamageScenarios, ...
+	Key       string    `gorm:"primaryKey;size:255"` // Identifies the item within its kind; recording it again replaces it
+	Data      string    `gorm:"type:jsonb;not null"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
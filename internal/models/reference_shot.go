@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ReferenceShot is one of a tenant's own reference examples, stored in the tenant's database and
+// blended with the global reference library of its analysis when shots are picked.
+type ReferenceShot struct {
+	AnalysisType string `gorm:"primaryKey;size:64;index:idx_reference_shots_spec,priority:1"`
+	ID           string `gorm:"primaryKey;size:255"`
+	Data         string `gorm:"type:jsonb;not null"`                                         // similarity.ReferenceData without the embedding
+	Model        string `gorm:"size:128;not null;index:idx_reference_shots_spec,priority:2"` // Embedding model; only shots made like the query are searched
+	Dimensions   int    `gorm:"not null;index:idx_reference_shots_spec,priority:3"`
+	Embedding    Vector `gorm:"not null"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Vector is a pgvector column, exchanged with the database in its text form "[1,2,3]".
+type Vector []float32
+
+func (Vector) GormDataType() string {
+	return "vector"
+}
+
+// Value implements driver.Valuer.
+func (v Vector) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return v.String(), nil
+}
+
+// Scan implements sql.Scanner.
+func (v *Vector) Scan(src any) error {
+	var s string
+	switch src := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		s = src
+	case []byte:
+		s = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into a vector", src)
+	}
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return fmt.Errorf("invalid vector %q", s)
+	}
+	s = s[1 : len(s)-1]
+	if s == "" {
+		*v = Vector{}
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make(Vector, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return fmt.Errorf("invalid vector element %q: %w", p, err)
+		}
+		out[i] = float32(f)
+	}
+	*v = out
+	return nil
+}
+
+func (v Vector) String() string {
+	var b strings.Builder
+	b.Grow(len(v)*10 + 2)
+	b.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+
+	"github.com/amir-saatchi/rest-api/corelogic/llm"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	"github.com/amir-saatchi/rest-api/corelogic/workflows"
+	"github.com/amir-saatchi/rest-api/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// referenceDataPath returns the directory holding the *_reference.json files.
+func referenceDataPath() string {
+	if p := os.Getenv("REFERENCE_DATA_PATH"); p != "" {
+		return p
+	}
+	return "./data"
+}
+
+// maxLoggedReferenceIssues caps the issues logged per library by CheckReferenceData.
+const maxLoggedReferenceIssues = 5
+
+// CheckReferenceData checks the reference libraries of all analyses and logs what it finds.
+// It returns an error when any library has issues, for callers that refuse to start on bad data.
+func CheckReferenceData() error {
+	bad := 0
+	for _, report := range workflows.LintReferenceLibraries(referenceDataPath()) {
+		log.Printf("Reference check: %s", report.Summary())
+		for i, issue := range report.Issues {
+			if i == maxLoggedReferenceIssues {
+				log.Printf("  ... %d more; run \"reftool lint\" for all", len(report.Issues)-i)
+				break
+			}
+			log.Printf("  %s", issue)
+		}
+		if len(report.Issues) > 0 {
+			bad++
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d reference libraries have issues", bad)
+	}
+	return nil
+}
+
+type analysisRequest struct {
+	Input      similarity.InputData `json:"input"`
+	SystemInfo map[string]string    `json:"system_info"`
+	// Optional existing assets and scenarios of the project, keyed by kind (e.g. "assets"),
+	// for analyses whose agent step can look them up; without it, the data recorded in the
+	// tenant database for ?project_id is used
+	ProjectData workflows.StaticProjectData `json:"project_data,omitempty"`
+}
+
+// runAnalysis runs one TARA analysis workflow (e.g. model_damage_scenario) for the calling tenant/user.
+func runAnalysis(c *gin.Context) {
+	var req analysisRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx, ok := callerContext(c)
+	if !ok {
+		return
+	}
+	if c.Query("no_cache") == "true" {
+		ctx = llm.WithCacheBypass(ctx)
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		if _, err := uuid.Parse(projectID); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid project_id"})
+			return
+		}
+		ctx = workflows.WithProject(ctx, projectID)
+	}
+	if req.ProjectData != nil {
+		ctx = workflows.WithProjectData(ctx, req.ProjectData)
+	}
+	if step := c.Query("step"); step != "" {
+		// Opt in to another step the analysis offers, e.g. ?step=self_consistency
+		ctx = workflows.WithStep(ctx, step)
+	}
+	result, runInfo, err := workflows.RunAnalysis(ctx, c.Param("type"), req.Input, req.SystemInfo, referenceDataPath())
+	if errors.Is(err, workflows.ErrInvalidStep) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, workflows.ErrJudgeRejected) {
+		// The rejected result is returned too, so the caller can see what the judge objected to
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "result": result, "run": runInfo})
+		return
+	}
+	if err != nil {
+		abortWithLLMError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": result, "run": runInfo})
+}
+
+// callerContext returns the request context with the calling tenant and user, whose quotas the
+// request's LLM calls are charged to. The company_id must be a registered tenant, and a user_id is
+// required when the tenant has per-user limits, so leaving it out can't escape them. Otherwise it
+// aborts the request and returns false.
+func callerContext(c *gin.Context) (context.Context, bool) {
+	companyID, userID := c.Query("company_id"), c.Query("user_id")
+	tenant, ok := db.Tenants[companyID]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unknown company_id"})
+		return nil, false
+	}
+	if userID == "" && tenant.LLMLimits.PerUser != (llm.Limits{}) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "user_id required"})
+		return nil, false
+	}
+	return llm.WithCaller(c.Request.Context(), companyID, userID), true
+}
+
+// abortWithLLMError maps quota errors to 429/402, open circuit breakers to 503, results that keep
+// breaking their guardrails to 422 and everything else to 500.
+func abortWithLLMError(c *gin.Context, err error) {
+	var quotaErr *llm.QuotaError
+	if errors.As(err, &quotaErr) {
+		c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+		status := http.StatusTooManyRequests
+		if errors.Is(err, llm.ErrBudgetExceeded) {
+			status = http.StatusPaymentRequired
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": quotaErr.Error(), "limit": quotaErr.Limit, "scope": quotaErr.Scope})
+		return
+	}
+	if errors.Is(err, llm.ErrCircuitOpen) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, workflows.ErrGuardrailViolation) {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// llmCacheStats reports the LLM response cache hits and misses of the calling tenant.
+func llmCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, llm.GetCacheStats(c.Query("company_id")))
+}
+
+// reloadReferenceIndexes reloads the in-memory reference indexes; ?force=true reloads unchanged files too.
+// It requires the X-Admin-Token header to match ADMIN_TOKEN and is disabled when ADMIN_TOKEN is unset.
+func reloadReferenceIndexes(c *gin.Context) {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin token required"})
+		return
+	}
+	reloaded, err := similarity.ReloadReferenceIndexes(c.Query("force") == "true")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "reloaded": reloaded})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reloaded": reloaded})
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amir-saatchi/rest-api/corelogic/config"
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
+	"github.com/amir-saatchi/rest-api/corelogic/workflows"
+	"github.com/gin-gonic/gin"
+)
+
+type upsertShotsRequest struct {
+	Shots []similarity.ReferenceData `json:"shots"`
+}
+
+// upsertReferenceShots adds shots to, or replaces shots in, the calling tenant's own reference
+// library of an analysis (e.g. PUT /api/reference/model_damage_scenario/shots?company_id=...).
+func upsertReferenceShots(c *gin.Context) {
+	var req upsertShotsRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	analysisType := c.Param("type")
+	if _, err := config.AnalysisConfig(analysisType); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, ok := callerContext(c)
+	if !ok {
+		return
+	}
+	err := workflows.UpsertTenantShots(ctx, analysisType, req.Shots, referenceDataPath())
+	if err != nil {
+		abortWithShotsError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"upserted": len(req.Shots)})
+}
+
+// deleteReferenceShot removes a shot from the calling tenant's reference library of an analysis.
+func deleteReferenceShot(c *gin.Context) {
+	analysisType := c.Param("type")
+	if _, err := config.AnalysisConfig(analysisType); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, ok := callerContext(c)
+	if !ok {
+		return
+	}
+	deleted, err := similarity.DeleteTenantShots(ctx, analysisType, []string{c.Param("id")})
+	if err != nil {
+		abortWithShotsError(c, err)
+		return
+	}
+	if deleted == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "shot not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// abortWithShotsError maps invalid shots to 400 and disabled tenant shots to 503; embedding errors
+// are LLM errors.
+func abortWithShotsError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, workflows.ErrInvalidShots):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, similarity.ErrTenantShotsDisabled):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		abortWithLLMError(c, err)
+	}
+}
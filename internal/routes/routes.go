@@ -42,6 +42,12 @@ func NewRouter() *gin.Engine {
 
     router.POST("/logs", createLog)
 
+    router.POST("/api/analysis/:type", runAnalysis)
+    router.GET("/api/llm/cache/stats", llmCacheStats)
+    router.POST("/api/admin/reference/reload", reloadReferenceIndexes)
+    router.PUT("/api/reference/:type/shots", upsertReferenceShots)
+    router.DELETE("/api/reference/:type/shots/:id", deleteReferenceShot)
+
     return router
 }
 
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
+	"github.com/amir-saatchi/rest-api/corelogic/similarity"
 	"github.com/amir-saatchi/rest-api/internal/db"
 	"github.com/amir-saatchi/rest-api/internal/routes"
 )
@@ -11,7 +15,31 @@ import (
 func main() {
 	// Initialize the database
 	db.InitDB()
-		
+	db.InitLLMCache()
+	db.InitTenantShots()
+	db.InitProjectStore()
+
+	// Check the reference libraries; REFERENCE_CHECK_STRICT=true refuses to start on issues
+	if err := routes.CheckReferenceData(); err != nil {
+		if os.Getenv("REFERENCE_CHECK_STRICT") == "true" {
+			log.Fatalf("Reference check failed: %v", err)
+		}
+		log.Printf("Warning: %v", err)
+	}
+
+	// Reload reference indexes whose files change on disk (REFERENCE_WATCH_INTERVAL, e.g. "30s"; "0" disables)
+	watchInterval := 30 * time.Second
+	if v := os.Getenv("REFERENCE_WATCH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid REFERENCE_WATCH_INTERVAL %q: %v", v, err)
+		}
+		watchInterval = d
+	}
+	if watchInterval > 0 {
+		go similarity.WatchReferenceIndexes(context.Background(), watchInterval)
+	}
+
     // Initialize Gin router
 	router := routes.NewRouter()
 